@@ -2,10 +2,13 @@ package main
 
 import (
 	"beautybargains/internal/chat"
+	"context"
 	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"os"
 
 	"github.com/gosimple/slug"
 	"github.com/joho/godotenv"
@@ -14,18 +17,30 @@ import (
 
 func main() {
 
-	db, err := sql.Open("sqlite3", "main.db")
-	if err != nil {
+	funcName := flag.String("func", "list", "specify the function wou want to run")
+	dbPath := flag.String("db", "", "path to the sqlite database, defaults to DB_PATH or main.db")
+	flag.Parse()
+
+	// .env is optional, the same settings can come from the environment
+	if err := godotenv.Load(".env"); err != nil && !errors.Is(err, os.ErrNotExist) {
 		log.Fatal(err)
 	}
-	defer db.Close()
 
-	if err := godotenv.Load(".env"); err != nil {
+	if *dbPath == "" {
+		*dbPath = os.Getenv("DB_PATH")
+	}
+	if *dbPath == "" {
+		*dbPath = "main.db"
+	}
+
+	db, err := sql.Open("sqlite3", *dbPath)
+	if err != nil {
 		log.Fatal(err)
 	}
+	defer db.Close()
 
-	funcName := flag.String("func", "list", "specify the function wou want to run")
-	flag.Parse()
+	// brands rated before are answered from the cache instead of paying for them again
+	chat.Configure(chat.Config{Store: chat.NewSQLStore(db)})
 
 	funcs := map[string]func(db *sql.DB){
 		"update_brand_paths": updateBrandPaths,
@@ -76,7 +91,7 @@ func rateBrands(db *sql.DB) {
 			log.Fatal(err)
 		}
 
-		score, err := chat.ChatRateBrand(brandName)
+		score, err := chat.ChatRateBrand(context.Background(), brandName)
 		if err != nil {
 			tx.Rollback()
 			log.Println(err)
@@ -1,7 +1,15 @@
 package main
 
 import (
+	"beautybargains/internal/chat"
+	"beautybargains/internal/metrics"
+	"database/sql"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/seanomeara96/paginator"
 )
@@ -11,8 +19,9 @@ func (h *Handler) adminHandleGetDashboard(w http.ResponseWriter, r *http.Request
 	limit, offset, _ := paginator.Paginate(r, 50)
 
 	posts, err := h.service.getPosts(getPostParams{
-		Limit:  limit,
-		Offset: offset,
+		Limit:     limit,
+		Offset:    offset,
+		AnyStatus: true,
 	})
 	if err != nil {
 		return err
@@ -23,11 +32,11 @@ func (h *Handler) adminHandleGetDashboard(w http.ResponseWriter, r *http.Request
 		"Admin": true,
 	}
 
-	return h.render.Page(w, "admindashboard", data)
+	return h.render.Page(w, r, "admindashboard", data)
 }
 
 func (h *Handler) adminHandleGetSignIn(w http.ResponseWriter, r *http.Request) error {
-	return h.render.Page(w, "adminsignin", nil)
+	return h.render.Page(w, r, "adminsignin", nil)
 }
 
 func (h *Handler) adminHandleGetSignOut(w http.ResponseWriter, r *http.Request) error {
@@ -61,3 +70,469 @@ func (h *Handler) adminHandlePostSignIn(w http.ResponseWriter, r *http.Request)
 	http.Redirect(w, r, "/admin", http.StatusSeeOther)
 	return nil
 }
+
+func (h *Handler) handleGetMetrics(w http.ResponseWriter, r *http.Request) error {
+	metrics.Handler().ServeHTTP(w, r)
+	return nil
+}
+
+func (h *Handler) adminHandleListErrors(w http.ResponseWriter, r *http.Request) error {
+
+	limit, offset, _ := paginator.Paginate(r, 50)
+
+	groups, err := h.service.GetErrorGroups(limit, offset)
+	if err != nil {
+		return err
+	}
+
+	return h.render.Page(w, r, "adminerrors", map[string]any{
+		"PageTitle":       "Admin Page, errors",
+		"MetaDescription": "",
+		"Canonical":       r.URL.Path,
+		"ErrorGroups":     groups,
+		"Admin":           true,
+	})
+}
+
+func (h *Handler) adminHandleGetPromotions(w http.ResponseWriter, r *http.Request) error {
+	stats, err := h.service.GetPromoStats(0)
+	if err != nil {
+		return err
+	}
+
+	return h.render.Page(w, r, "adminpromotions", map[string]any{
+		"PageTitle":       "Admin Page, promotions",
+		"MetaDescription": "",
+		"Canonical":       r.URL.Path,
+		"PromoStats":      stats,
+		"Admin":           true,
+	})
+}
+
+func (h *Handler) adminHandleGetReview(w http.ResponseWriter, r *http.Request) error {
+	limit, offset, _ := paginator.Paginate(r, 50)
+
+	items, err := h.service.GetReviewQueue(limit, offset)
+	if err != nil {
+		return err
+	}
+
+	return h.render.Page(w, r, "adminreview", map[string]any{
+		"PageTitle":       "Admin Page, review",
+		"MetaDescription": "",
+		"Canonical":       r.URL.Path,
+		"Items":           items,
+		"Admin":           true,
+	})
+}
+
+func (h *Handler) adminHandlePostReview(w http.ResponseWriter, r *http.Request) error {
+	postID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid post id", http.StatusBadRequest)
+		return nil
+	}
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	var status string
+	switch r.Form.Get("decision") {
+	case "save":
+		status = postPending
+	case "approve":
+		status = postApproved
+	case "reject":
+		status = postRejected
+	default:
+		http.Error(w, "decision must be save, approve or reject", http.StatusBadRequest)
+		return nil
+	}
+
+	coupons, err := parseCouponRows(r.Form)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	edit := postEdit{
+		Description: r.Form.Get("description"),
+		Brands:      splitNameList(r.Form.Get("brands")),
+		Categories:  splitNameList(r.Form.Get("categories")),
+		Coupons:     coupons,
+	}
+	err = h.service.ReviewPost(postID, edit, status)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return nil
+	}
+	// the post has already left the queue, there is nothing to do but show what's left
+	if err != nil && !errors.Is(err, errPostNotPending) {
+		return err
+	}
+
+	http.Redirect(w, r, "/admin/review", http.StatusSeeOther)
+	return nil
+}
+
+func (h *Handler) adminHandleGetAnalysisQueue(w http.ResponseWriter, r *http.Request) error {
+	limit, offset, _ := paginator.Paginate(r, 50)
+
+	queue, err := h.service.GetAnalysisQueue(limit, offset)
+	if err != nil {
+		return err
+	}
+
+	return h.render.Page(w, r, "adminanalyses", map[string]any{
+		"PageTitle":       "Admin Page, analysis queue",
+		"MetaDescription": "",
+		"Canonical":       r.URL.Path,
+		"Queue":           queue,
+		"MaxAttempts":     h.service.retry.MaxAttempts,
+		"Admin":           true,
+	})
+}
+
+func (h *Handler) adminHandleRetryAnalysis(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid analysis id", http.StatusBadRequest)
+		return nil
+	}
+	if err := h.service.RetryAnalysis(id); err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/admin/analyses", http.StatusSeeOther)
+	return nil
+}
+
+func (h *Handler) adminHandleListPrompts(w http.ResponseWriter, r *http.Request) error {
+	return h.renderPrompts(w, r, Prompt{}, "")
+}
+
+// renderPrompts shows the versions of the offer prompt with a form for the next one, prefilled
+// with draft or the active version
+func (h *Handler) renderPrompts(w http.ResponseWriter, r *http.Request, draft Prompt, formErr string) error {
+	prompts, err := h.service.GetPrompts(offerPromptJob)
+	if err != nil {
+		return err
+	}
+	if draft.Template == "" {
+		for _, p := range prompts {
+			if p.Active {
+				draft = p
+			}
+		}
+	}
+
+	return h.render.Page(w, r, "adminprompts", map[string]any{
+		"PageTitle":       "Admin Page, prompts",
+		"MetaDescription": "",
+		"Canonical":       r.URL.Path,
+		"Prompts":         prompts,
+		"Draft":           draft,
+		"FormError":       formErr,
+		"MaxSample":       maxPromptSample,
+		"Admin":           true,
+	})
+}
+
+func (h *Handler) adminHandleCreatePrompt(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	model := strings.TrimSpace(r.Form.Get("model"))
+	p := Prompt{
+		Job:      offerPromptJob,
+		Template: r.Form.Get("template"),
+		Schema:   r.Form.Get("schema"),
+		Model:    sql.NullString{String: model, Valid: model != ""},
+		Notes:    strings.TrimSpace(r.Form.Get("notes")),
+	}
+	if err := p.validate(); err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return h.renderPrompts(w, r, p, err.Error())
+	}
+	if _, err := h.service.CreatePrompt(p); err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/admin/prompts", http.StatusSeeOther)
+	return nil
+}
+
+func (h *Handler) adminHandleActivatePrompt(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid prompt id", http.StatusBadRequest)
+		return nil
+	}
+	if err := h.service.ActivatePrompt(id); err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/admin/prompts", http.StatusSeeOther)
+	return nil
+}
+
+// adminHandleComparePrompt starts running a prompt against stored banners in the background.
+// It is a POST because every banner is a paid llm call unless the answer is cached.
+func (h *Handler) adminHandleComparePrompt(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid prompt id", http.StatusBadRequest)
+		return nil
+	}
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	sample, _ := strconv.Atoi(r.Form.Get("sample"))
+
+	prompt, err := h.service.GetPrompt(id)
+	if err != nil {
+		return err
+	}
+	started, err := h.service.StartPromptComparison(prompt, sample)
+	if err != nil {
+		return err
+	}
+	if !started {
+		http.Error(w, "this prompt is already being compared", http.StatusConflict)
+		return nil
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/prompts/%d/compare", id), http.StatusSeeOther)
+	return nil
+}
+
+// adminHandleGetPromptComparison shows the last comparison of a prompt as it fills in
+func (h *Handler) adminHandleGetPromptComparison(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid prompt id", http.StatusBadRequest)
+		return nil
+	}
+	prompt, err := h.service.GetPrompt(id)
+	if err != nil {
+		return err
+	}
+	comparisons, err := h.service.GetPromptComparisons(prompt)
+	if err != nil {
+		return err
+	}
+
+	return h.render.Page(w, r, "adminpromptcompare", map[string]any{
+		"PageTitle":       "Admin Page, compare prompt",
+		"MetaDescription": "",
+		"Canonical":       r.URL.Path,
+		"Prompt":          prompt,
+		"Comparisons":     comparisons,
+		"Running":         h.service.PromptComparisonRunning(id),
+		"Admin":           true,
+	})
+}
+
+func (h *Handler) adminHandleGetLLMSpend(w http.ResponseWriter, r *http.Request) error {
+	if h.service.llm == nil {
+		return errors.New("llm usage is not being recorded")
+	}
+
+	now := time.Now().UTC()
+	periods := []struct {
+		Name  string
+		Since time.Time
+	}{
+		{"Today", time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)},
+		{"This month", time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)},
+		{"All time", time.Time{}},
+	}
+
+	type periodSpend struct {
+		Name  string
+		Jobs  []chat.JobSpend
+		Total float64
+	}
+	spend := make([]periodSpend, 0, len(periods))
+	for _, p := range periods {
+		jobs, err := h.service.llm.SpendByJob(r.Context(), p.Since)
+		if err != nil {
+			return err
+		}
+		ps := periodSpend{Name: p.Name, Jobs: jobs}
+		for _, j := range jobs {
+			ps.Total += j.Cost
+		}
+		spend = append(spend, ps)
+	}
+
+	return h.render.Page(w, r, "adminllm", map[string]any{
+		"PageTitle":       "Admin Page, llm spend",
+		"MetaDescription": "",
+		"Canonical":       r.URL.Path,
+		"Spend":           spend,
+		"Admin":           true,
+	})
+}
+
+func (h *Handler) adminHandleListProducts(w http.ResponseWriter, r *http.Request) error {
+	limit, offset, _ := paginator.Paginate(r, 50)
+
+	products, err := h.service.GetProductSummaries(limit, offset)
+	if err != nil {
+		return err
+	}
+
+	return h.render.Page(w, r, "adminproducts", map[string]any{
+		"PageTitle":       "Admin Page, products",
+		"MetaDescription": "",
+		"Canonical":       r.URL.Path,
+		"Products":        products,
+		"Websites":        getWebsites(0, 0),
+		"Admin":           true,
+	})
+}
+
+// adminHandleAddProduct tracks a product page, it's crawled on the next run of the jobs
+func (h *Handler) adminHandleAddProduct(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	websiteID, err := strconv.Atoi(r.Form.Get("website_id"))
+	if err != nil {
+		http.Error(w, "invalid website id", http.StatusBadRequest)
+		return nil
+	}
+	website, err := getWebsiteByID(websiteID)
+	if err != nil {
+		http.Error(w, "unknown website", http.StatusBadRequest)
+		return nil
+	}
+	pageURL := strings.TrimSpace(r.Form.Get("url"))
+	if !sameSite(website.URL, pageURL) {
+		http.Error(w, "the product page must be an http(s) url on "+website.URL, http.StatusBadRequest)
+		return nil
+	}
+	if err := h.service.AddProduct(website.WebsiteID, pageURL); err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/admin/products", http.StatusSeeOther)
+	return nil
+}
+
+// scoredPost is a post on /admin/scoring with the signals behind its score
+type scoredPost struct {
+	scoreInput
+	Website Website
+	Signals scoreSignals
+}
+
+func (h *Handler) adminHandleGetScoring(w http.ResponseWriter, r *http.Request) error {
+	weights, err := h.service.GetScoreWeights()
+	if err != nil {
+		return err
+	}
+	inputs, err := h.service.GetTopScoredPosts(30)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	posts := make([]scoredPost, len(inputs))
+	for i, in := range inputs {
+		posts[i].scoreInput = in
+		posts[i].Website, _ = getWebsiteByID(in.WebsiteID)
+		posts[i].Signals = in.signals(h.service.scoreHalfLife, now)
+	}
+
+	return h.render.Page(w, r, "adminscoring", map[string]any{
+		"PageTitle":       "Admin Page, scoring",
+		"MetaDescription": "",
+		"Canonical":       r.URL.Path,
+		"Weights":         weights,
+		"Posts":           posts,
+		"HalfLife":        h.service.scoreHalfLife,
+		"Admin":           true,
+	})
+}
+
+// adminHandleSaveScoring saves the score weights, posts are rescored on the next run of the jobs
+func (h *Handler) adminHandleSaveScoring(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	var weights ScoreWeights
+	for name, weight := range map[string]*float64{
+		"retailer":   &weights.Retailer,
+		"brand":      &weights.Brand,
+		"discount":   &weights.Discount,
+		"recency":    &weights.Recency,
+		"engagement": &weights.Engagement,
+		"coupon":     &weights.Coupon,
+	} {
+		v, err := strconv.ParseFloat(strings.TrimSpace(r.Form.Get(name)), 64)
+		if err != nil || v < 0 || v > 100 {
+			http.Error(w, "the "+name+" weight must be a number from 0 to 100", http.StatusBadRequest)
+			return nil
+		}
+		*weight = v
+	}
+	if err := h.service.SaveScoreWeights(weights); err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/admin/scoring", http.StatusSeeOther)
+	return nil
+}
+
+// adminHandleGetClicks reports outbound clicks by retailer and post and edits affiliate templates
+func (h *Handler) adminHandleGetClicks(w http.ResponseWriter, r *http.Request) error {
+	since := time.Now().AddDate(0, 0, -clickReportDays)
+	byWebsite, err := h.service.GetClicksByWebsite(since)
+	if err != nil {
+		return err
+	}
+	posts, err := h.service.GetTopClickedPosts(since, 30)
+	if err != nil {
+		return err
+	}
+	templates, err := h.service.GetAffiliateTemplates()
+	if err != nil {
+		return err
+	}
+
+	return h.render.Page(w, r, "adminclicks", map[string]any{
+		"PageTitle":       "Admin Page, clicks",
+		"MetaDescription": "",
+		"Canonical":       r.URL.Path,
+		"Days":            clickReportDays,
+		"Websites":        byWebsite,
+		"Posts":           posts,
+		"Templates":       templates,
+		"Admin":           true,
+	})
+}
+
+// adminHandleSaveAffiliateTemplate sets a retailer's affiliate template, it applies from the next click
+func (h *Handler) adminHandleSaveAffiliateTemplate(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	websiteID, _ := strconv.Atoi(r.Form.Get("website_id"))
+	website, err := getWebsiteByID(websiteID)
+	if err != nil {
+		http.Error(w, "unknown website", http.StatusBadRequest)
+		return nil
+	}
+	template := strings.TrimSpace(r.Form.Get("template"))
+	example := sql.NullInt64{Int64: 1, Valid: true}
+	if _, err := applyAffiliate(website.URL, template, outboundTarget{PostID: example, CouponID: example, ProductID: example}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	if err := h.service.SaveAffiliateTemplate(website.WebsiteID, template); err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/admin/clicks", http.StatusSeeOther)
+	return nil
+}
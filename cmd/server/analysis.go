@@ -0,0 +1,312 @@
+package main
+
+import (
+	"beautybargains/internal/chat"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+/*
+New banners wait in analysis_queue until the llm has described them and the post is saved. The
+first attempt is made as soon as the banner is found; when it fails the banner is retried after
+the backoff, doubling each time up to maxRetryDelay, and marked dead after MaxAttempts. Dead
+banners stay in the queue, so they aren't picked up again by the scraper, until an admin retries
+them at /admin/analyses. Running out of llm budget defers a banner without using up an attempt.
+When the ocr fallback is configured it is used instead of deferring, and on the last attempt.
+
+Times are stored in UTC so they compare correctly as text.
+*/
+
+const (
+	analysisQueued = "queued"
+	analysisDead   = "dead"
+
+	maxRetryDelay = 24 * time.Hour
+	// analysisBatch caps how many due banners one run of retryAnalyses works through
+	analysisBatch = 50
+)
+
+// retryPolicy is how often and how quickly failed analyses are retried
+type retryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+}
+
+// delay is how long to wait after the given number of failed attempts
+func (p retryPolicy) delay(attempts int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempts && d < maxRetryDelay; i++ {
+		d *= 2
+	}
+	return min(d, maxRetryDelay)
+}
+
+// queuedAnalysis is a banner waiting to be analysed
+type queuedAnalysis struct {
+	ID          int
+	WebsiteID   int
+	Banner      BannerData
+	Image       StoredImage
+	Status      string
+	Attempts    int
+	NextAttempt time.Time
+	LastError   sql.NullString
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+const analysisColumns = `id, website_id, src_url, supporting_text, href,
+	image_hash, image_ext, image_width, image_height, image_phash,
+	status, attempts, next_attempt, last_error, created_at, updated_at`
+
+func scanQueuedAnalysis(row scannable) (queuedAnalysis, error) {
+	var q queuedAnalysis
+	var phash int64
+	err := row.Scan(
+		&q.ID, &q.WebsiteID, &q.Banner.Src, &q.Banner.SupportingText, &q.Banner.Href,
+		&q.Image.Hash, &q.Image.Ext, &q.Image.Width, &q.Image.Height, &phash,
+		&q.Status, &q.Attempts, &q.NextAttempt, &q.LastError, &q.CreatedAt, &q.UpdatedAt,
+	)
+	q.Image.PHash = uint64(phash)
+	return q, err
+}
+
+// enqueueAnalysis adds banner to the queue, due now. A banner already queued is returned as is.
+func enqueueAnalysis(db *sql.DB, website Website, banner BannerData, image StoredImage, now time.Time) (queuedAnalysis, error) {
+	now = now.UTC()
+	if _, err := db.Exec(`
+	INSERT INTO
+		analysis_queue (
+			website_id,
+			src_url,
+			supporting_text,
+			href,
+			image_hash,
+			image_ext,
+			image_width,
+			image_height,
+			image_phash,
+			next_attempt,
+			created_at,
+			updated_at
+		)
+	VALUES
+		(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(src_url) DO NOTHING`,
+		website.WebsiteID, banner.Src, banner.SupportingText, banner.Href,
+		image.Hash, image.Ext, image.Width, image.Height, int64(image.PHash),
+		now, now, now,
+	); err != nil {
+		return queuedAnalysis{}, fmt.Errorf("could not queue banner %s for analysis: %w", banner.Src, err)
+	}
+
+	q, err := scanQueuedAnalysis(db.QueryRow(`SELECT `+analysisColumns+` FROM analysis_queue WHERE src_url = ?`, banner.Src))
+	if err != nil {
+		return queuedAnalysis{}, fmt.Errorf("could not get queued banner %s: %w", banner.Src, err)
+	}
+	return q, nil
+}
+
+// analyzeBanner asks the llm about banner and normalises the answer
+func analyzeBanner(ctx context.Context, db *sql.DB, website Website, banner BannerData, imageHash string) (*OfferDescriptionResponse, offerReview, error) {
+	offer, err := analyzeOffer(ctx, db, website.WebsiteName, banner, imageHash)
+	if err != nil {
+		return nil, offerReview{}, err
+	}
+	review, err := normaliseOffer(db, offer, time.Now())
+	if err != nil {
+		return offer, offerReview{}, err
+	}
+	return offer, review, nil
+}
+
+// attemptAnalysis analyses a queued banner and saves it as a post, which takes it off the queue.
+// A failure is recorded against the banner and returned.
+func (s *Service) attemptAnalysis(ctx context.Context, website Website, q queuedAnalysis, now time.Time) (*OfferDescriptionResponse, offerReview, int, error) {
+	offer, review, err := analyzeBanner(ctx, s.db, website, q.Banner, q.Image.Hash)
+	// no offer means the llm itself failed rather than normalising its answer
+	if offer == nil && s.fallBackToOCR(q, err) {
+		offer, review, err = s.analyzeBannerOffline(ctx, website, q, now, err)
+	}
+	// shutting down isn't the banner's fault, it stays due without using an attempt
+	if err != nil && ctx.Err() != nil {
+		return offer, review, 0, errors.Join(err, ctx.Err())
+	}
+	if err == nil {
+		var postID int
+		if postID, err = saveOffer(s, website, q.Banner, q.Image, offer, review); err == nil {
+			outcome := "ok"
+			if offer.MachineExtracted {
+				outcome = "ocr"
+			}
+			analysisAttempts.Inc(website.WebsiteName, outcome)
+			return offer, review, postID, nil
+		}
+	}
+
+	outcome, recordErr := recordAnalysisFailure(s.db, q, err, s.retry, now)
+	if recordErr != nil {
+		return offer, review, 0, errors.Join(err, recordErr)
+	}
+	analysisAttempts.Inc(website.WebsiteName, outcome)
+	return offer, review, 0, err
+}
+
+// fallBackToOCR is whether a banner the llm failed on should be read offline instead. That is
+// when the budget is spent or this was the banner's last attempt.
+func (s *Service) fallBackToOCR(q queuedAnalysis, llmErr error) bool {
+	if s.ocr == nil || llmErr == nil {
+		return false
+	}
+	return errors.Is(llmErr, chat.ErrBudgetExceeded) || q.Attempts+1 >= s.retry.MaxAttempts
+}
+
+// analyzeBannerOffline reads a queued banner with the ocr fallback. When that fails too both
+// errors are returned so the failure is still recorded against the llm.
+func (s *Service) analyzeBannerOffline(ctx context.Context, website Website, q queuedAnalysis, now time.Time, llmErr error) (*OfferDescriptionResponse, offerReview, error) {
+	offer, err := s.extractOfferOffline(ctx, website, q.Banner, q.Image, now)
+	if err != nil {
+		return nil, offerReview{}, errors.Join(llmErr, fmt.Errorf("ocr fallback failed: %w", err))
+	}
+	review, err := normaliseOffer(s.db, offer, now)
+	if err != nil {
+		return offer, offerReview{}, err
+	}
+	return offer, review, nil
+}
+
+// recordAnalysisFailure schedules the next attempt or marks the banner dead, returning which
+func recordAnalysisFailure(db *sql.DB, q queuedAnalysis, failure error, policy retryPolicy, now time.Time) (string, error) {
+	outcome := "retry"
+	attempts := q.Attempts + 1
+	status := analysisQueued
+	switch {
+	case errors.Is(failure, chat.ErrBudgetExceeded):
+		// not the banner's fault, try again once there may be budget without using an attempt
+		outcome = "deferred"
+		attempts = q.Attempts
+	case attempts >= policy.MaxAttempts:
+		outcome = "dead"
+		status = analysisDead
+	}
+
+	now = now.UTC()
+	if _, err := db.Exec(`
+	UPDATE
+		analysis_queue
+	SET
+		status = ?,
+		attempts = ?,
+		next_attempt = ?,
+		last_error = ?,
+		updated_at = ?
+	WHERE
+		id = ?`,
+		status, attempts, now.Add(policy.delay(max(attempts, 1))), failure.Error(), now, q.ID,
+	); err != nil {
+		return "", fmt.Errorf("could not record failed analysis of %s: %w", q.Banner.Src, err)
+	}
+	return outcome, nil
+}
+
+// retryAnalyses works through queued banners that are due. Failed analyses are logged, only
+// database errors are returned.
+func retryAnalyses(ctx context.Context, service *Service) error {
+	now := time.Now()
+	rows, err := service.db.Query(`
+	SELECT
+		`+analysisColumns+`
+	FROM
+		analysis_queue
+	WHERE
+		status = ?
+		AND next_attempt <= ?
+	ORDER BY
+		next_attempt
+	LIMIT ?`,
+		analysisQueued, now.UTC(), analysisBatch,
+	)
+	if err != nil {
+		return fmt.Errorf("could not get due analyses: %w", err)
+	}
+	due := make([]queuedAnalysis, 0, analysisBatch)
+	for rows.Next() {
+		q, err := scanQueuedAnalysis(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, q)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, q := range due {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		website, err := getWebsiteByID(q.WebsiteID)
+		if err != nil {
+			return fmt.Errorf("could not get website by id %d: %w", q.WebsiteID, err)
+		}
+		if _, _, _, err := service.attemptAnalysis(ctx, website, q, now); err != nil {
+			log.Printf("retrying analysis of banner %s for website %s failed: %v", q.Banner.Src, website.WebsiteName, err)
+		}
+	}
+	return nil
+}
+
+// GetAnalysisQueue lists banners still waiting, dead ones first then by when they are due
+func (s *Service) GetAnalysisQueue(limit, offset int) ([]queuedAnalysis, error) {
+	rows, err := s.db.Query(`
+	SELECT
+		`+analysisColumns+`
+	FROM
+		analysis_queue
+	ORDER BY
+		status = ? DESC,
+		next_attempt
+	LIMIT ? OFFSET ?`,
+		analysisDead, limit, offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not get the analysis queue: %w", err)
+	}
+	defer rows.Close()
+
+	queue := make([]queuedAnalysis, 0, limit)
+	for rows.Next() {
+		q, err := scanQueuedAnalysis(rows)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, q)
+	}
+	return queue, rows.Err()
+}
+
+// RetryAnalysis makes a queued banner due now, a dead banner gets its attempts back
+func (s *Service) RetryAnalysis(id int) error {
+	now := time.Now().UTC()
+	if _, err := s.db.Exec(`
+	UPDATE
+		analysis_queue
+	SET
+		attempts = CASE WHEN status = ? THEN 0 ELSE attempts END,
+		status = ?,
+		next_attempt = ?,
+		updated_at = ?
+	WHERE
+		id = ?`,
+		analysisDead, analysisQueued, now, now, id,
+	); err != nil {
+		return fmt.Errorf("could not retry analysis %d: %w", id, err)
+	}
+	return nil
+}
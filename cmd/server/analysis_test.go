@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := retryPolicy{MaxAttempts: 5, Backoff: 10 * time.Minute}
+	cases := map[int]time.Duration{
+		1:  10 * time.Minute,
+		2:  20 * time.Minute,
+		3:  40 * time.Minute,
+		4:  80 * time.Minute,
+		50: maxRetryDelay,
+	}
+	for attempts, want := range cases {
+		if got := p.delay(attempts); got != want {
+			t.Errorf("delay(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+
+	if got := (retryPolicy{Backoff: 48 * time.Hour}).delay(1); got != maxRetryDelay {
+		t.Errorf("expected a backoff over the cap to be capped, got %v", got)
+	}
+}
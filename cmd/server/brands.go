@@ -172,6 +172,10 @@ func (s *Service) UpdateBrand(brand Brand) error {
 	if err != nil {
 		return fmt.Errorf("could not update brand: %w", err)
 	}
+	// the brand's score is part of its posts' scores
+	if _, err := s.db.Exec(`UPDATE posts SET scored_at = NULL WHERE id IN (SELECT post_id FROM post_brands WHERE brand_id = ?)`, brand.ID); err != nil {
+		return fmt.Errorf("could not queue posts of brand %d for scoring: %w", brand.ID, err)
+	}
 	return nil
 }
 
@@ -2,8 +2,9 @@ package main
 
 import (
 	"beautybargains/internal/chat"
+	"context"
+	"database/sql"
 	"encoding/json"
-	"fmt"
 
 	"github.com/sashabaranov/go-openai"
 )
@@ -17,83 +18,60 @@ type OfferDescriptionResponse struct {
 	Categories []string `json:"categories"`
 	// any brands mentioned
 	Brands []string `json:"brands"`
-}
-
-const responseSchema = `{
-  "type": "object",
-  "properties": {
-    "description": {
-      "type": "string",
-      "description": "Text description of the offer"
-    },
-    "coupon_codes": {
-      "type": "array",
-      "description": "Any coupon codes found in the resource",
-      "items": {
-        "type": "object",
-        "properties": {
-          "code": {
-            "type": "string",
-            "description": "The coupon code"
-          },
-          "description": {
-            "type": "string",
-            "description": "Description of the coupon code"
-          },
-          "valid_until": {
-            "type": ["string", "null"],
-            "format": "date-time",
-            "description": "Expiration date of the coupon, if any, in rfc3339 time format"
-          }
-        },
-        "required": ["code", "description"]
-      }
-    },
-    "categories": {
-      "type": "array",
-      "description": "Typical health and beauty categories",
-      "items": {
-        "type": "string"
-      }
-    },
-    "brands": {
-      "type": "array",
-      "description": "Any brands mentioned",
-      "items": {
-        "type": "string"
-      }
-    }
-  },
-  "required": ["description", "coupon_codes", "categories", "brands"]
-}`
+	// the offer as structured fields, see deals.go
+	Deal Deal `json:"deal"`
 
-var mySchema = chat.MySchema{Raw: responseSchema}
+	// the prompt and model that produced the response, not part of the schema
+	PromptVersion int    `json:"-"`
+	Model         string `json:"-"`
+	// MachineExtracted is set when the offer was read from the banner without the llm, see ocr.go
+	MachineExtracted bool `json:"-"`
+}
 
 /* chat service begins */
-func analyzeOffer(websiteName string, banner BannerData) (*OfferDescriptionResponse, error) {
-
-	content := []openai.ChatMessagePart{
-		{
-			Type: "text",
-			Text: fmt.Sprintf(`You are a joyful and excited social media manager for a health and beauty magazine with the goal of motivating people to take advantage of today's available beauty offers.
-			Tell your audience what the beauty retailer %s is advertising today and highlight any coupons if available. Keep your response short, playful and suitable for a tweet or instagram caption.
-			Do not acknowledge that you are AI.`, websiteName),
+// configureChat points the chat package at the configured model and budget with responses
+// cached in store
+func configureChat(cfg LLMConfig, store chat.Store) {
+	chat.Configure(chat.Config{
+		APIKey:    cfg.APIKey,
+		Model:     cfg.Model,
+		MaxTokens: cfg.MaxTokens,
+		Timeout:   cfg.Timeout,
+		Store:     store,
+		Budget: chat.Budget{
+			DailyTokens:   cfg.DailyTokenBudget,
+			MonthlyTokens: cfg.MonthlyTokenBudget,
+			DailySpend:    cfg.DailySpendBudget,
+			MonthlySpend:  cfg.MonthlySpendBudget,
 		},
+	})
+}
+
+// analyzeOffer asks the llm to describe banner with the active prompt. imageHash is the sha256
+// of the banner when it has been downloaded so a re-uploaded banner is answered from the cache.
+func analyzeOffer(ctx context.Context, db *sql.DB, websiteName string, banner BannerData, imageHash string) (*OfferDescriptionResponse, error) {
+	prompt, err := getActivePrompt(db, offerPromptJob)
+	if err != nil {
+		return nil, err
 	}
+	return runOfferPrompt(ctx, prompt, offerPromptJob, websiteName, banner, imageHash)
+}
 
-	if banner.SupportingText != "" {
-		content = append(content, openai.ChatMessagePart{
-			Type: "text",
-			Text: fmt.Sprintf("For some additional context regarding this promotion please see the quoted text '%s'", banner.SupportingText),
-		})
+// runOfferPrompt describes banner with a specific version of the prompt, usage is recorded
+// against job
+func runOfferPrompt(ctx context.Context, prompt Prompt, job, websiteName string, banner BannerData, imageHash string) (*OfferDescriptionResponse, error) {
+	text, err := prompt.render(websiteName, banner)
+	if err != nil {
+		return nil, err
 	}
 
-	content = append(content, openai.ChatMessagePart{
-		Type:     "image_url",
-		ImageURL: &openai.ChatMessageImageURL{URL: banner.Src},
-	})
+	content := []openai.ChatMessagePart{
+		{Type: "text", Text: text},
+		{Type: "image_url", ImageURL: &openai.ChatMessageImageURL{URL: banner.Src}},
+	}
 
 	requestParams := openai.ChatCompletionRequest{
+		Model: prompt.model(),
 		Messages: []openai.ChatCompletionMessage{
 			{
 				Role:         "user",
@@ -105,12 +83,18 @@ func analyzeOffer(websiteName string, banner BannerData) (*OfferDescriptionRespo
 			JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
 				Name:        "OfferDescriptionResponse",
 				Description: "Schema for Offer Description response including coupon codes and related information.",
-				Schema:      &mySchema,
+				Schema:      &chat.MySchema{Raw: prompt.Schema},
 			},
 		},
 	}
 
-	answer, err := chat.CreateChatCompletion(requestParams)
+	call := chat.Call{Job: job, Validate: func(answer string) error {
+		return json.Unmarshal([]byte(answer), new(OfferDescriptionResponse))
+	}}
+	if imageHash != "" {
+		call.ImageHashes = []string{imageHash}
+	}
+	answer, err := chat.CreateChatCompletion(ctx, requestParams, call)
 	if err != nil {
 		return nil, err
 	}
@@ -119,6 +103,8 @@ func analyzeOffer(websiteName string, banner BannerData) (*OfferDescriptionRespo
 	if err := json.Unmarshal([]byte(answer), data); err != nil {
 		return nil, err
 	}
+	data.PromptVersion = prompt.Version
+	data.Model = requestParams.Model
 
 	return data, nil
 }
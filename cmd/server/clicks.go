@@ -0,0 +1,373 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Outbound links go through /go/{postID}, /go/coupon/{id}, /go/offer/{id} and /go/product/{id}
+so clicks are counted before the reader is sent on to the retailer. A post goes to its banner's
+link, a coupon to the link of its post and an offer or product to its page at the retailer, all
+fall back to the retailer's homepage. Links in emails go through /go too.
+
+Retailers with an affiliate programme have a template, edited at /admin/clicks, that's applied
+to the link on the way out. A template is either query parameters added to the link or a
+tracking link the link is passed to as {url}:
+
+	ref=beautybargains&sub={post}
+	https://track.example.com/click?m=123&u={url}
+
+{post}, {coupon} and {product} are replaced with the ids of what was clicked, empty when
+there's none.
+
+A click is logged in clicks and counted on its post, which shows as the post's likes and queues
+it for rescoring. Bots and clients clicking faster than the click limiter allows are sent on
+without being counted.
+*/
+
+// clickReportDays is how far back /admin/clicks reports
+const clickReportDays = 30
+
+// botAgents are user agent fragments of crawlers and link previewers
+var botAgents = []string{"bot", "crawl", "spider", "slurp", "facebookexternalhit", "preview", "curl", "wget"}
+
+func isBot(userAgent string) bool {
+	userAgent = strings.ToLower(userAgent)
+	if userAgent == "" {
+		return true
+	}
+	for _, agent := range botAgents {
+		if strings.Contains(userAgent, agent) {
+			return true
+		}
+	}
+	return false
+}
+
+// outboundTarget is where a click goes and what it's counted against
+type outboundTarget struct {
+	WebsiteID int
+	PostID    sql.NullInt64
+	CouponID  sql.NullInt64
+	ProductID sql.NullInt64
+	Link      string
+}
+
+// applyAffiliate rewrites link with an affiliate template, see the top of this file
+func applyAffiliate(link, template string, target outboundTarget) (string, error) {
+	if template == "" {
+		return link, nil
+	}
+	id := func(n sql.NullInt64) string {
+		if !n.Valid {
+			return ""
+		}
+		return strconv.FormatInt(n.Int64, 10)
+	}
+	template = strings.NewReplacer(
+		"{post}", id(target.PostID),
+		"{coupon}", id(target.CouponID),
+		"{product}", id(target.ProductID),
+		"{url}", url.QueryEscape(link),
+	).Replace(template)
+
+	if strings.HasPrefix(template, "http://") || strings.HasPrefix(template, "https://") {
+		if _, err := url.Parse(template); err != nil {
+			return "", fmt.Errorf("invalid affiliate link %s: %w", template, err)
+		}
+		return template, nil
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", fmt.Errorf("invalid link %s: %w", link, err)
+	}
+	params, err := url.ParseQuery(strings.TrimPrefix(template, "?"))
+	if err != nil {
+		return "", fmt.Errorf("invalid affiliate parameters %s: %w", template, err)
+	}
+	query := u.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// outboundURL is where target sends readers, with the retailer's affiliate template applied
+func (s *Service) outboundURL(target outboundTarget) (string, error) {
+	website, err := getWebsiteByID(target.WebsiteID)
+	if err != nil {
+		return "", err
+	}
+	link := target.Link
+	if u, err := url.Parse(link); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		link = website.URL
+	}
+	var template string
+	err = s.db.QueryRow(`SELECT template FROM affiliate_templates WHERE website_id = ?`, website.WebsiteID).Scan(&template)
+	if err != nil && err != sql.ErrNoRows {
+		return "", fmt.Errorf("could not get the affiliate template of %s: %w", website.WebsiteName, err)
+	}
+	return applyAffiliate(link, template, target)
+}
+
+// getPostTarget returns where an approved post links to
+func (s *Service) getPostTarget(postID int) (outboundTarget, error) {
+	target := outboundTarget{PostID: sql.NullInt64{Int64: int64(postID), Valid: true}}
+	err := s.db.QueryRow(`
+	SELECT
+		website_id,
+		COALESCE(link, '')
+	FROM
+		posts
+	WHERE
+		id = ?
+		AND status = ?`,
+		postID, postApproved,
+	).Scan(&target.WebsiteID, &target.Link)
+	if err != nil {
+		return outboundTarget{}, fmt.Errorf("could not get the link of post %d: %w", postID, err)
+	}
+	return target, nil
+}
+
+// getCouponTarget returns where a coupon links to, the link of its post when it has one
+func (s *Service) getCouponTarget(couponID int) (outboundTarget, error) {
+	target := outboundTarget{CouponID: sql.NullInt64{Int64: int64(couponID), Valid: true}}
+	err := s.db.QueryRow(`
+	SELECT
+		c.website_id,
+		c.post_id,
+		COALESCE(p.link, '')
+	FROM
+		coupon_codes c
+		LEFT JOIN posts p ON p.id = c.post_id
+	WHERE
+		c.id = ?
+		AND (c.post_id IS NULL OR p.status = ?)`,
+		couponID, postApproved,
+	).Scan(&target.WebsiteID, &target.PostID, &target.Link)
+	if err != nil {
+		return outboundTarget{}, fmt.Errorf("could not get the link of coupon %d: %w", couponID, err)
+	}
+	return target, nil
+}
+
+// getOfferTarget returns the page of a product offer
+func (s *Service) getOfferTarget(offerID int) (outboundTarget, error) {
+	var target outboundTarget
+	err := s.db.QueryRow(`
+	SELECT
+		p.website_id,
+		o.product_id,
+		o.url
+	FROM
+		product_offers o
+		JOIN products p ON p.id = o.product_id
+	WHERE
+		o.id = ?`,
+		offerID,
+	).Scan(&target.WebsiteID, &target.ProductID, &target.Link)
+	if err != nil {
+		return outboundTarget{}, fmt.Errorf("could not get the link of offer %d: %w", offerID, err)
+	}
+	return target, nil
+}
+
+// getProductTarget returns the page of a product
+func (s *Service) getProductTarget(productID int) (outboundTarget, error) {
+	target := outboundTarget{ProductID: sql.NullInt64{Int64: int64(productID), Valid: true}}
+	err := s.db.QueryRow(`SELECT website_id, url FROM products WHERE id = ?`, productID).Scan(&target.WebsiteID, &target.Link)
+	if err != nil {
+		return outboundTarget{}, fmt.Errorf("could not get the link of product %d: %w", productID, err)
+	}
+	return target, nil
+}
+
+// RecordClick logs a click and counts it on its post, which queues the post for rescoring
+func (s *Service) RecordClick(target outboundTarget, now time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+	INSERT INTO
+		clicks (website_id, post_id, coupon_id, product_id, clicked_at)
+	VALUES
+		(?, ?, ?, ?, ?)`,
+		target.WebsiteID, target.PostID, target.CouponID, target.ProductID, now.UTC(),
+	); err != nil {
+		return fmt.Errorf("could not record click: %w", err)
+	}
+	if target.PostID.Valid {
+		if _, err := tx.Exec(`UPDATE posts SET clicks = clicks + 1, scored_at = NULL WHERE id = ?`, target.PostID); err != nil {
+			return fmt.Errorf("could not count click on post %d: %w", target.PostID.Int64, err)
+		}
+	}
+	return tx.Commit()
+}
+
+type AffiliateTemplate struct {
+	Website   Website
+	Template  string
+	UpdatedAt sql.NullTime
+}
+
+// GetAffiliateTemplates returns every retailer with its template, "" for those without one
+func (s *Service) GetAffiliateTemplates() ([]AffiliateTemplate, error) {
+	rows, err := s.db.Query(`SELECT website_id, template, updated_at FROM affiliate_templates`)
+	if err != nil {
+		return nil, fmt.Errorf("could not get affiliate templates: %w", err)
+	}
+	defer rows.Close()
+
+	saved := map[int]AffiliateTemplate{}
+	for rows.Next() {
+		var websiteID int
+		var t AffiliateTemplate
+		if err := rows.Scan(&websiteID, &t.Template, &t.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("could not scan affiliate template: %w", err)
+		}
+		saved[websiteID] = t
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var templates []AffiliateTemplate
+	for _, website := range getWebsites(0, 0) {
+		t := saved[website.WebsiteID]
+		t.Website = website
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
+
+// SaveAffiliateTemplate sets a retailer's template, an empty template removes it
+func (s *Service) SaveAffiliateTemplate(websiteID int, template string) error {
+	website, err := getWebsiteByID(websiteID)
+	if err != nil {
+		return err
+	}
+	if template == "" {
+		if _, err := s.db.Exec(`DELETE FROM affiliate_templates WHERE website_id = ?`, websiteID); err != nil {
+			return fmt.Errorf("could not remove the affiliate template of %s: %w", website.WebsiteName, err)
+		}
+		return nil
+	}
+	_, err = s.db.Exec(`
+	INSERT INTO
+		affiliate_templates (website_id, template, updated_at)
+	VALUES
+		(?, ?, ?)
+	ON CONFLICT (website_id) DO UPDATE SET
+		template = excluded.template,
+		updated_at = excluded.updated_at`,
+		websiteID, template, time.Now().UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("could not save the affiliate template of %s: %w", website.WebsiteName, err)
+	}
+	return nil
+}
+
+// WebsiteClicks are a retailer's clicks in the report window
+type WebsiteClicks struct {
+	Website  Website
+	Posts    int
+	Coupons  int
+	Products int
+}
+
+// PostClicks are a post's clicks in the report window
+type PostClicks struct {
+	PostID      int
+	Website     Website
+	Description string
+	Link        string
+	Clicks      int
+}
+
+// GetClicksByWebsite counts clicks on posts, coupons and products of each retailer since since,
+// most first
+func (s *Service) GetClicksByWebsite(since time.Time) ([]WebsiteClicks, error) {
+	rows, err := s.db.Query(`
+	SELECT
+		website_id,
+		SUM(coupon_id IS NULL AND product_id IS NULL),
+		COUNT(coupon_id),
+		COUNT(product_id)
+	FROM
+		clicks
+	WHERE
+		clicked_at >= ?
+	GROUP BY
+		website_id
+	ORDER BY
+		COUNT(*) DESC`,
+		since.UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not count clicks by website: %w", err)
+	}
+	defer rows.Close()
+
+	var clicks []WebsiteClicks
+	for rows.Next() {
+		var c WebsiteClicks
+		var websiteID int
+		if err := rows.Scan(&websiteID, &c.Posts, &c.Coupons, &c.Products); err != nil {
+			return nil, fmt.Errorf("could not scan website clicks: %w", err)
+		}
+		c.Website, _ = getWebsiteByID(websiteID)
+		clicks = append(clicks, c)
+	}
+	return clicks, rows.Err()
+}
+
+// GetTopClickedPosts returns the posts clicked most since since, coupon clicks included
+func (s *Service) GetTopClickedPosts(since time.Time, limit int) ([]PostClicks, error) {
+	rows, err := s.db.Query(`
+	SELECT
+		p.id,
+		p.website_id,
+		COALESCE(p.description, ''),
+		COALESCE(p.link, ''),
+		COUNT(*)
+	FROM
+		clicks c
+		JOIN posts p ON p.id = c.post_id
+	WHERE
+		c.clicked_at >= ?
+	GROUP BY
+		p.id
+	ORDER BY
+		COUNT(*) DESC
+	LIMIT ?`,
+		since.UTC(), limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not get the most clicked posts: %w", err)
+	}
+	defer rows.Close()
+
+	var posts []PostClicks
+	for rows.Next() {
+		var p PostClicks
+		var websiteID int
+		if err := rows.Scan(&p.PostID, &websiteID, &p.Description, &p.Link, &p.Clicks); err != nil {
+			return nil, fmt.Errorf("could not scan post clicks: %w", err)
+		}
+		p.Website, _ = getWebsiteByID(websiteID)
+		posts = append(posts, p)
+	}
+	return posts, rows.Err()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestApplyAffiliate(t *testing.T) {
+	post := sql.NullInt64{Int64: 42, Valid: true}
+	coupon := sql.NullInt64{Int64: 7, Valid: true}
+	tests := []struct {
+		name     string
+		link     string
+		template string
+		coupon   sql.NullInt64
+		want     string
+	}{
+		{"no template", "https://shop.ie/sale?utm=x", "", sql.NullInt64{}, "https://shop.ie/sale?utm=x"},
+		{"parameters", "https://shop.ie/sale?page=2", "ref=bb&sub={post}", sql.NullInt64{}, "https://shop.ie/sale?page=2&ref=bb&sub=42"},
+		{"parameters replace the link's", "https://shop.ie/?ref=old", "?ref=bb", sql.NullInt64{}, "https://shop.ie/?ref=bb"},
+		{"coupon", "https://shop.ie/", "sub={post}-{coupon}", coupon, "https://shop.ie/?sub=42-7"},
+		{"no product", "https://shop.ie/", "sub={product}", sql.NullInt64{}, "https://shop.ie/?sub="},
+		{"tracking link", "https://shop.ie/sale?a=1&b=2", "https://track.example.com/c?m=1&u={url}", sql.NullInt64{}, "https://track.example.com/c?m=1&u=https%3A%2F%2Fshop.ie%2Fsale%3Fa%3D1%26b%3D2"},
+	}
+	for _, tt := range tests {
+		got, err := applyAffiliate(tt.link, tt.template, outboundTarget{PostID: post, CouponID: tt.coupon})
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := applyAffiliate("https://shop.ie/", "ref=%zz", outboundTarget{PostID: post, CouponID: coupon}); err == nil {
+		t.Error("expected an error for a malformed template")
+	}
+}
+
+func TestIsBot(t *testing.T) {
+	for agent, want := range map[string]bool{
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15": false,
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)":    true,
+		"facebookexternalhit/1.1": true,
+		"":                        true,
+	} {
+		if got := isBot(agent); got != want {
+			t.Errorf("isBot(%q) = %v, want %v", agent, got, want)
+		}
+	}
+}
+
+func TestProductClicks(t *testing.T) {
+	s := newTestService(t)
+	now := time.Date(2026, 5, 10, 12, 0, 0, 0, time.UTC)
+	mustExec(t, s.db, `INSERT INTO products (id, website_id, name, url, created_at) VALUES (1, 2, 'Serum', 'https://shop.ie/serum', ?)`, now)
+	mustExec(t, s.db, `INSERT INTO product_offers (id, product_id, sku, price, url, last_seen) VALUES (5, 1, 'a', 20, 'https://shop.ie/serum?size=30', ?)`, now)
+
+	offer, err := s.getOfferTarget(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offer.WebsiteID != 2 || offer.ProductID.Int64 != 1 || offer.Link != "https://shop.ie/serum?size=30" {
+		t.Errorf("unexpected offer target %+v", offer)
+	}
+	product, err := s.getProductTarget(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if product.ProductID.Int64 != 1 || product.Link != "https://shop.ie/serum" {
+		t.Errorf("unexpected product target %+v", product)
+	}
+	if _, err := s.getOfferTarget(6); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected no target for an unknown offer, got %v", err)
+	}
+
+	for _, target := range []outboundTarget{offer, product} {
+		if err := s.RecordClick(target, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clicks, err := s.GetClicksByWebsite(now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clicks) != 1 || clicks[0].Products != 2 || clicks[0].Posts != 0 || clicks[0].Coupons != 0 {
+		t.Errorf("expected 2 product clicks, got %+v", clicks)
+	}
+}
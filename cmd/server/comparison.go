@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// comparisonDays is how much price history the comparison chart shows
+const comparisonDays = 90
+
+// CatalogOffer is a retailer's current price for a catalog product
+type CatalogOffer struct {
+	ID             int
+	WebsiteID      int
+	Website        Website
+	Name           string
+	URL            string
+	Price          float64
+	Currency       string
+	Availability   string
+	LowestIn90Days bool
+	AllTimeLow     bool
+}
+
+// InStock is false only when the retailer says it's out of stock
+func (o CatalogOffer) InStock() bool {
+	return o.Availability != "OutOfStock" && o.Availability != "SoldOut" && o.Availability != "Discontinued"
+}
+
+func (s *Service) GetCatalogProduct(slug string) (CatalogProduct, error) {
+	var c CatalogProduct
+	err := s.db.QueryRow(`
+	SELECT
+		c.id,
+		c.slug,
+		c.brand_id,
+		COALESCE(b.name, ''),
+		c.name,
+		c.size,
+		c.gtin,
+		c.image,
+		c.created_at
+	FROM
+		catalog_products c
+		LEFT JOIN brands b ON b.id = c.brand_id
+	WHERE
+		c.slug = ?`,
+		slug,
+	).Scan(&c.ID, &c.Slug, &c.BrandID, &c.Brand, &c.Name, &c.Size, &c.GTIN, &c.Image, &c.CreatedAt)
+	if err != nil {
+		return CatalogProduct{}, fmt.Errorf("could not get catalog product %s: %w", slug, err)
+	}
+	return c, nil
+}
+
+// GetCatalogOffers returns the offers of a catalog product still listed at their last crawl,
+// cheapest first
+func (s *Service) GetCatalogOffers(catalogID int) ([]CatalogOffer, error) {
+	rows, err := s.db.Query(`
+	SELECT
+		o.id,
+		p.website_id,
+		o.name,
+		CASE WHEN o.url != '' THEN o.url ELSE p.url END,
+		o.price,
+		o.currency,
+		o.availability,
+		o.lowest_90_days,
+		o.all_time_low
+	FROM
+		product_offers o
+		JOIN products p ON p.id = o.product_id
+	WHERE
+		o.catalog_id = ?
+		AND o.price IS NOT NULL
+		AND o.last_seen = p.last_crawled
+	ORDER BY
+		o.price`,
+		catalogID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not get offers of catalog product %d: %w", catalogID, err)
+	}
+	defer rows.Close()
+
+	var offers []CatalogOffer
+	for rows.Next() {
+		var o CatalogOffer
+		if err := rows.Scan(
+			&o.ID,
+			&o.WebsiteID,
+			&o.Name,
+			&o.URL,
+			&o.Price,
+			&o.Currency,
+			&o.Availability,
+			&o.LowestIn90Days,
+			&o.AllTimeLow,
+		); err != nil {
+			return nil, fmt.Errorf("could not scan catalog offer: %w", err)
+		}
+		o.Website, _ = getWebsiteByID(o.WebsiteID)
+		offers = append(offers, o)
+	}
+	return offers, rows.Err()
+}
+
+// getCompareSlugs maps the products of posts to the catalog product of their cheapest offer
+func (s *Service) getCompareSlugs(posts []Post) (map[int64]string, error) {
+	var args []any
+	for _, post := range posts {
+		if post.ProductID.Valid {
+			args = append(args, post.ProductID.Int64)
+		}
+	}
+	if len(args) == 0 {
+		return nil, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(args)), ",")
+	rows, err := s.db.Query(`
+	SELECT
+		o.product_id,
+		c.slug
+	FROM
+		product_offers o
+		JOIN catalog_products c ON c.id = o.catalog_id
+	WHERE
+		o.product_id IN (`+placeholders+`)
+	ORDER BY
+		o.price DESC`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not get comparison pages of posts: %w", err)
+	}
+	defer rows.Close()
+
+	slugs := make(map[int64]string, len(args))
+	for rows.Next() {
+		var productID int64
+		var slug string
+		if err := rows.Scan(&productID, &slug); err != nil {
+			return nil, err
+		}
+		// cheapest last so it wins
+		slugs[productID] = slug
+	}
+	return slugs, rows.Err()
+}
+
+// priceChart is a line per retailer drawn as svg polylines
+type priceChart struct {
+	Width, Height int
+	Lines         []chartLine
+	// Low and High are the prices at the bottom and top of the chart
+	Low, High float64
+	From, To  time.Time
+}
+
+type chartLine struct {
+	Label  string
+	Colour string
+	// Segments are polyline points, a line breaks on days without a price
+	Segments []string
+}
+
+var chartColours = []string{"#ec4899", "#3b82f6", "#10b981", "#f59e0b", "#8b5cf6", "#6b7280"}
+
+// newPriceChart scales series of daily prices, all over the same days, to a width by height chart
+func newPriceChart(labels []string, series [][]DailyPrice, width, height int) priceChart {
+	chart := priceChart{Width: width, Height: height, Low: math.Inf(1), High: math.Inf(-1)}
+	days := 0
+	for _, prices := range series {
+		days = max(days, len(prices))
+		for _, p := range prices {
+			if p.Price != nil {
+				chart.Low = math.Min(chart.Low, *p.Price)
+				chart.High = math.Max(chart.High, *p.Price)
+			}
+		}
+	}
+	if days == 0 || math.IsInf(chart.Low, 1) {
+		return priceChart{}
+	}
+	// leave a margin so flat lines don't sit on the edge
+	margin := math.Max((chart.High-chart.Low)*0.1, chart.High*0.05)
+	chart.Low = math.Max(0, chart.Low-margin)
+	chart.High += margin
+
+	x := func(day int) float64 {
+		if days == 1 {
+			return float64(width) / 2
+		}
+		return float64(day) * float64(width) / float64(days-1)
+	}
+	y := func(price float64) float64 {
+		return float64(height) * (chart.High - price) / (chart.High - chart.Low)
+	}
+
+	for i, prices := range series {
+		line := chartLine{Label: labels[i], Colour: chartColours[i%len(chartColours)]}
+		var points []string
+		for day, p := range prices {
+			if p.Price == nil {
+				if len(points) > 0 {
+					line.Segments = append(line.Segments, strings.Join(points, " "))
+					points = nil
+				}
+				continue
+			}
+			points = append(points, fmt.Sprintf("%.1f,%.1f", x(day), y(*p.Price)))
+		}
+		if len(points) > 0 {
+			line.Segments = append(line.Segments, strings.Join(points, " "))
+		}
+		chart.Lines = append(chart.Lines, line)
+		if len(prices) > 0 {
+			chart.From, chart.To = prices[0].Day, prices[len(prices)-1].Day
+		}
+	}
+	return chart
+}
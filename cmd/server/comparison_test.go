@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewPriceChart(t *testing.T) {
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	price := func(p float64) *float64 { return &p }
+	series := [][]DailyPrice{
+		{{Day: day}, {Day: day.AddDate(0, 0, 1), Price: price(20)}, {Day: day.AddDate(0, 0, 2), Price: price(10)}},
+		{{Day: day, Price: price(15)}, {Day: day.AddDate(0, 0, 1)}, {Day: day.AddDate(0, 0, 2), Price: price(15)}},
+	}
+	chart := newPriceChart([]string{"A", "B"}, series, 100, 50)
+	if chart.Low != 9 || chart.High != 21 {
+		t.Errorf("expected a margin around 10 to 20, got %v to %v", chart.Low, chart.High)
+	}
+	if len(chart.Lines) != 2 || chart.Lines[0].Colour == chart.Lines[1].Colour {
+		t.Fatalf("expected two differently coloured lines, got %+v", chart.Lines)
+	}
+	if got := chart.Lines[0].Segments; len(got) != 1 || got[0] != "50.0,4.2 100.0,45.8" {
+		t.Errorf("unexpected first line %v", got)
+	}
+	if got := chart.Lines[1].Segments; len(got) != 2 {
+		t.Errorf("expected the second line to break on the missing day, got %v", got)
+	}
+	if !chart.From.Equal(day) || !chart.To.Equal(day.AddDate(0, 0, 2)) {
+		t.Errorf("unexpected range %v to %v", chart.From, chart.To)
+	}
+
+	if empty := newPriceChart([]string{"A"}, [][]DailyPrice{{{Day: day}}}, 100, 50); empty.Lines != nil {
+		t.Errorf("expected no chart without prices, got %+v", empty)
+	}
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// Config holds every setting the server needs. Values are resolved in order of
+// increasing precedence: defaults, the optional config file, environment variables
+// and finally command line flags.
+type Config struct {
+	Port        string
+	Mode        Mode
+	Skip        bool
+	MetricsPort string
+	// TrustProxy uses X-Forwarded-For to identify clients, only enable behind a reverse proxy
+	TrustProxy bool
+
+	// Domain is the public origin used in links, e.g. https://beautybargains.ie
+	Domain string
+	DBPath string
+	// MediaDir is where archived banner images and their resized variants are stored
+	MediaDir string
+
+	SessionKey    string
+	AdminEmail    string
+	AdminPassword string
+
+	// ModeratePosts holds new posts as pending until an admin approves them at /admin/review
+	ModeratePosts bool
+	// TranslateLocales are the locales new posts are translated into by the llm, none by default
+	TranslateLocales []string
+
+	// JobInterval is the pause between ingestion cycles
+	JobInterval time.Duration
+	// ScoreHalfLife is how long it takes the recency part of a post's score to halve
+	ScoreHalfLife time.Duration
+	Timeouts      serverTimeouts
+
+	Scraper        ScraperConfig
+	LLM            LLMConfig
+	OCR            OCRConfig
+	PriceDrops     PriceDropConfig
+	Trending       TrendingConfig
+	Telegram       TelegramConfig
+	ErrorReporting ErrorReportingConfig
+	Mailer         MailerConfig
+}
+
+type ScraperConfig struct {
+	UserAgent string
+	// Workers is how many websites are scraped at the same time
+	Workers      int
+	Timeout      time.Duration
+	HostInterval time.Duration
+	MaxRetries   int
+	// ProductInterval is how long a tracked product page goes before it's crawled again
+	ProductInterval time.Duration
+}
+
+type LLMConfig struct {
+	APIKey    string
+	Model     string
+	MaxTokens int
+	// Timeout bounds each request to the api so a hung call can't hold up shutdown
+	Timeout time.Duration
+	// budgets are per UTC day and month, 0 means unlimited. Spend is in US dollars.
+	DailyTokenBudget   int
+	MonthlyTokenBudget int
+	DailySpendBudget   float64
+	MonthlySpendBudget float64
+	// a banner whose analysis fails is retried after RetryBackoff, doubling each time, and
+	// given up on after MaxAttempts
+	MaxAttempts  int
+	RetryBackoff time.Duration
+}
+
+type TelegramConfig struct {
+	BotToken string
+	ChatID   string
+}
+
+// Enabled reports whether enough has been supplied to report errors to telegram
+func (t TelegramConfig) Enabled() bool {
+	return t.BotToken != "" && t.ChatID != ""
+}
+
+// ErrorReportingConfig limits how often errors are passed on to telegram or the log.
+// Every error is still recorded for /admin/errors.
+type ErrorReportingConfig struct {
+	// Window is how long an identical error is suppressed for after being reported
+	Window time.Duration
+	// MaxPerWindow caps the number of distinct errors reported per window, 0 is unlimited
+	MaxPerWindow int
+}
+
+type MailerConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (m MailerConfig) Enabled() bool {
+	return m.Host != ""
+}
+
+const defaultConfigFile = ".env"
+
+func defaultConfig() Config {
+	return Config{
+		Mode:          Dev,
+		DBPath:        "main.db",
+		MediaDir:      "media",
+		JobInterval:   5 * time.Minute,
+		ScoreHalfLife: 7 * 24 * time.Hour,
+		Timeouts: serverTimeouts{
+			Read:     10 * time.Second,
+			Write:    30 * time.Second,
+			Idle:     120 * time.Second,
+			Shutdown: 30 * time.Second,
+		},
+		Scraper: ScraperConfig{
+			UserAgent:       "BeautyBargainsBot/1.0 (+https://beautybargains.ie)",
+			Workers:         4,
+			Timeout:         20 * time.Second,
+			HostInterval:    2 * time.Second,
+			MaxRetries:      3,
+			ProductInterval: 24 * time.Hour,
+		},
+		LLM: LLMConfig{
+			Model:        "gpt-4o-2024-08-06",
+			MaxTokens:    1000,
+			Timeout:      90 * time.Second,
+			MaxAttempts:  5,
+			RetryBackoff: 10 * time.Minute,
+		},
+		OCR: OCRConfig{
+			Languages: "eng",
+			Timeout:   30 * time.Second,
+		},
+		PriceDrops: PriceDropConfig{
+			Threshold:     0.1,
+			PostThreshold: 0.2,
+		},
+		Trending: TrendingConfig{
+			Window: 7 * 24 * time.Hour,
+			Limit:  5,
+		},
+		ErrorReporting: ErrorReportingConfig{
+			Window:       10 * time.Minute,
+			MaxPerWindow: 20,
+		},
+		Mailer: MailerConfig{
+			Port: 587,
+		},
+	}
+}
+
+// loadConfig builds the config from the optional config file, the environment and args
+// (usually os.Args[1:]) and validates the result.
+func loadConfig(args []string) (Config, error) {
+	cfg := defaultConfig()
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configFile := fs.String("config", defaultConfigFile, "optional env style config file")
+	skip := fs.Bool("skip", false, "skip bannner extraction and hashtag jobs")
+	port := fs.String("port", "", "http port")
+	mode := fs.String("mode", "", "deployment mode")
+	metricsPort := fs.String("metrics-port", "", "optional port to serve prometheus metrics on without auth")
+	dbPath := fs.String("db", "", "path to the sqlite database")
+	jobInterval := fs.Duration("job-interval", 0, "pause between ingestion cycles")
+	readTimeout := fs.Duration("read-timeout", 0, "max duration for reading an entire request")
+	writeTimeout := fs.Duration("write-timeout", 0, "max duration before timing out writes of a response")
+	idleTimeout := fs.Duration("idle-timeout", 0, "max time to wait for the next request on a keep-alive connection")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 0, "max time to wait for requests and jobs to finish on shutdown")
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	// the default file is optional, a file asked for explicitly is not
+	if err := godotenv.Load(*configFile); err != nil {
+		if set["config"] || !errors.Is(err, os.ErrNotExist) {
+			return Config{}, fmt.Errorf("failed to load config file %s: %w", *configFile, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return Config{}, err
+	}
+
+	if set["skip"] {
+		cfg.Skip = *skip
+	}
+	if set["port"] {
+		cfg.Port = *port
+	}
+	if set["mode"] {
+		cfg.Mode = Mode(*mode)
+	}
+	if set["metrics-port"] {
+		cfg.MetricsPort = *metricsPort
+	}
+	if set["db"] {
+		cfg.DBPath = *dbPath
+	}
+	if set["job-interval"] {
+		cfg.JobInterval = *jobInterval
+	}
+	if set["read-timeout"] {
+		cfg.Timeouts.Read = *readTimeout
+	}
+	if set["write-timeout"] {
+		cfg.Timeouts.Write = *writeTimeout
+	}
+	if set["idle-timeout"] {
+		cfg.Timeouts.Idle = *idleTimeout
+	}
+	if set["shutdown-timeout"] {
+		cfg.Timeouts.Shutdown = *shutdownTimeout
+	}
+
+	if cfg.Mode == "" {
+		cfg.Mode = Dev
+	}
+	// links always point back at the local server in dev
+	if cfg.Mode == Dev && cfg.Port != "" {
+		cfg.Domain = "http://localhost:" + cfg.Port
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// applyEnv overrides cfg with any of the recognised environment variables that are set
+func (cfg *Config) applyEnv() error {
+	var errs []error
+
+	str := func(key string, dst *string) {
+		if v, ok := os.LookupEnv(key); ok && v != "" {
+			*dst = v
+		}
+	}
+	duration := func(key string, dst *time.Duration) {
+		v, ok := os.LookupEnv(key)
+		if !ok || v == "" {
+			return
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s must be a duration such as 5m: %w", key, err))
+			return
+		}
+		*dst = d
+	}
+	integer := func(key string, dst *int) {
+		v, ok := os.LookupEnv(key)
+		if !ok || v == "" {
+			return
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s must be a whole number: %w", key, err))
+			return
+		}
+		*dst = n
+	}
+
+	boolean := func(key string, dst *bool) {
+		v, ok := os.LookupEnv(key)
+		if !ok || v == "" {
+			return
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s must be true or false: %w", key, err))
+			return
+		}
+		*dst = b
+	}
+	float := func(key string, dst *float64) {
+		v, ok := os.LookupEnv(key)
+		if !ok || v == "" {
+			return
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s must be a number: %w", key, err))
+			return
+		}
+		*dst = f
+	}
+
+	var mode string
+	str("MODE", &mode)
+	if mode != "" {
+		cfg.Mode = Mode(mode)
+	}
+	boolean("SKIP_JOBS", &cfg.Skip)
+	boolean("TRUST_PROXY", &cfg.TrustProxy)
+	boolean("MODERATE_POSTS", &cfg.ModeratePosts)
+	if v, ok := os.LookupEnv("TRANSLATE_LOCALES"); ok {
+		cfg.TranslateLocales = splitNameList(v)
+	}
+
+	str("PORT", &cfg.Port)
+	str("METRICS_PORT", &cfg.MetricsPort)
+	str("PROD_DOMAIN", &cfg.Domain)
+	str("DB_PATH", &cfg.DBPath)
+	str("MEDIA_DIR", &cfg.MediaDir)
+	str("SESSION_KEY", &cfg.SessionKey)
+	str("ADMIN_EMAIL", &cfg.AdminEmail)
+	str("ADMIN_PASSWORD", &cfg.AdminPassword)
+	duration("JOB_INTERVAL", &cfg.JobInterval)
+	duration("SCORE_HALF_LIFE", &cfg.ScoreHalfLife)
+	duration("READ_TIMEOUT", &cfg.Timeouts.Read)
+	duration("WRITE_TIMEOUT", &cfg.Timeouts.Write)
+	duration("IDLE_TIMEOUT", &cfg.Timeouts.Idle)
+	duration("SHUTDOWN_TIMEOUT", &cfg.Timeouts.Shutdown)
+
+	str("SCRAPER_USER_AGENT", &cfg.Scraper.UserAgent)
+	integer("SCRAPER_WORKERS", &cfg.Scraper.Workers)
+	duration("SCRAPER_TIMEOUT", &cfg.Scraper.Timeout)
+	duration("SCRAPER_HOST_INTERVAL", &cfg.Scraper.HostInterval)
+	integer("SCRAPER_MAX_RETRIES", &cfg.Scraper.MaxRetries)
+	duration("SCRAPER_PRODUCT_INTERVAL", &cfg.Scraper.ProductInterval)
+
+	str("OPENAI_API_KEY", &cfg.LLM.APIKey)
+	str("OPENAI_MODEL", &cfg.LLM.Model)
+	integer("OPENAI_MAX_TOKENS", &cfg.LLM.MaxTokens)
+	duration("OPENAI_TIMEOUT", &cfg.LLM.Timeout)
+	integer("OPENAI_DAILY_TOKEN_BUDGET", &cfg.LLM.DailyTokenBudget)
+	integer("OPENAI_MONTHLY_TOKEN_BUDGET", &cfg.LLM.MonthlyTokenBudget)
+	float("OPENAI_DAILY_SPEND_BUDGET", &cfg.LLM.DailySpendBudget)
+	float("OPENAI_MONTHLY_SPEND_BUDGET", &cfg.LLM.MonthlySpendBudget)
+	integer("OPENAI_MAX_ATTEMPTS", &cfg.LLM.MaxAttempts)
+	duration("OPENAI_RETRY_BACKOFF", &cfg.LLM.RetryBackoff)
+
+	str("OCR_COMMAND", &cfg.OCR.Command)
+	str("OCR_LANGUAGES", &cfg.OCR.Languages)
+	duration("OCR_TIMEOUT", &cfg.OCR.Timeout)
+
+	float("PRICE_DROP_THRESHOLD", &cfg.PriceDrops.Threshold)
+	float("PRICE_DROP_POST_THRESHOLD", &cfg.PriceDrops.PostThreshold)
+
+	duration("TRENDING_WINDOW", &cfg.Trending.Window)
+	integer("TRENDING_LIMIT", &cfg.Trending.Limit)
+
+	str("TGRAM_BOT_API_TOKEN", &cfg.Telegram.BotToken)
+	str("TGRAM_CHAT_ID", &cfg.Telegram.ChatID)
+	duration("ERROR_REPORT_WINDOW", &cfg.ErrorReporting.Window)
+	integer("ERROR_REPORT_MAX_PER_WINDOW", &cfg.ErrorReporting.MaxPerWindow)
+
+	str("SMTP_HOST", &cfg.Mailer.Host)
+	integer("SMTP_PORT", &cfg.Mailer.Port)
+	str("SMTP_USERNAME", &cfg.Mailer.Username)
+	str("SMTP_PASSWORD", &cfg.Mailer.Password)
+	str("MAIL_FROM", &cfg.Mailer.From)
+
+	return errors.Join(errs...)
+}
+
+// Validate reports every problem with the config at once so they can all be fixed in one go
+func (cfg Config) Validate() error {
+	var errs []error
+
+	if cfg.Port == "" {
+		errs = append(errs, errors.New("port is required via -port flag or PORT"))
+	}
+	if cfg.Mode == Prod {
+		if cfg.Domain == "" {
+			errs = append(errs, errors.New("PROD_DOMAIN is required to run the server in prod"))
+		}
+		if cfg.SessionKey == "" {
+			errs = append(errs, errors.New("SESSION_KEY is required to run the server in prod"))
+		}
+	}
+	if cfg.Timeouts.Read <= 0 || cfg.Timeouts.Write <= 0 || cfg.Timeouts.Idle <= 0 || cfg.Timeouts.Shutdown <= 0 {
+		errs = append(errs, errors.New("server timeouts must be greater than 0"))
+	}
+	if err := cfg.ValidateJobs(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateJobs reports problems with everything but the http server, for commands like ingest
+// that run the jobs on their own
+func (cfg Config) ValidateJobs() error {
+	var errs []error
+
+	if cfg.Mode != Dev && cfg.Mode != Prod {
+		errs = append(errs, fmt.Errorf("mode must be %q or %q got %q", Dev, Prod, cfg.Mode))
+	}
+	if cfg.DBPath == "" {
+		errs = append(errs, errors.New("database path must not be empty"))
+	}
+	if cfg.MediaDir == "" {
+		errs = append(errs, errors.New("media dir must not be empty"))
+	}
+	if cfg.JobInterval <= 0 {
+		errs = append(errs, errors.New("job interval must be greater than 0"))
+	}
+	if cfg.ScoreHalfLife <= 0 {
+		errs = append(errs, errors.New("SCORE_HALF_LIFE must be greater than 0"))
+	}
+	for _, code := range cfg.TranslateLocales {
+		if _, ok := getLocale(code); !ok || code == defaultLocale {
+			errs = append(errs, fmt.Errorf("TRANSLATE_LOCALES has %q which is not a locale posts can be translated into", code))
+		}
+	}
+	if cfg.Scraper.UserAgent == "" {
+		errs = append(errs, errors.New("SCRAPER_USER_AGENT must not be empty"))
+	}
+	if cfg.Scraper.Workers < 1 {
+		errs = append(errs, errors.New("SCRAPER_WORKERS must be at least 1"))
+	}
+	if cfg.Scraper.Timeout <= 0 || cfg.Scraper.HostInterval <= 0 {
+		errs = append(errs, errors.New("SCRAPER_TIMEOUT and SCRAPER_HOST_INTERVAL must be greater than 0"))
+	}
+	if cfg.Scraper.MaxRetries < 0 {
+		errs = append(errs, errors.New("SCRAPER_MAX_RETRIES must not be negative"))
+	}
+	if cfg.Scraper.ProductInterval <= 0 {
+		errs = append(errs, errors.New("SCRAPER_PRODUCT_INTERVAL must be greater than 0"))
+	}
+	if !cfg.Skip && cfg.LLM.APIKey == "" {
+		errs = append(errs, errors.New("OPENAI_API_KEY is required unless jobs are skipped with -skip"))
+	}
+	if cfg.LLM.Model == "" {
+		errs = append(errs, errors.New("OPENAI_MODEL must not be empty"))
+	}
+	if cfg.LLM.MaxTokens <= 0 {
+		errs = append(errs, errors.New("OPENAI_MAX_TOKENS must be greater than 0"))
+	}
+	if cfg.LLM.Timeout <= 0 {
+		errs = append(errs, errors.New("OPENAI_TIMEOUT must be greater than 0"))
+	}
+	if cfg.LLM.DailyTokenBudget < 0 || cfg.LLM.MonthlyTokenBudget < 0 || cfg.LLM.DailySpendBudget < 0 || cfg.LLM.MonthlySpendBudget < 0 {
+		errs = append(errs, errors.New("OPENAI budgets must not be negative"))
+	}
+	if cfg.LLM.MaxAttempts <= 0 || cfg.LLM.RetryBackoff <= 0 {
+		errs = append(errs, errors.New("OPENAI_MAX_ATTEMPTS and OPENAI_RETRY_BACKOFF must be greater than 0"))
+	}
+	if cfg.OCR.Command != "" && cfg.OCR.Timeout <= 0 {
+		errs = append(errs, errors.New("OCR_TIMEOUT must be greater than 0"))
+	}
+	if cfg.PriceDrops.Threshold <= 0 || cfg.PriceDrops.Threshold >= 1 {
+		errs = append(errs, errors.New("PRICE_DROP_THRESHOLD must be a fraction between 0 and 1, e.g. 0.1 for 10%"))
+	}
+	if cfg.PriceDrops.PostThreshold < cfg.PriceDrops.Threshold || cfg.PriceDrops.PostThreshold >= 1 {
+		errs = append(errs, errors.New("PRICE_DROP_POST_THRESHOLD must be at least PRICE_DROP_THRESHOLD and less than 1"))
+	}
+	if cfg.Trending.Window <= 0 {
+		errs = append(errs, errors.New("TRENDING_WINDOW must be greater than 0"))
+	}
+	if cfg.Trending.Limit < 1 {
+		errs = append(errs, errors.New("TRENDING_LIMIT must be at least 1"))
+	}
+	if (cfg.Telegram.BotToken == "") != (cfg.Telegram.ChatID == "") {
+		errs = append(errs, errors.New("TGRAM_BOT_API_TOKEN and TGRAM_CHAT_ID must be supplied together"))
+	}
+	if cfg.ErrorReporting.Window <= 0 {
+		errs = append(errs, errors.New("ERROR_REPORT_WINDOW must be greater than 0"))
+	}
+	if cfg.ErrorReporting.MaxPerWindow < 0 {
+		errs = append(errs, errors.New("ERROR_REPORT_MAX_PER_WINDOW must not be negative"))
+	}
+	if cfg.Mailer.Enabled() {
+		if cfg.Mailer.Port <= 0 {
+			errs = append(errs, errors.New("SMTP_PORT must be greater than 0"))
+		}
+		if cfg.Mailer.From == "" {
+			errs = append(errs, errors.New("MAIL_FROM is required when SMTP_HOST is set"))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "test")
+	t.Setenv("PORT", "4000")
+	t.Setenv("JOB_INTERVAL", "10m")
+
+	cfg, err := loadConfig([]string{"-config", "testdata/does-not-exist.env", "-port", "3000"})
+	if err == nil {
+		t.Fatal("expected an error for a missing config file that was asked for explicitly")
+	}
+
+	cfg, err = loadConfig([]string{"-port", "3000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != "3000" {
+		t.Errorf("expected flag to override env, got port %s", cfg.Port)
+	}
+	if cfg.JobInterval.Minutes() != 10 {
+		t.Errorf("expected job interval from env, got %s", cfg.JobInterval)
+	}
+	if cfg.Domain != "http://localhost:3000" {
+		t.Errorf("expected localhost domain in dev, got %s", cfg.Domain)
+	}
+}
+
+func TestValidateConfigProd(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Port = "3000"
+	cfg.Mode = Prod
+	cfg.Skip = true
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected prod config without a session key or domain to be invalid")
+	}
+	for _, want := range []string{"SESSION_KEY", "PROD_DOMAIN"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to mention %s, got %v", want, err)
+		}
+	}
+}
+
+func TestValidateJobs(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Skip = true
+	if err := cfg.ValidateJobs(); err != nil {
+		t.Errorf("expected the jobs not to need a port, got %v", err)
+	}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "port") {
+		t.Errorf("expected the server to need a port, got %v", err)
+	}
+
+	cfg.Skip = false
+	if err := cfg.ValidateJobs(); err == nil || !strings.Contains(err.Error(), "OPENAI_API_KEY") {
+		t.Errorf("expected the llm jobs to need an api key, got %v", err)
+	}
+}
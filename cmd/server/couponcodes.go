@@ -51,8 +51,13 @@ func (s *Service) CreateCouponCode(coupon CouponCode) error {
 	return nil
 }
 
+// undatedCouponLife is how long a coupon without an expiry date is treated as active
+const undatedCouponLife = 30 * 24 * time.Hour
+
 type getCouponParams struct {
 	WebsiteID, Limit, Offset int
+	// ActiveAt limits coupons to those still valid at this time when set
+	ActiveAt time.Time
 }
 
 func (s *Service) GetCoupons(params getCouponParams) ([]CouponCode, error) {
@@ -61,21 +66,30 @@ func (s *Service) GetCoupons(params getCouponParams) ([]CouponCode, error) {
 
 	query.WriteString(`
 	SELECT
+		id,
 		code,
 		description,
 		valid_until,
 		first_seen,
 		website_id
 	FROM
-		coupon_codes`)
+		coupon_codes
+	WHERE
+		(post_id IS NULL OR post_id IN (SELECT id FROM posts WHERE status = ?))`)
 
-	args := []any{}
+	// coupons found before posts were moderated have no post
+	args := []any{postApproved}
 
 	if params.WebsiteID > 0 {
-		query.WriteString(` WHERE website_id = ?`)
+		query.WriteString(` AND website_id = ?`)
 		args = append(args, params.WebsiteID)
 	}
 
+	if !params.ActiveAt.IsZero() {
+		query.WriteString(` AND (valid_until >= ? OR (valid_until IS NULL AND first_seen >= ?))`)
+		args = append(args, params.ActiveAt.UTC(), params.ActiveAt.Add(-undatedCouponLife).UTC())
+	}
+
 	query.WriteString(`	ORDER BY
 		id DESC`)
 
@@ -99,6 +113,7 @@ func (s *Service) GetCoupons(params getCouponParams) ([]CouponCode, error) {
 	for rows.Next() {
 		var coupon CouponCode
 		err := rows.Scan(
+			&coupon.ID,
 			&coupon.Code,
 			&coupon.Description,
 			&coupon.ValidUntil,
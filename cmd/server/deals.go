@@ -0,0 +1,326 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Besides the free text description every post carries the offer as structured fields so the
+feed can be filtered to, say, 30% off or free delivery. The llm fills them in through the deal
+object of the extract_offers schema and the rules below fill the gaps from the description.
+A field the banner doesn't mention is NULL or false.
+
+Dates are stored in UTC so they compare correctly as text.
+*/
+
+type Deal struct {
+	// PercentOff is the largest percentage off, so "up to 50% off" is 50
+	PercentOff *float64 `json:"percent_off"`
+	// AmountOff is in euro
+	AmountOff *float64 `json:"amount_off"`
+	// MinSpend is what has to be spent, in euro, for the offer to apply
+	MinSpend     *float64   `json:"min_spend"`
+	FreeGift     bool       `json:"free_gift"`
+	FreeShipping bool       `json:"free_shipping"`
+	BOGOF        bool       `json:"bogof"`
+	StartsAt     *time.Time `json:"starts_at"`
+	EndsAt       *time.Time `json:"ends_at"`
+	// set on price drops from our own price history, never by the llm, see pricedrops.go
+	LowestIn90Days bool `json:"-"`
+	AllTimeLow     bool `json:"-"`
+}
+
+// maxAmountOff is the largest euro discount believed, bigger is usually a price read as a discount
+const maxAmountOff = 500
+
+var (
+	percentOffPattern   = regexp.MustCompile(`(?i)\b(up\s+to\s+)?(\d{1,2})\s?%\s*off\b`)
+	euroOffPattern      = regexp.MustCompile(`(?i)(up\s+to\s+)?€\s?(\d{1,4}(?:[.,]\d{2})?)\s*off\b`)
+	minSpendPattern     = regexp.MustCompile(`(?i)\b(?:spend(?:ing)?(?:\s+over)?|orders?\s+(?:over|above|of)|minimum\s+spend(?:\s+of)?)\s+€\s?(\d{1,4}(?:[.,]\d{2})?)`)
+	freeShippingPattern = regexp.MustCompile(`(?i)\bfree\s+(?:standard\s+|express\s+|next[\s-]day\s+)?(?:delivery|shipping|postage|p&p)\b`)
+	freeGiftPattern     = regexp.MustCompile(`(?i)\b(?:free\s+(?:\w+\s+)?gift|gift\s+with\s+(?:purchase|every\s+order)|gwp)\b`)
+	bogofPattern        = regexp.MustCompile(`(?i)\b(?:bogof?|buy\s+(?:one|1),?\s+get\s+(?:one|1)(?:\s+free)?|2\s+for\s+1)\b`)
+
+	// 25th May, 25 May 2025
+	dayMonthPattern = regexp.MustCompile(`(?i)\b(\d{1,2})(?:st|nd|rd|th)?\s+(jan|feb|mar|apr|may|jun|jul|aug|sep|sept|oct|nov|dec)[a-z]*\.?(?:\s+(\d{4}))?\b`)
+	// 25/05, 25.05.2025, 25/05/25
+	numericDatePattern = regexp.MustCompile(`\b(\d{1,2})[/.](\d{1,2})(?:[/.](\d{2}|\d{4}))?\b`)
+	// words that put a date at the end of an offer
+	endsPattern = regexp.MustCompile(`(?i)\b(ends?|until|till|til|expires?|valid\s+to|last\s+day)\b`)
+	// words that put a date at the start of an offer
+	startsPattern = regexp.MustCompile(`(?i)\b(starts?|starting|from|begins?)\b`)
+)
+
+var months = map[string]time.Month{
+	"jan": time.January, "feb": time.February, "mar": time.March, "apr": time.April,
+	"may": time.May, "jun": time.June, "jul": time.July, "aug": time.August,
+	"sep": time.September, "sept": time.September, "oct": time.October, "nov": time.November,
+	"dec": time.December,
+}
+
+// dealFromText applies the extraction rules to text, a banner or a description of one
+func dealFromText(text string, now time.Time) Deal {
+	text = strings.Join(strings.Fields(text), " ")
+	var deal Deal
+
+	for _, m := range percentOffPattern.FindAllStringSubmatch(text, -1) {
+		if n, _ := strconv.ParseFloat(m[2], 64); n > 0 && (deal.PercentOff == nil || n > *deal.PercentOff) {
+			deal.PercentOff = &n
+		}
+	}
+	for _, m := range euroOffPattern.FindAllStringSubmatch(text, -1) {
+		if n := parseEuro(m[2]); n > 0 && (deal.AmountOff == nil || n > *deal.AmountOff) {
+			deal.AmountOff = &n
+		}
+	}
+	if m := minSpendPattern.FindStringSubmatch(text); m != nil {
+		if n := parseEuro(m[1]); n > 0 {
+			deal.MinSpend = &n
+		}
+	}
+	deal.FreeShipping = freeShippingPattern.MatchString(text)
+	deal.FreeGift = freeGiftPattern.MatchString(text)
+	deal.BOGOF = bogofPattern.MatchString(text)
+	deal.StartsAt = dateAfter(text, startsPattern, now, false)
+	deal.EndsAt = extractEndDate(text, now)
+	return deal
+}
+
+func parseEuro(s string) float64 {
+	n, _ := strconv.ParseFloat(strings.ReplaceAll(s, ",", "."), 64)
+	return n
+}
+
+// extractEndDate finds the date an offer ends. Only dates after a word like "ends" or "until"
+// count, a date on its own is as likely to be when the sale started. Dates without a year are
+// taken to be the next one on or after now.
+func extractEndDate(text string, now time.Time) *time.Time {
+	return dateAfter(text, endsPattern, now, true)
+}
+
+// dateAfter finds a date closely following a match of words. Ends are the end of the day and
+// roll over to next year when the date has passed, starts are the beginning of the day.
+func dateAfter(text string, words *regexp.Regexp, now time.Time, end bool) *time.Time {
+	loc := words.FindStringIndex(text)
+	if loc == nil {
+		return nil
+	}
+	rest := text[loc[1]:]
+	// the date should follow closely, not be somewhere else on the banner
+	if len(rest) > 40 {
+		rest = rest[:40]
+	}
+
+	var day, year int
+	var month time.Month
+	if m := dayMonthPattern.FindStringSubmatch(rest); m != nil {
+		day, _ = strconv.Atoi(m[1])
+		month = months[strings.ToLower(m[2])]
+		year, _ = strconv.Atoi(m[3])
+	} else if m := numericDatePattern.FindStringSubmatch(rest); m != nil {
+		day, _ = strconv.Atoi(m[1])
+		n, _ := strconv.Atoi(m[2])
+		month = time.Month(n)
+		year, _ = strconv.Atoi(m[3])
+		if year > 0 && year < 100 {
+			year += 2000
+		}
+	} else {
+		return nil
+	}
+	if day < 1 || day > 31 || month < time.January || month > time.December {
+		return nil
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if year == 0 {
+		year = now.Year()
+		if end && time.Date(year, month, day, 0, 0, 0, 0, now.Location()).Before(today) {
+			year++
+		}
+	}
+	t := time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+	if end {
+		t = time.Date(year, month, day, 23, 59, 59, 0, now.Location())
+	}
+	if t.Day() != day {
+		// 31st of a 30 day month
+		return nil
+	}
+	return &t
+}
+
+// cleanDeal fills the fields of deal the llm missed from text and drops implausible values
+func cleanDeal(deal *Deal, text string, now time.Time, review *offerReview) {
+	found := dealFromText(text, now)
+	if deal.PercentOff == nil {
+		deal.PercentOff = found.PercentOff
+	}
+	if deal.AmountOff == nil {
+		deal.AmountOff = found.AmountOff
+	}
+	if deal.MinSpend == nil {
+		deal.MinSpend = found.MinSpend
+	}
+	deal.FreeShipping = deal.FreeShipping || found.FreeShipping
+	deal.FreeGift = deal.FreeGift || found.FreeGift
+	deal.BOGOF = deal.BOGOF || found.BOGOF
+	if deal.StartsAt == nil {
+		deal.StartsAt = found.StartsAt
+	}
+	if deal.EndsAt == nil {
+		deal.EndsAt = found.EndsAt
+	}
+
+	if deal.PercentOff != nil && (*deal.PercentOff <= 0 || *deal.PercentOff >= 100) {
+		review.flag(0.2, "%v%% off is implausible and was dropped", *deal.PercentOff)
+		deal.PercentOff = nil
+	}
+	if deal.AmountOff != nil && (*deal.AmountOff <= 0 || *deal.AmountOff > maxAmountOff) {
+		review.flag(0.2, "€%v off is implausible and was dropped", *deal.AmountOff)
+		deal.AmountOff = nil
+	}
+	if deal.MinSpend != nil && *deal.MinSpend <= 0 {
+		deal.MinSpend = nil
+	}
+
+	if deal.EndsAt != nil {
+		switch {
+		case deal.EndsAt.Before(now):
+			review.flag(0.15, "the offer end %s is in the past and was dropped", deal.EndsAt.Format(time.DateOnly))
+			deal.EndsAt = nil
+		case deal.EndsAt.After(now.Add(maxCouponValidity)):
+			review.flag(0.15, "the offer end %s is too far ahead and was clamped", deal.EndsAt.Format(time.DateOnly))
+			clamped := now.Add(maxCouponValidity)
+			deal.EndsAt = &clamped
+		}
+	}
+	if deal.StartsAt != nil && deal.EndsAt != nil && deal.StartsAt.After(*deal.EndsAt) {
+		review.flag(0.1, "the offer starts %s after it ends and the start was dropped", deal.StartsAt.Format(time.DateOnly))
+		deal.StartsAt = nil
+	}
+
+	if deal.StartsAt != nil {
+		utc := deal.StartsAt.UTC()
+		deal.StartsAt = &utc
+	}
+	if deal.EndsAt != nil {
+		utc := deal.EndsAt.UTC()
+		deal.EndsAt = &utc
+	}
+}
+
+// labels describes deal for a post in locale, e.g. "30% off" and "Free delivery"
+func (d Deal) labels(locale string) []string {
+	var labels []string
+	if d.PercentOff != nil {
+		labels = append(labels, translate(locale, "%s%% off", formatNumber(*d.PercentOff)))
+	}
+	if d.AmountOff != nil {
+		labels = append(labels, translate(locale, "€%s off", formatNumber(*d.AmountOff)))
+	}
+	if d.MinSpend != nil {
+		labels = append(labels, translate(locale, "Min. spend €%s", formatNumber(*d.MinSpend)))
+	}
+	if d.BOGOF {
+		labels = append(labels, translate(locale, "Buy one get one free"))
+	}
+	if d.FreeGift {
+		labels = append(labels, translate(locale, "Free gift"))
+	}
+	if d.FreeShipping {
+		labels = append(labels, translate(locale, "Free delivery"))
+	}
+	if d.AllTimeLow {
+		labels = append(labels, translate(locale, "Lowest price ever"))
+	} else if d.LowestIn90Days {
+		labels = append(labels, translate(locale, "Lowest price in 90 days"))
+	}
+	if d.EndsAt != nil {
+		labels = append(labels, translate(locale, "Ends %s", d.EndsAt.Format("02/01")))
+	}
+	return labels
+}
+
+// formatNumber drops the decimals of whole amounts
+func formatNumber(n float64) string {
+	if n == float64(int64(n)) {
+		return strconv.FormatInt(int64(n), 10)
+	}
+	return strconv.FormatFloat(n, 'f', 2, 64)
+}
+
+// dealFilter narrows and orders the feed by deal attributes, see parseDealFilter
+type dealFilter struct {
+	MinPercent   int
+	FreeShipping bool
+	FreeGift     bool
+	BOGOF        bool
+	// Sort is "" for the usual feed order, "newest", "discount" or "ending"
+	Sort string
+}
+
+// discountOptions are the minimum discounts offered in the feed's filter
+var discountOptions = []int{10, 20, 30, 50}
+
+var dealSorts = map[string]bool{"newest": true, "discount": true, "ending": true}
+
+// parseDealFilter reads the feed's filter form, anything unexpected is ignored
+func parseDealFilter(q url.Values) dealFilter {
+	var f dealFilter
+	if n, err := strconv.Atoi(q.Get("min_percent")); err == nil && n > 0 && n < 100 {
+		f.MinPercent = n
+	}
+	f.FreeShipping = q.Get("free_shipping") != ""
+	f.FreeGift = q.Get("free_gift") != ""
+	f.BOGOF = q.Get("bogof") != ""
+	if dealSorts[q.Get("sort")] {
+		f.Sort = q.Get("sort")
+	}
+	return f
+}
+
+func (f dealFilter) Active() bool {
+	return f != dealFilter{}
+}
+
+// conditions returns the where clauses and their args the filter adds to a posts query
+func (f dealFilter) conditions(now time.Time) ([]string, []any) {
+	var conditions []string
+	var args []any
+	if f.MinPercent > 0 {
+		conditions = append(conditions, "percent_off >= ?")
+		args = append(args, f.MinPercent)
+	}
+	if f.FreeShipping {
+		conditions = append(conditions, "free_shipping = 1")
+	}
+	if f.FreeGift {
+		conditions = append(conditions, "free_gift = 1")
+	}
+	if f.BOGOF {
+		conditions = append(conditions, "bogof = 1")
+	}
+	if f.Sort == "ending" {
+		conditions = append(conditions, "ends_at >= ?")
+		args = append(args, now.UTC())
+	}
+	return conditions, args
+}
+
+// orderBy is the order by clause for Sort, "" keeps the caller's
+func (f dealFilter) orderBy() string {
+	switch f.Sort {
+	case "newest":
+		return "timestamp DESC"
+	case "discount":
+		return "COALESCE(percent_off, 0) DESC, COALESCE(amount_off, 0) DESC, timestamp DESC"
+	case "ending":
+		return "ends_at ASC"
+	}
+	return ""
+}
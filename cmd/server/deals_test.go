@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestDealFromText(t *testing.T) {
+	now := time.Date(2025, time.May, 10, 12, 0, 0, 0, time.UTC)
+
+	deal := dealFromText("Up to 40% off skincare, 20% off make-up. €10 off when you spend €50. Free delivery and a free mini gift. Starts 12th May, ends 25th May", now)
+	if deal.PercentOff == nil || *deal.PercentOff != 40 {
+		t.Errorf("expected 40%% off, got %v", deal.PercentOff)
+	}
+	if deal.AmountOff == nil || *deal.AmountOff != 10 {
+		t.Errorf("expected €10 off, got %v", deal.AmountOff)
+	}
+	if deal.MinSpend == nil || *deal.MinSpend != 50 {
+		t.Errorf("expected a minimum spend of €50, got %v", deal.MinSpend)
+	}
+	if !deal.FreeShipping || !deal.FreeGift || deal.BOGOF {
+		t.Errorf("expected free delivery and a free gift only, got %+v", deal)
+	}
+	if deal.StartsAt == nil || deal.StartsAt.Format(time.DateOnly) != "2025-05-12" {
+		t.Errorf("expected the deal to start on 2025-05-12, got %v", deal.StartsAt)
+	}
+	if deal.EndsAt == nil || deal.EndsAt.Format(time.DateOnly) != "2025-05-25" {
+		t.Errorf("expected the deal to end on 2025-05-25, got %v", deal.EndsAt)
+	}
+
+	if deal := dealFromText("Buy one, get one free on all lipsticks", now); !deal.BOGOF {
+		t.Error("expected buy one get one free")
+	}
+	if deal := dealFromText("New in from your favourite brands", now); deal != (Deal{}) {
+		t.Errorf("expected an empty deal, got %+v", deal)
+	}
+}
+
+func TestCleanDeal(t *testing.T) {
+	now := time.Date(2025, time.May, 10, 12, 0, 0, 0, time.UTC)
+	ptr := func(n float64) *float64 { return &n }
+	past := now.AddDate(0, 0, -1)
+
+	review := offerReview{Confidence: 1}
+	deal := Deal{PercentOff: ptr(150), AmountOff: ptr(2000), EndsAt: &past}
+	cleanDeal(&deal, "Boots is running a promotion: 25% off and free delivery", now, &review)
+
+	// the implausible percentage is filled from the text before it's checked
+	if deal.PercentOff != nil {
+		t.Errorf("expected the implausible percentage to be dropped, got %v", *deal.PercentOff)
+	}
+	if deal.AmountOff != nil {
+		t.Errorf("expected the implausible amount to be dropped, got %v", *deal.AmountOff)
+	}
+	if deal.EndsAt != nil {
+		t.Errorf("expected the past end date to be dropped, got %v", deal.EndsAt)
+	}
+	if !deal.FreeShipping {
+		t.Error("expected free delivery to be filled in from the text")
+	}
+	if len(review.Issues) != 3 {
+		t.Errorf("expected 3 issues, got %q", review.Issues)
+	}
+
+	review = offerReview{Confidence: 1}
+	deal = Deal{}
+	cleanDeal(&deal, "30% off everything until 25th May", now, &review)
+	if deal.PercentOff == nil || *deal.PercentOff != 30 {
+		t.Errorf("expected 30%% off, got %v", deal.PercentOff)
+	}
+	if deal.EndsAt == nil || deal.EndsAt.Location() != time.UTC {
+		t.Errorf("expected an end date in UTC, got %v", deal.EndsAt)
+	}
+	if len(review.Issues) != 0 {
+		t.Errorf("expected no issues, got %q", review.Issues)
+	}
+}
+
+func TestParseDealFilter(t *testing.T) {
+	q, _ := url.ParseQuery("min_percent=30&free_shipping=1&sort=discount")
+	f := parseDealFilter(q)
+	if want := (dealFilter{MinPercent: 30, FreeShipping: true, Sort: "discount"}); f != want {
+		t.Errorf("got %+v, want %+v", f, want)
+	}
+	if !f.Active() {
+		t.Error("expected the filter to be active")
+	}
+
+	q, _ = url.ParseQuery("min_percent=abc&sort=price")
+	if f := parseDealFilter(q); f.Active() {
+		t.Errorf("expected unexpected values to be ignored, got %+v", f)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+/*
+Shopify, Magento and Next.js shops render a lot of their carousel content from JSON embedded in
+the page. Pulling that out once, before the per website rules run, lets the rules read banner
+images, links and promo text from structured data instead of depending on positional selectors.
+*/
+
+// EmbeddedData is the structured data found in a page
+type EmbeddedData struct {
+	// JSONLD holds every object from ld+json scripts with arrays and @graph flattened
+	JSONLD []map[string]any
+	// NextData is the parsed __NEXT_DATA__ script of a Next.js page, if any
+	NextData any
+	// Sections are application/json scripts keyed by id or data-section-id, as used by Shopify themes
+	Sections map[string]any
+	// Attributes are data-* attributes whose value is a JSON object or array
+	Attributes []AttributeJSON
+}
+
+// AttributeJSON is a JSON value parsed from an element attribute
+type AttributeJSON struct {
+	Name      string
+	Value     any
+	Selection *goquery.Selection
+}
+
+func extractEmbeddedData(doc *goquery.Document) EmbeddedData {
+	data := EmbeddedData{Sections: map[string]any{}}
+
+	doc.Find(`script[type="application/ld+json"]`).Each(func(i int, s *goquery.Selection) {
+		var v any
+		if err := json.Unmarshal([]byte(strings.TrimSpace(s.Text())), &v); err != nil {
+			return
+		}
+		data.JSONLD = append(data.JSONLD, flattenJSONLD(v)...)
+	})
+
+	if s := doc.Find(`script#__NEXT_DATA__`).First(); s.Length() > 0 {
+		var v any
+		if err := json.Unmarshal([]byte(strings.TrimSpace(s.Text())), &v); err == nil {
+			data.NextData = v
+		}
+	}
+
+	doc.Find(`script[type="application/json"]`).Each(func(i int, s *goquery.Selection) {
+		key := s.AttrOr("data-section-id", s.AttrOr("id", ""))
+		if key == "" || key == "__NEXT_DATA__" {
+			return
+		}
+		var v any
+		if err := json.Unmarshal([]byte(strings.TrimSpace(s.Text())), &v); err != nil {
+			return
+		}
+		data.Sections[key] = v
+	})
+
+	doc.Find("*").Each(func(i int, s *goquery.Selection) {
+		for _, node := range s.Nodes {
+			for _, attr := range node.Attr {
+				if !strings.HasPrefix(attr.Key, "data-") {
+					continue
+				}
+				if v, ok := parseAttributeJSON(attr.Val); ok {
+					data.Attributes = append(data.Attributes, AttributeJSON{Name: attr.Key, Value: v, Selection: s})
+				}
+			}
+		}
+	})
+
+	return data
+}
+
+// parseAttributeJSON parses attribute values that hold JSON. Magento page builder escapes the
+// quotes inside its attributes so those are unescaped first.
+func parseAttributeJSON(value string) (any, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) < 2 || !(value[0] == '{' || value[0] == '[') {
+		return nil, false
+	}
+	var v any
+	if err := json.Unmarshal([]byte(value), &v); err == nil {
+		return v, true
+	}
+	if err := json.Unmarshal([]byte(strings.ReplaceAll(value, `\"`, `"`)), &v); err == nil {
+		return v, true
+	}
+	return nil, false
+}
+
+func flattenJSONLD(v any) []map[string]any {
+	switch t := v.(type) {
+	case []any:
+		var out []map[string]any
+		for _, item := range t {
+			out = append(out, flattenJSONLD(item)...)
+		}
+		return out
+	case map[string]any:
+		out := []map[string]any{t}
+		if graph, ok := t["@graph"]; ok {
+			out = append(out, flattenJSONLD(graph)...)
+		}
+		return out
+	}
+	return nil
+}
+
+// AttributesNamed returns the JSON attributes called name in document order
+func (e EmbeddedData) AttributesNamed(name string) []AttributeJSON {
+	var out []AttributeJSON
+	for _, a := range e.Attributes {
+		if a.Name == name {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// JSONLDOfType returns the ld+json objects whose @type is, or includes, typ
+func (e EmbeddedData) JSONLDOfType(typ string) []map[string]any {
+	var out []map[string]any
+	for _, obj := range e.JSONLD {
+		switch t := obj["@type"].(type) {
+		case string:
+			if t == typ {
+				out = append(out, obj)
+			}
+		case []any:
+			for _, item := range t {
+				if s, ok := item.(string); ok && s == typ {
+					out = append(out, obj)
+					break
+				}
+			}
+		}
+	}
+	return out
+}
+
+var (
+	bannerImageKeys = []string{"mobile_image", "image_mobile", "image", "image_url", "src", "desktop_image"}
+	bannerLinkKeys  = []string{"link", "url", "href", "button_link"}
+	bannerTextKeys  = []string{"heading", "title", "subheading", "text", "button_label", "alt"}
+	imageExtension  = regexp.MustCompile(`(?i)\.(jpe?g|png|webp|gif|avif)(\?|$)`)
+)
+
+// bannersFromJSON walks v looking for objects that describe a slide, i.e. have an image url,
+// and returns them as banners. Used when a website's selectors find nothing.
+func bannersFromJSON(v any, website Website) []BannerData {
+	var out []BannerData
+	seen := map[string]bool{}
+	var walk func(v any)
+	walk = func(v any) {
+		switch t := v.(type) {
+		case map[string]any:
+			banner := BannerData{}
+			for _, key := range bannerImageKeys {
+				if src := jsonString(t, key); imageExtension.MatchString(src) {
+					banner.Src = absoluteURL(website, src)
+					break
+				}
+			}
+			if banner.Src != "" && !seen[banner.Src] {
+				seen[banner.Src] = true
+				for _, key := range bannerLinkKeys {
+					if href := jsonString(t, key); href != "" && !imageExtension.MatchString(href) {
+						banner.Href = absoluteURL(website, href)
+						break
+					}
+				}
+				var text []string
+				for _, key := range bannerTextKeys {
+					if s := strings.TrimSpace(jsonString(t, key)); s != "" {
+						text = append(text, s)
+					}
+				}
+				banner.SupportingText = strings.Join(text, " ")
+				out = append(out, banner)
+			}
+
+			keys := make([]string, 0, len(t))
+			for k := range t {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				walk(t[k])
+			}
+		case []any:
+			for _, child := range t {
+				walk(child)
+			}
+		}
+	}
+	walk(v)
+	return out
+}
+
+// SectionBanners returns slides found in Shopify section data
+func (e EmbeddedData) SectionBanners(website Website) []BannerData {
+	keys := make([]string, 0, len(e.Sections))
+	for k := range e.Sections {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var out []BannerData
+	for _, k := range keys {
+		out = append(out, bannersFromJSON(e.Sections[k], website)...)
+	}
+	return out
+}
+
+// Banners returns slides found in Shopify section data and __NEXT_DATA__
+func (e EmbeddedData) Banners(website Website) []BannerData {
+	out := e.SectionBanners(website)
+	if e.NextData != nil {
+		out = append(out, bannersFromJSON(e.NextData, website)...)
+	}
+	return out
+}
+
+// absoluteURL resolves protocol relative and root relative urls against the website
+func absoluteURL(website Website, value string) string {
+	if strings.HasPrefix(value, "//") {
+		return "https:" + value
+	}
+	if strings.HasPrefix(value, "/") {
+		return strings.TrimSuffix(website.URL, "/") + value
+	}
+	return value
+}
+
+// jsonString returns the string at key in v when v is an object
+func jsonString(v any, key string) string {
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return ""
+	}
+	s, _ := obj[key].(string)
+	return s
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const embeddedPage = `<html><head>
+<script type="application/ld+json">{"@context":"https://schema.org","@graph":[{"@type":"Organization","name":"Shop"},{"@type":["Product"],"name":"Serum"}]}</script>
+<script type="application/json" data-section-id="slideshow">{"blocks":[{"image":"//cdn.shop.ie/files/banner.jpg?v=1","link":"/collections/sale","heading":"20% off"}]}</script>
+</head><body>
+<div data-content-type="slide"><a href="/offers"><div class="pagebuilder-slide-wrapper" data-background-images='{\"desktop_image\":\"https://mc.ie/d.jpg\",\"mobile_image\":\"https://mc.ie/m.jpg\"}'><p>Save on SPF</p></div></a></div>
+</body></html>`
+
+func TestExtractEmbeddedData(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(embeddedPage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	embedded := extractEmbeddedData(doc)
+
+	if len(embedded.JSONLDOfType("Product")) != 1 || len(embedded.JSONLDOfType("Organization")) != 1 {
+		t.Errorf("expected @graph to be flattened got %v", embedded.JSONLD)
+	}
+
+	attrs := embedded.AttributesNamed("data-background-images")
+	if len(attrs) != 1 || jsonString(attrs[0].Value, "mobile_image") != "https://mc.ie/m.jpg" {
+		t.Fatalf("expected escaped magento attribute json to be parsed got %+v", attrs)
+	}
+
+	website := Website{URL: "https://www.shop.ie"}
+	banners := embedded.Banners(website)
+	if len(banners) != 1 {
+		t.Fatalf("expected one banner from section json got %+v", banners)
+	}
+	expected := BannerData{
+		Src:            "https://cdn.shop.ie/files/banner.jpg?v=1",
+		Href:           "https://www.shop.ie/collections/sale",
+		SupportingText: "20% off",
+	}
+	if banners[0] != expected {
+		t.Errorf("expected %+v got %+v", expected, banners[0])
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/seanomeara96/tgram"
+)
+
+// ErrorReporter is anything that can be told about an error. Report returns the
+// error it was given so it can be used inline, e.g. return reportErr(err)
+type ErrorReporter interface {
+	Report(err error) error
+}
+
+// ErrorReporterFunc adapts a plain function to the ErrorReporter interface
+type ErrorReporterFunc func(error) error
+
+func (f ErrorReporterFunc) Report(err error) error {
+	return f(err)
+}
+
+// logReporter writes errors to the standard logger. It is used when nothing else is configured
+// and as the fallback when another reporter fails.
+type logReporter struct{}
+
+func (logReporter) Report(err error) error {
+	if err != nil {
+		log.Printf("error: %v", err)
+	}
+	return err
+}
+
+func newTelegramReporter(cfg TelegramConfig) (ErrorReporter, error) {
+	send, err := tgram.NewErrorReporter("beautybargains.ie", cfg.BotToken, cfg.ChatID)
+	if err != nil {
+		return nil, err
+	}
+	return fallbackReporter{primary: ErrorReporterFunc(send), fallback: logReporter{}}, nil
+}
+
+// fallbackReporter reports to fallback when primary fails to deliver
+type fallbackReporter struct {
+	primary  ErrorReporter
+	fallback ErrorReporter
+}
+
+func (f fallbackReporter) Report(err error) error {
+	if err == nil {
+		return nil
+	}
+	if sendErr := f.primary.Report(err); sendErr != nil && !errors.Is(sendErr, err) {
+		f.fallback.Report(fmt.Errorf("failed to report error (%v): %w", sendErr, err))
+	}
+	return err
+}
+
+// multiReporter reports every error to each of its reporters
+type multiReporter []ErrorReporter
+
+func (m multiReporter) Report(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, r := range m {
+		r.Report(err)
+	}
+	return err
+}
+
+var fingerprintNumbers = regexp.MustCompile(`\d+`)
+
+// errorFingerprint groups errors that only differ by ids, counts or timestamps
+func errorFingerprint(err error) string {
+	sum := sha1.Sum([]byte(fingerprintNumbers.ReplaceAllString(err.Error(), "N")))
+	return hex.EncodeToString(sum[:])
+}
+
+// rateLimitedReporter drops errors that have already been reported within window and caps
+// the total number of errors passed on per window so a failing dependency can't flood next.
+type rateLimitedReporter struct {
+	next   ErrorReporter
+	window time.Duration
+	max    int
+	now    func() time.Time
+
+	mu          sync.Mutex
+	lastSent    map[string]time.Time
+	windowStart time.Time
+	sent        int
+	suppressed  int
+}
+
+func newRateLimitedReporter(next ErrorReporter, window time.Duration, max int) *rateLimitedReporter {
+	return &rateLimitedReporter{
+		next:     next,
+		window:   window,
+		max:      max,
+		now:      time.Now,
+		lastSent: map[string]time.Time{},
+	}
+}
+
+func (r *rateLimitedReporter) Report(err error) error {
+	if err == nil {
+		return nil
+	}
+	if !r.allow(errorFingerprint(err)) {
+		return err
+	}
+	return r.next.Report(err)
+}
+
+func (r *rateLimitedReporter) allow(fingerprint string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if now.Sub(r.windowStart) >= r.window {
+		if r.suppressed > 0 {
+			log.Printf("error reporter suppressed %d errors in the last %s", r.suppressed, r.window)
+		}
+		r.windowStart, r.sent, r.suppressed = now, 0, 0
+		for k, t := range r.lastSent {
+			if now.Sub(t) >= r.window {
+				delete(r.lastSent, k)
+			}
+		}
+	}
+
+	if last, ok := r.lastSent[fingerprint]; ok && now.Sub(last) < r.window {
+		r.suppressed++
+		return false
+	}
+	if r.max > 0 && r.sent >= r.max {
+		r.suppressed++
+		return false
+	}
+	r.lastSent[fingerprint] = now
+	r.sent++
+	return true
+}
+
+/*
+CREATE TABLE error_groups (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    fingerprint TEXT NOT NULL UNIQUE,
+    message TEXT NOT NULL,
+    count INTEGER NOT NULL DEFAULT 0,
+    first_seen DATETIME NOT NULL,
+    last_seen DATETIME NOT NULL
+);
+*/
+
+// ErrorGroup is every occurrence of errors sharing a fingerprint
+type ErrorGroup struct {
+	ID          int
+	Fingerprint string
+	Message     string // the most recent message in the group
+	Count       int
+	FirstSeen   time.Time
+	LastSeen    time.Time
+}
+
+// sqliteErrorReporter records every error so they can be reviewed on /admin/errors
+type sqliteErrorReporter struct {
+	db       *sql.DB
+	fallback ErrorReporter
+}
+
+func (s sqliteErrorReporter) Report(err error) error {
+	if err == nil {
+		return nil
+	}
+	now := time.Now()
+	if _, dbErr := s.db.Exec(`
+	INSERT INTO
+		error_groups (fingerprint, message, count, first_seen, last_seen)
+	VALUES
+		(?, ?, 1, ?, ?)
+	ON CONFLICT(fingerprint) DO UPDATE SET
+		message = excluded.message,
+		count = count + 1,
+		last_seen = excluded.last_seen`,
+		errorFingerprint(err), err.Error(), now, now,
+	); dbErr != nil && s.fallback != nil {
+		s.fallback.Report(fmt.Errorf("failed to record error (%v): %w", dbErr, err))
+	}
+	return err
+}
+
+func (s *Service) GetErrorGroups(limit, offset int) ([]ErrorGroup, error) {
+	rows, err := s.db.Query(`
+	SELECT
+		id,
+		fingerprint,
+		message,
+		count,
+		first_seen,
+		last_seen
+	FROM
+		error_groups
+	ORDER BY
+		last_seen DESC
+	LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("could not query error groups: %w", err)
+	}
+	defer rows.Close()
+
+	groups := make([]ErrorGroup, 0, limit)
+	for rows.Next() {
+		var g ErrorGroup
+		if err := rows.Scan(&g.ID, &g.Fingerprint, &g.Message, &g.Count, &g.FirstSeen, &g.LastSeen); err != nil {
+			return nil, fmt.Errorf("could not scan error group: %w", err)
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// newErrorReporter records every error in the database and notifies telegram, or the log when
+// telegram isn't configured, at most once per window for each distinct error.
+func newErrorReporter(db *sql.DB, cfg Config) (ErrorReporter, error) {
+	var notify ErrorReporter = logReporter{}
+	if cfg.Telegram.Enabled() {
+		telegram, err := newTelegramReporter(cfg.Telegram)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect the telegram error reporter: %w", err)
+		}
+		notify = telegram
+	}
+
+	return multiReporter{
+		sqliteErrorReporter{db: db, fallback: logReporter{}},
+		newRateLimitedReporter(notify, cfg.ErrorReporting.Window, cfg.ErrorReporting.MaxPerWindow),
+	}, nil
+}
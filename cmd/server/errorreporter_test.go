@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedReporter(t *testing.T) {
+	var reported []string
+	next := ErrorReporterFunc(func(err error) error {
+		reported = append(reported, err.Error())
+		return err
+	})
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := newRateLimitedReporter(next, time.Minute, 2)
+	r.now = func() time.Time { return now }
+
+	r.Report(errors.New("failed to score post 1"))
+	r.Report(errors.New("failed to score post 2")) // same fingerprint
+	r.Report(errors.New("failed to process hashtags"))
+	r.Report(errors.New("database is locked")) // over the per window cap
+
+	if len(reported) != 2 {
+		t.Fatalf("expected 2 errors to be reported got %d: %v", len(reported), reported)
+	}
+
+	now = now.Add(time.Minute)
+	r.Report(errors.New("failed to score post 3"))
+	if len(reported) != 3 {
+		t.Errorf("expected error to be reported again after the window, got %v", reported)
+	}
+}
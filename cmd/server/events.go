@@ -15,6 +15,8 @@ type Event struct {
 	Profile Profile
 	Content Content
 	Meta    EventMeta
+	// Locale is the language the event is shown in
+	Locale string
 }
 
 type Profile struct {
@@ -30,30 +32,59 @@ type Content struct {
 }
 
 type ExtraImage struct {
-	Src string
-	Alt string
+	Src    string
+	Alt    string
+	SrcSet string // optional
+	Width  int    // optional
+	Height int    // optional
 }
 
 type EventMeta struct {
 	CTALink *string
 	Src     *string
 	Likes   int
+	// Status is "live" while the banner is on the retailer's homepage and "ended" after
+	Status string
+	RanFor string // optional, how long an ended promotion ran
+	// MachineExtracted is set when the post was read from the banner without the llm
+	MachineExtracted bool
+	// DealLabels are chips like "30% off" and "Free delivery", see Deal.labels
+	DealLabels []string
+	// PriceAlertLink is where readers watch the product of a price drop post
+	PriceAlertLink string
+	// CompareLink is the price comparison page of the product of a price drop post
+	CompareLink string
 }
 
-func (s *Service) ConvertPostsToEvents(posts []Post) ([]Event, error) {
+// ConvertPostsToEvents shows posts in locale, using their translated descriptions where there are any
+func (s *Service) ConvertPostsToEvents(posts []Post, locale Locale) ([]Event, error) {
+	translations, err := s.getPostTranslations(posts, locale.Code)
+	if err != nil {
+		return nil, err
+	}
+	compareSlugs, err := s.getCompareSlugs(posts)
+	if err != nil {
+		return nil, err
+	}
 	events := make([]Event, 0, len(posts))
 	for _, post := range posts {
-		e, err := s.ConvertPostToEvent(post)
+		if description, ok := translations[post.ID]; ok {
+			post.Description = description
+		}
+		e, err := s.ConvertPostToEvent(post, locale)
 		if err != nil {
 			return nil, err
 		}
+		if slug, ok := compareSlugs[post.ProductID.Int64]; ok && post.ProductID.Valid {
+			e.Meta.CompareLink = locale.Prefix + "/products/" + slug
+		}
 		events = append(events, e)
 	}
 	return events, nil
 }
 
-func (s *Service) ConvertPostToEvent(post Post) (Event, error) {
-	e := Event{}
+func (s *Service) ConvertPostToEvent(post Post, locale Locale) (Event, error) {
+	e := Event{Locale: locale.Code}
 	for _, persona := range getPersonas(0, 0) {
 		if persona.ID == post.AuthorID {
 			e.Profile.Username = persona.Name
@@ -66,22 +97,33 @@ func (s *Service) ConvertPostToEvent(post Post) (Event, error) {
 	hours := int(timeDiff.Hours())
 	days := hours / 24
 
-	var unit string
-	var magnitude int
-	if days > 0 {
-		unit, magnitude = "Days", days
-	} else {
-		unit, magnitude = "Hours", hours
-		if hours == 1 {
-			unit = "Hour"
-		}
+	switch {
+	case days > 0:
+		e.Content.TimeElapsed = translate(locale.Code, "%d Days ago", days)
+	case hours == 1:
+		e.Content.TimeElapsed = translate(locale.Code, "%d Hour ago", hours)
+	default:
+		e.Content.TimeElapsed = translate(locale.Code, "%d Hours ago", hours)
 	}
-
-	e.Content.TimeElapsed = fmt.Sprintf("%d %s ago", magnitude, unit)
 	e.Meta.Src = &post.SrcURL
+	e.Meta.MachineExtracted = post.MachineExtracted
+	e.Meta.DealLabels = post.Deal.labels(locale.Code)
+
+	if post.FirstSeen.Valid && post.LastSeen.Valid {
+		if post.Active {
+			e.Meta.Status = "live"
+		} else {
+			e.Meta.Status = "ended"
+			e.Meta.RanFor = localDuration(locale.Code, post.LastSeen.Time.Sub(post.FirstSeen.Time))
+		}
+	}
 
-	if post.Link.Valid {
-		e.Meta.CTALink = &post.Link.String
+	// outbound links are counted through /go, see clicks.go
+	ctaLink := fmt.Sprintf("/go/%d", post.ID)
+	e.Meta.CTALink = &ctaLink
+	e.Meta.Likes = post.Clicks
+	if post.ProductID.Valid {
+		e.Meta.PriceAlertLink = fmt.Sprintf("%s/alerts/new?product=%d", locale.Prefix, post.ProductID.Int64)
 	}
 
 	extraText := post.Description
@@ -90,7 +132,7 @@ func (s *Service) ConvertPostToEvent(post Post) (Event, error) {
 
 	for _, match := range matches {
 		phrase := strings.ToLower(match[1])
-		extraText = strings.Replace(extraText, match[0], fmt.Sprintf("<a class='text-blue-500' href='/?hashtag=%s'>%s</a>", phrase, match[0]), 1)
+		extraText = strings.Replace(extraText, match[0], fmt.Sprintf("<a class='text-blue-500' href='%s/?hashtag=%s'>%s</a>", locale.Prefix, phrase, match[0]), 1)
 	}
 
 	e.Content.ExtraText = (*template.HTML)(&extraText)
@@ -98,7 +140,11 @@ func (s *Service) ConvertPostToEvent(post Post) (Event, error) {
 	if err != nil {
 		return Event{}, fmt.Errorf("could not get website by id %d: %v", post.WebsiteID, err)
 	}
-	e.Content.Summary = template.HTML(fmt.Sprintf("posted an update about <a href='%s'>%s</a>", website.URL, website.WebsiteName))
-	e.Content.ExtraImages = &[]ExtraImage{{post.SrcURL, ""}}
+	e.Content.Summary = template.HTML(translate(locale.Code, "posted an update about %s", fmt.Sprintf("<a href='%s'>%s</a>", website.URL, website.WebsiteName)))
+	image := ExtraImage{Src: post.SrcURL}
+	if stored, ok := storedImageFromPost(post); ok {
+		image = ExtraImage{Src: stored.URL(), SrcSet: stored.SrcSet(), Width: stored.Width, Height: stored.Height}
+	}
+	e.Content.ExtraImages = &[]ExtraImage{image}
 	return e, nil
 }
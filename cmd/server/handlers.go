@@ -12,6 +12,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/sessions"
@@ -25,7 +26,14 @@ type Handler struct {
 	service           *Service
 	render            *Renderer
 	authenticator     auth.Authenticator
+	trustProxy        bool
 	subscriptionQueue chan SubscriptionPayload
+	// closed by Close to stop the subscription worker
+	subscriptionDone chan struct{}
+	subscriptionWG   sync.WaitGroup
+	closeOnce        sync.Once
+	// clicks limits how many outbound clicks a client has counted, see clicks.go
+	clicks *rateLimiter
 }
 
 type SubscriptionPayload struct {
@@ -33,11 +41,18 @@ type SubscriptionPayload struct {
 	ErrChan chan error
 }
 
+var ErrShuttingDown = errors.New("server is shutting down")
+
+// Close stops the subscription worker and blocks until the request it is
+// currently processing, if any, has finished.
 func (h *Handler) Close() {
-	if h.subscriptionQueue != nil {
-		close(h.subscriptionQueue)
-		h.subscriptionQueue = nil
+	if h.subscriptionDone == nil {
+		return
 	}
+	h.closeOnce.Do(func() {
+		close(h.subscriptionDone)
+	})
+	h.subscriptionWG.Wait()
 }
 
 func (h *Handler) InitSubscriptionWorker() {
@@ -45,14 +60,22 @@ func (h *Handler) InitSubscriptionWorker() {
 	if h.subscriptionQueue == nil {
 		h.subscriptionQueue = make(chan SubscriptionPayload)
 	}
-
-	go func() {
-		for request := range h.subscriptionQueue {
-			// run subscripttionProcess
-			request.ErrChan <- request.Fn()
-			close(request.ErrChan)
+	h.subscriptionDone = make(chan struct{})
+
+	h.subscriptionWG.Add(1)
+	go func(done chan struct{}) {
+		defer h.subscriptionWG.Done()
+		for {
+			select {
+			case request := <-h.subscriptionQueue:
+				// run subscripttionProcess
+				request.ErrChan <- request.Fn()
+				close(request.ErrChan)
+			case <-done:
+				return
+			}
 		}
-	}()
+	}(h.subscriptionDone)
 
 }
 
@@ -60,7 +83,7 @@ func (h *Handler) handleGetHomePage(w http.ResponseWriter, r *http.Request) erro
 
 	hashtagQuery := r.URL.Query().Get("hashtag")
 
-	if hashtagQuery != "" {
+	if hashtagQuery != "" || parseDealFilter(r.URL.Query()).Active() {
 		return h.handleGetFeed(w, r)
 	}
 
@@ -70,22 +93,18 @@ func (h *Handler) handleGetHomePage(w http.ResponseWriter, r *http.Request) erro
 	FROM
 		(
 			SELECT
-				id,
-				website_id,
-				src_url,
-				author_id,
-				score,
-				description,
-				timestamp
+				`+postColumns+`
 			FROM
 				posts
+			WHERE
+				status = ?
 			ORDER BY
 				timestamp DESC
 		)
 	GROUP BY
 		website_id
 	LIMIT
-		6`)
+		6`, postApproved)
 	if err != nil {
 		return err
 	}
@@ -95,12 +114,13 @@ func (h *Handler) handleGetHomePage(w http.ResponseWriter, r *http.Request) erro
 		return err
 	}
 
-	events, err := h.service.ConvertPostsToEvents(posts)
+	locale, _ := requestLocale(r)
+	events, err := h.service.ConvertPostsToEvents(posts, locale)
 	if err != nil {
 		return err
 	}
 
-	trendingHashtags, err := h.service.GetTrendingHashtags()
+	trendingHashtags, err := h.service.GetTrendingHashtags(0, 0)
 	if err != nil {
 		return err
 	}
@@ -112,8 +132,8 @@ func (h *Handler) handleGetHomePage(w http.ResponseWriter, r *http.Request) erro
 	}
 
 	data := map[string]any{
-		"PageTitle":         "All of Ireland's Top Beauty Deals and Discount Codes in One Place!",
-		"MetaDescription":   "We keep an eye on all your favourite beauty retailers' top offers and discount codes so you dont have to.",
+		"PageTitle":         translate(locale.Code, "All of Ireland's Top Beauty Deals and Discount Codes in One Place!"),
+		"MetaDescription":   translate(locale.Code, "We keep an eye on all your favourite beauty retailers' top offers and discount codes so you dont have to."),
 		"Canonical":         r.URL.Path,
 		"AlreadySubscribed": subscribed,
 		"Events":            events,
@@ -121,12 +141,13 @@ func (h *Handler) handleGetHomePage(w http.ResponseWriter, r *http.Request) erro
 		"Trending":          trendingHashtags,
 	}
 
-	return h.render.Page(w, "feedpage", data)
+	return h.render.Page(w, r, "feedpage", data)
 }
 
 func (h *Handler) handleGetFeed(w http.ResponseWriter, r *http.Request) error {
 
 	hashtagQuery := r.URL.Query().Get("hashtag")
+	filter := parseDealFilter(r.URL.Query())
 
 	websitePath := r.PathValue("websitePath")
 
@@ -141,17 +162,18 @@ func (h *Handler) handleGetFeed(w http.ResponseWriter, r *http.Request) error {
 		postIDs = pIds
 	}
 
-	posts, err := h.service.GetPreviewPosts(website, postIDs)
+	posts, err := h.service.GetPreviewPosts(website, postIDs, filter)
 	if err != nil {
 		return err
 	}
 
-	events, err := h.service.ConvertPostsToEvents(posts)
+	locale, _ := requestLocale(r)
+	events, err := h.service.ConvertPostsToEvents(posts, locale)
 	if err != nil {
 		return err
 	}
 
-	trendingHashtags, err := h.service.GetTrendingHashtags()
+	trendingHashtags, err := h.service.GetTrendingHashtags(website.WebsiteID, 0)
 	if err != nil {
 		return err
 	}
@@ -184,8 +206,20 @@ func (h *Handler) handleGetFeed(w http.ResponseWriter, r *http.Request) error {
 		log.Printf("Warning: Error getting subscription_status cookie: %v", err)
 	}
 
+	// how long this retailer's promotions usually last, only shown on a store's own feed
+	var promoStats *PromoStats
+	if website.WebsiteID != 0 {
+		stats, err := h.service.GetPromoStats(website.WebsiteID)
+		if err != nil {
+			return err
+		}
+		if len(stats) > 0 && stats[0].Ended > 0 {
+			promoStats = &stats[0]
+		}
+	}
+
 	// on feed page the offers are either for the selected website or hashtag
-	var offersFor string = "You"
+	var offersFor string = translate(locale.Code, "You")
 	if website.WebsiteID != 0 {
 		offersFor = website.WebsiteName
 	} else if hashtagQuery != "" {
@@ -193,8 +227,8 @@ func (h *Handler) handleGetFeed(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	data := map[string]any{
-		"PageTitle":         fmt.Sprintf("Latest offers and Discount Codes for %s", website.WebsiteName),
-		"MetaDescription":   fmt.Sprintf("We track the offers and discounts on %s deliver them staight to your inbox.", website.WebsiteName),
+		"PageTitle":         translate(locale.Code, "Latest offers and Discount Codes for %s", website.WebsiteName),
+		"MetaDescription":   translate(locale.Code, "We track the offers and discounts on %s deliver them staight to your inbox.", website.WebsiteName),
 		"Canonical":         r.URL.Path,
 		"AlreadySubscribed": subscribed,
 		"Events":            events,
@@ -202,9 +236,13 @@ func (h *Handler) handleGetFeed(w http.ResponseWriter, r *http.Request) error {
 		"Trending":          trendingHashtags,
 		"OffersFor":         offersFor,
 		"WebsiteCoupons":    websiteCoupons,
+		"PromoStats":        promoStats,
+		"Filter":            filter,
+		"DiscountOptions":   discountOptions,
+		"Hashtag":           hashtagQuery,
 	}
 
-	return h.render.Page(w, "feedpage", data)
+	return h.render.Page(w, r, "feedpage", data)
 }
 
 var ErrEmailAlreadyExists = errors.New("this email already exists")
@@ -217,24 +255,26 @@ func (h *Handler) handleStoreSubscription(w http.ResponseWriter, r *http.Request
 		return fmt.Errorf("could not parse form: %w", err)
 	}
 
+	locale, _ := requestLocale(r)
+
 	// Validate and sanitize email input
 	email := strings.TrimSpace(r.FormValue("email"))
 	if !isValidEmail(email) {
-		return h.render.Template(w, "subscriptionform", map[string]any{
-			"EmailErr": "Please provide a valid email address",
+		return h.render.Partial(w, r, "subscriptionform", map[string]any{
+			"EmailErr": translate(locale.Code, "Please provide a valid email address"),
 		})
 	}
 
 	// Use constant-time comparison for consent check to prevent timing attacks
 	consent := r.FormValue("consent")
 	if !(subtle.ConstantTimeCompare([]byte(consent), []byte("on")) == 1) {
-		return h.render.Template(w, "subscriptionform", map[string]any{
-			"ConsentErr": "Please consent so we can add you to our mailing list. Thanks!",
+		return h.render.Partial(w, r, "subscriptionform", map[string]any{
+			"ConsentErr": translate(locale.Code, "Please consent so we can add you to our mailing list. Thanks!"),
 		})
 	}
 
-	errChan := make(chan error)
-	h.subscriptionQueue <- SubscriptionPayload{
+	errChan := make(chan error, 1)
+	payload := SubscriptionPayload{
 		Fn: func() error {
 
 			// Use a transaction to ensure data consistency
@@ -290,13 +330,25 @@ func (h *Handler) handleStoreSubscription(w http.ResponseWriter, r *http.Request
 		ErrChan: errChan,
 	}
 
+	select {
+	case h.subscriptionQueue <- payload:
+	case <-h.subscriptionDone:
+		return ErrShuttingDown
+	}
+
 	err := <-errChan
 	if errors.Is(err, ErrEmailAlreadyExists) {
-		return h.render.Template(w, "subscriptionform", map[string]any{
-			"EmailErr": "This email is already subscribed",
+		subscriberSignups.Inc("duplicate")
+		return h.render.Partial(w, r, "subscriptionform", map[string]any{
+			"EmailErr": translate(locale.Code, "This email is already subscribed"),
 		})
 
 	}
+	if err != nil {
+		subscriberSignups.Inc("error")
+		return err
+	}
+	subscriberSignups.Inc("ok")
 
 	http.SetCookie(w, &http.Cookie{
 		Name:     "subscription_status",
@@ -308,7 +360,7 @@ func (h *Handler) handleStoreSubscription(w http.ResponseWriter, r *http.Request
 		Path:     "/",                     // Restrict cookie scope
 	})
 
-	return h.render.Template(w, "subscriptionsuccess", nil)
+	return h.render.Partial(w, r, "subscriptionsuccess", nil)
 }
 
 // Helper function to validate email format
@@ -328,9 +380,10 @@ func isSQLiteConstraintError(err error) bool {
 }
 
 func (h *Handler) handleSubscribe(w http.ResponseWriter, r *http.Request) error {
-	return h.render.Page(w, "subscribepage", map[string]any{
-		"PageTitle":       "Subscribe to the BeautyBargains Newsletter to never miss a Deal",
-		"MetaDescription": "We drop the latest offers from Top Beauty Sites into one email so you never miss out.",
+	locale, _ := requestLocale(r)
+	return h.render.Page(w, r, "subscribepage", map[string]any{
+		"PageTitle":       translate(locale.Code, "Subscribe to the BeautyBargains Newsletter to never miss a Deal"),
+		"MetaDescription": translate(locale.Code, "We drop the latest offers from Top Beauty Sites into one email so you never miss out."),
 		"Canonical":       r.URL.Path,
 	})
 }
@@ -380,9 +433,10 @@ func (h *Handler) handleGetVerifySubscription(w http.ResponseWriter, r *http.Req
 	}
 
 	// subscription confirmed
-	return h.render.Page(w, "subscriptionverification", map[string]any{
-		"PageTitle":       "Thanks for Signing Up!",
-		"MetaDescription": "Keep an eye out for our newsletter!",
+	locale, _ := requestLocale(r)
+	return h.render.Page(w, r, "subscriptionverification", map[string]any{
+		"PageTitle":       translate(locale.Code, "Thanks for Signing Up!"),
+		"MetaDescription": translate(locale.Code, "Keep an eye out for our newsletter!"),
 		"Canonical":       r.URL.Path,
 	})
 }
@@ -403,7 +457,7 @@ func (h *Handler) Unauthorized(w http.ResponseWriter, r *http.Request) error {
 	w.WriteHeader(http.StatusUnauthorized)
 
 	return h.render.Page(
-		w, "unauthorizedpage",
+		w, r, "unauthorizedpage",
 		map[string]any{
 			"PageTitle":       "BeautyBargains.ie | Unauthorized",
 			"MetaDescription": "You are attemptig to access without authorization",
@@ -437,11 +491,12 @@ func (h *Handler) handleListCoupons(w http.ResponseWriter, r *http.Request) erro
 		return h.render.Template(w, "coupons-container", websiteCoupons)
 	}
 
-	return h.render.Page(w,
+	locale, _ := requestLocale(r)
+	return h.render.Page(w, r,
 		"couponcodes",
 		map[string]any{
-			"PageTitle":       "Find Coupons/Discount Codes for top Beauty Retailers in Ireland!",
-			"MetaDescription": "We collect new discount codes as fast as we can and leave all them here for you.",
+			"PageTitle":       translate(locale.Code, "Find Coupons/Discount Codes for top Beauty Retailers in Ireland!"),
+			"MetaDescription": translate(locale.Code, "We collect new discount codes as fast as we can and leave all them here for you."),
 			"WebsiteCoupons":  websiteCoupons,
 			"Canonical":       r.URL.Path,
 			"Websites":        getWebsites(0, 0),
@@ -456,7 +511,7 @@ func (h *Handler) handleListSubscribers(w http.ResponseWriter, r *http.Request)
 		return fmt.Errorf("handler failed to get subscribers; %w", err)
 	}
 
-	return h.render.Page(w, "adminsubscribers", map[string]any{
+	return h.render.Page(w, r, "adminsubscribers", map[string]any{
 		"PageTitle":       "Admin Page, subscribers",
 		"MetaDescription": "",
 		"Canonical":       r.URL.Path,
@@ -464,3 +519,274 @@ func (h *Handler) handleListSubscribers(w http.ResponseWriter, r *http.Request)
 	})
 
 }
+
+func (h *Handler) handleGetPriceAlert(w http.ResponseWriter, r *http.Request) error {
+	productID, err := strconv.Atoi(r.URL.Query().Get("product"))
+	if err != nil {
+		http.Error(w, "invalid product", http.StatusBadRequest)
+		return nil
+	}
+	product, err := h.service.GetProductSummary(productID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return h.renderPriceAlert(w, r, product, "", false)
+}
+
+func (h *Handler) handleStorePriceAlert(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, 1024)
+	if err := r.ParseForm(); err != nil {
+		return fmt.Errorf("could not parse form: %w", err)
+	}
+	locale, _ := requestLocale(r)
+
+	productID, err := strconv.Atoi(r.FormValue("product_id"))
+	if err != nil {
+		http.Error(w, "invalid product", http.StatusBadRequest)
+		return nil
+	}
+	product, err := h.service.GetProductSummary(productID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	email := strings.TrimSpace(r.FormValue("email"))
+	if !isValidEmail(email) {
+		return h.renderPriceAlert(w, r, product, translate(locale.Code, "Please provide a valid email address"), false)
+	}
+	var target float64
+	if raw := strings.TrimSpace(r.FormValue("target_price")); raw != "" {
+		target, err = strconv.ParseFloat(strings.TrimPrefix(raw, "€"), 64)
+		if err != nil || target <= 0 {
+			return h.renderPriceAlert(w, r, product, translate(locale.Code, "Please enter a price like 9.99 or leave it empty"), false)
+		}
+	}
+
+	// the same page whether or not email is subscribed
+	if err := h.service.RequestPriceAlert(email, productID, target, h.domain+locale.Prefix, time.Now()); err != nil {
+		return err
+	}
+	return h.renderPriceAlert(w, r, product, "", true)
+}
+
+func (h *Handler) renderPriceAlert(w http.ResponseWriter, r *http.Request, product ProductSummary, formErr string, saved bool) error {
+	locale, _ := requestLocale(r)
+	return h.render.Page(w, r, "pricealert", map[string]any{
+		"PageTitle":       translate(locale.Code, "Price alerts for %s", product.Name),
+		"MetaDescription": translate(locale.Code, "Get an email when %s gets cheaper.", product.Name),
+		"Canonical":       r.URL.Path,
+		"Product":         product,
+		"FormErr":         formErr,
+		"Saved":           saved,
+	})
+}
+
+func (h *Handler) handleConfirmPriceAlert(w http.ResponseWriter, r *http.Request) error {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "missing token", http.StatusBadRequest)
+		return nil
+	}
+	locale, _ := requestLocale(r)
+	var product ProductSummary
+	productID, err := h.service.ConfirmPriceAlert(token, time.Now())
+	if err == nil {
+		product, err = h.service.GetProductSummary(productID)
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	return h.render.Page(w, r, "pricealertconfirmed", map[string]any{
+		"PageTitle":       translate(locale.Code, "Price alert confirmed"),
+		"MetaDescription": translate(locale.Code, "Price alerts"),
+		"Canonical":       r.URL.Path,
+		"Confirmed":       err == nil,
+		"Product":         product,
+	})
+}
+
+func (h *Handler) handleRemovePriceAlert(w http.ResponseWriter, r *http.Request) error {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "missing token", http.StatusBadRequest)
+		return nil
+	}
+	removed, err := h.service.RemovePriceAlert(token)
+	if err != nil {
+		return err
+	}
+	locale, _ := requestLocale(r)
+	return h.render.Page(w, r, "pricealertremoved", map[string]any{
+		"PageTitle":       translate(locale.Code, "Price alert removed"),
+		"MetaDescription": translate(locale.Code, "You won't get emails about this product any more."),
+		"Canonical":       r.URL.Path,
+		"Removed":         removed,
+	})
+}
+
+func (h *Handler) handleGetCatalogProduct(w http.ResponseWriter, r *http.Request) error {
+	product, err := h.service.GetCatalogProduct(r.PathValue("slug"))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	offers, err := h.service.GetCatalogOffers(product.ID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	type retailerCoupons struct {
+		Website Website
+		Coupons []CouponCode
+	}
+	var coupons []retailerCoupons
+	seen := map[int]bool{}
+	labels := make([]string, 0, len(offers))
+	series := make([][]DailyPrice, 0, len(offers))
+	for _, offer := range offers {
+		prices, err := h.service.GetDailyPrices(offer.ID, now.AddDate(0, 0, -comparisonDays), now)
+		if err != nil {
+			return err
+		}
+		labels = append(labels, offer.Website.WebsiteName)
+		series = append(series, prices)
+
+		if seen[offer.WebsiteID] {
+			continue
+		}
+		seen[offer.WebsiteID] = true
+		websiteCoupons, err := h.service.GetCoupons(getCouponParams{WebsiteID: offer.WebsiteID, Limit: 3, ActiveAt: now})
+		if err != nil {
+			return err
+		}
+		if len(websiteCoupons) > 0 {
+			coupons = append(coupons, retailerCoupons{Website: offer.Website, Coupons: websiteCoupons})
+		}
+	}
+
+	locale, _ := requestLocale(r)
+	title := strings.TrimSpace(strings.Join([]string{product.Brand, product.Name, product.Size}, " "))
+	return h.render.Page(w, r, "catalogproduct", map[string]any{
+		"PageTitle":       translate(locale.Code, "%s price comparison", title),
+		"MetaDescription": translate(locale.Code, "Compare the price of %s at Irish beauty retailers.", title),
+		"Canonical":       r.URL.Path,
+		"Title":           title,
+		"Product":         product,
+		"Offers":          offers,
+		"Coupons":         coupons,
+		"Chart":           newPriceChart(labels, series, 600, 200),
+	})
+}
+
+// handleGoPost sends readers on to a post's link, counting the click, see clicks.go
+func (h *Handler) handleGoPost(w http.ResponseWriter, r *http.Request) error {
+	postID, err := strconv.Atoi(r.PathValue("postID"))
+	if err != nil {
+		http.NotFound(w, r)
+		return nil
+	}
+	target, err := h.service.getPostTarget(postID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return h.redirectOutbound(w, r, target)
+}
+
+// handleGoCoupon sends readers on to the retailer of a coupon, counting the click
+func (h *Handler) handleGoCoupon(w http.ResponseWriter, r *http.Request) error {
+	couponID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return nil
+	}
+	target, err := h.service.getCouponTarget(couponID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return h.redirectOutbound(w, r, target)
+}
+
+// handleGoOffer sends readers on to an offer's page at the retailer, counting the click
+func (h *Handler) handleGoOffer(w http.ResponseWriter, r *http.Request) error {
+	offerID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return nil
+	}
+	target, err := h.service.getOfferTarget(offerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return h.redirectOutbound(w, r, target)
+}
+
+// handleGoProduct sends readers on to a product's page at the retailer, counting the click
+func (h *Handler) handleGoProduct(w http.ResponseWriter, r *http.Request) error {
+	productID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return nil
+	}
+	target, err := h.service.getProductTarget(productID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return h.redirectOutbound(w, r, target)
+}
+
+func (h *Handler) redirectOutbound(w http.ResponseWriter, r *http.Request, target outboundTarget) error {
+	link, err := h.service.outboundURL(target)
+	if err != nil {
+		return err
+	}
+	if h.countClick(r) {
+		// a click that can't be recorded shouldn't keep the reader from the retailer
+		if err := h.service.RecordClick(target, time.Now()); err != nil {
+			h.service.ReportErr(err)
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+	http.Redirect(w, r, link, http.StatusFound)
+	return nil
+}
+
+// countClick leaves out bots and clients clicking faster than people do
+func (h *Handler) countClick(r *http.Request) bool {
+	if r.Method != http.MethodGet || isBot(r.UserAgent()) {
+		return false
+	}
+	if h.clicks == nil {
+		return true
+	}
+	ok, _ := h.clicks.allow(h.clientIP(r))
+	return ok
+}
@@ -53,15 +53,6 @@ func (s *Service) getHashtagIDByPhrase(phrase string) (int, error) {
 	return h, nil
 }
 
-func (s *Service) getHashtagByID(id int) (*Hashtag, error) {
-	q := `SELECT id, phrase from hashtags WHERE id = ?`
-	var h Hashtag
-	if err := s.db.QueryRow(q, id).Scan(&h.ID, &h.Phrase); err != nil {
-		return nil, err
-	}
-	return &h, nil
-}
-
 func (s *Service) getPostIDsByHashtagQuery(hashtagQuery string) (postIDs []int, err error) {
 	hashtagID, err := s.getHashtagIDByPhrase(hashtagQuery)
 	if err != nil {
@@ -84,31 +75,3 @@ func (s *Service) getPostIDsByHashtagQuery(hashtagQuery string) (postIDs []int,
 
 	return postIDs, nil
 }
-func (s *Service) GetTrendingHashtags() ([]Hashtag, error) {
-	rows, err := s.db.Query(`SELECT hashtag_id, count(post_id) FROM post_hashtags GROUP BY hashtag_id ORDER BY count(post_id) DESC LIMIT 5`)
-	if err != nil {
-		return nil, fmt.Errorf("could not count hashtag mentions: %w", err)
-	}
-	defer rows.Close()
-	type hashtagCount struct {
-		HashtagID int
-		PostCount int
-	}
-	top := make([]hashtagCount, 0, 5)
-	for rows.Next() {
-		var row hashtagCount
-		if err := rows.Scan(&row.HashtagID, &row.PostCount); err != nil {
-			return nil, err
-		}
-		top = append(top, row)
-	}
-	var trendingHashtags []Hashtag
-	for _, row := range top {
-		hashtag, err := s.getHashtagByID(row.HashtagID)
-		if err != nil {
-			return nil, fmt.Errorf("could not get hashtag by id: %w", err)
-		}
-		trendingHashtags = append(trendingHashtags, Hashtag{ID: hashtag.ID, Phrase: hashtag.Phrase})
-	}
-	return trendingHashtags, nil
-}
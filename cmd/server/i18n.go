@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+Public pages are served in English and, under /ga, in Irish. UI strings are translated with the
+message catalogue below, keyed by the English text so templates stay readable and anything
+missing falls back to English:
+
+	{{ t .Locale "Latest Beauty Offers" }}
+	{{ t .Locale "Beauty Offers for %s" .OffersFor }}
+
+Post descriptions are translated by the llm, see translations.go.
+*/
+
+const defaultLocale = "en"
+
+type Locale struct {
+	Code string
+	// Prefix is prepended to paths, empty for the default locale
+	Prefix string
+	// Language is the English name of the language, used in prompts
+	Language string
+	// Name is what the language calls itself, used in the language switcher
+	Name string
+	// Tag is used for hreflang and og:locale
+	Tag string
+}
+
+var locales = []Locale{
+	{Code: "en", Prefix: "", Language: "English", Name: "English", Tag: "en-IE"},
+	{Code: "ga", Prefix: "/ga", Language: "Irish", Name: "Gaeilge", Tag: "ga-IE"},
+}
+
+func getLocale(code string) (Locale, bool) {
+	for _, l := range locales {
+		if l.Code == code {
+			return l, true
+		}
+	}
+	return Locale{}, false
+}
+
+type localeContextKey struct{}
+
+// withLocale serves next in locale. Only pages wrapped with it get hreflang alternates.
+func (h *Handler) withLocale(locale string) middleware {
+	return func(next handleFunc) handleFunc {
+		return func(w http.ResponseWriter, r *http.Request) error {
+			return next(w, r.WithContext(context.WithValue(r.Context(), localeContextKey{}, locale)))
+		}
+	}
+}
+
+// requestLocale is the locale r is served in and whether the route is localised at all
+func requestLocale(r *http.Request) (Locale, bool) {
+	if code, ok := r.Context().Value(localeContextKey{}).(string); ok {
+		if l, ok := getLocale(code); ok {
+			return l, true
+		}
+	}
+	l, _ := getLocale(defaultLocale)
+	return l, false
+}
+
+// alternate is the same page in another locale
+type alternate struct {
+	Locale Locale
+	Path   string
+}
+
+// alternates returns path, as served in current, for every locale
+func alternates(current Locale, path string) []alternate {
+	base := strings.TrimPrefix(path, current.Prefix)
+	if base == "" {
+		base = "/"
+	}
+	out := make([]alternate, 0, len(locales))
+	for _, l := range locales {
+		out = append(out, alternate{Locale: l, Path: l.Prefix + base})
+	}
+	return out
+}
+
+// messages are the translations of UI strings by locale
+var messages = map[string]map[string]string{
+	"ga": {
+		// navigation and footer
+		"Home":       "Baile",
+		"Stores":     "Siopaí",
+		"Newsletter": "Nuachtlitir",
+		"Coupons":    "Cúpóin",
+		"Pro Tip:":   "Leid:",
+		"Bookmark this page and check back often for the latest beauty discounts. Share with your friends to help them save, too!": "Cuir leabharmharc leis an leathanach seo agus fill air go minic le haghaidh na lascainí áilleachta is déanaí. Roinn é le do chairde chun cabhrú leo airgead a shábháil freisin!",
+
+		// page titles and descriptions
+		"All of Ireland's Top Beauty Deals and Discount Codes in One Place!":                                        "Na Margaí Áilleachta agus na Cóid Lascaine is Fearr in Éirinn in Aon Áit Amháin!",
+		"We keep an eye on all your favourite beauty retailers' top offers and discount codes so you dont have to.": "Coinnímid súil ar na tairiscintí agus na cóid lascaine is fearr ó na miondíoltóirí áilleachta is ansa leat ionas nach gá duitse.",
+		"Latest offers and Discount Codes for %s":                                                                   "Na tairiscintí agus na cóid lascaine is déanaí do %s",
+		"We track the offers and discounts on %s deliver them staight to your inbox.":                               "Leanaimid na tairiscintí agus na lascainí ar %s agus seolaimid díreach chuig do bhosca isteach iad.",
+		"Find Coupons/Discount Codes for top Beauty Retailers in Ireland!":                                          "Faigh Cúpóin agus Cóid Lascaine do na Miondíoltóirí Áilleachta is Fearr in Éirinn!",
+		"We collect new discount codes as fast as we can and leave all them here for you.":                          "Bailímid cóid lascaine nua chomh tapa agus is féidir linn agus fágaimid anseo iad ar fad duit.",
+		"Subscribe to the BeautyBargains Newsletter to never miss a Deal":                                           "Cláraigh do Nuachtlitir BeautyBargains ionas nach gcaillfidh tú margadh go deo",
+		"We drop the latest offers from Top Beauty Sites into one email so you never miss out.":                     "Cuirimid na tairiscintí is déanaí ó na suíomhanna áilleachta is fearr in aon ríomhphost amháin ionas nach gcaillfidh tú aon rud.",
+		"Thanks for Signing Up!":              "Go raibh maith agat as clárú!",
+		"Keep an eye out for our newsletter!": "Coinnigh súil amach dár nuachtlitir!",
+
+		// feed
+		"Latest Beauty Offers": "Na Tairiscintí Áilleachta is Déanaí",
+		"Beauty Offers for %s": "Tairiscintí Áilleachta do %s",
+		"You":                  "Chách",
+		"%s promotions usually run for about %s.": "Maireann tairiscintí %s thart ar %s de ghnáth.",
+		"%d live now.":         "%d beo anois.",
+		"View Offers by Store": "Féach ar Thairiscintí de réir Siopa",
+		"We are keeping our eyes on these stores and are adding more soon": "Táimid ag coinneáil súil ar na siopaí seo agus beidh tuilleadh againn go luath",
+		"%s Screenshot":                        "Gabháil scáileáin de %s",
+		"Stay Updated in Just 5 Minutes a Day": "Fan ar an Eolas i 5 Nóiméad sa Lá",
+		"Join our beauty tribe and be the first to discover the hottest deals and beauty secrets from top brands in Ireland. Subscribe now and never miss out on fabulous offers!": "Bí linn agus bí ar an gcéad duine le teacht ar na margaí is teo agus ar rúin áilleachta ó na brandaí is fearr in Éirinn. Cláraigh anois agus ná caill tairiscint iontach riamh!",
+
+		// events
+		"posted an update about %s":          "a chuir nuashonrú suas faoi %s",
+		"%d Days ago":                        "%d lá ó shin",
+		"%d Hours ago":                       "%d uair an chloig ó shin",
+		"%d Hour ago":                        "%d uair an chloig ó shin",
+		"Live now":                           "Beo anois",
+		"Ended":                              "Thart",
+		"ran %s":                             "mhair sé %s",
+		"%d Likes":                           "%d Maith liom",
+		"Shop Now":                           "Siopáil Anois",
+		"Source":                             "Foinse",
+		"Auto-extracted":                     "Bainte go huathoibríoch",
+		"Read automatically from the banner": "Léite go huathoibríoch ón mbratach",
+		"1 day":                              "1 lá",
+		"%d days":                            "%d lá",
+		"1 hour":                             "1 uair an chloig",
+		"%d hours":                           "%d uair an chloig",
+
+		// deals
+		"%s%% off":                "%s%% lascaine",
+		"€%s off":                 "€%s lascaine",
+		"Min. spend €%s":          "Íoschaiteachas €%s",
+		"Buy one get one free":    "Ceannaigh ceann, faigh ceann saor in aisce",
+		"Free gift":               "Bronntanas saor in aisce",
+		"Free delivery":           "Seachadadh saor in aisce",
+		"Ends %s":                 "Críochnaíonn %s",
+		"Discount":                "Lascaine",
+		"Any":                     "Aon cheann",
+		"%d%% or more":            "%d%% nó níos mó",
+		"Sort by":                 "Sórtáil de réir",
+		"Top picks":               "Na cinn is fearr",
+		"Newest":                  "Is nuaí",
+		"Biggest discount":        "An lascaine is mó",
+		"Ending soon":             "Ag críochnú go luath",
+		"Filter":                  "Scag",
+		"Lowest price ever":       "An praghas is ísle riamh",
+		"Lowest price in 90 days": "An praghas is ísle le 90 lá",
+
+		// coupons
+		"Irish Beauty Coupon Codes":                                  "Cóid Chúpóin Áilleachta na hÉireann",
+		"Find the Best Beauty Coupons and Discount Codes in Ireland": "Faigh na Cúpóin Áilleachta agus na Cóid Lascaine is Fearr in Éirinn",
+		"Welcome to BeautyBargains.ie, your ultimate destination for the latest beauty deals and discounts from Ireland's favorite retailers. Whether you're looking for skincare, makeup, or haircare products, we've got you covered with exclusive offers. Check out the most recent beauty coupon codes below!": "Fáilte go BeautyBargains.ie, an áit is fearr le teacht ar na margaí agus na lascainí áilleachta is déanaí ó na miondíoltóirí is fearr le muintir na hÉireann. Cibé an bhfuil cúram craicinn, smideadh nó cúram gruaige uait, tá tairiscintí eisiacha againn duit. Féach ar na cóid chúpóin áilleachta is déanaí thíos!",
+		"Filter by Store:": "Scag de réir Siopa:",
+		"All Stores":       "Gach Siopa",
+
+		// newsletter
+		"Sign Up To The Newsletter": "Cláraigh don Nuachtlitir",
+		"Email":                     "Ríomhphost",
+		"I agree to receive email updates and promotions": "Aontaím nuashonruithe agus tairiscintí a fháil trí ríomhphost",
+		"Subscribe":                            "Cláraigh",
+		"Please provide a valid email address": "Tabhair seoladh ríomhphoist bailí le do thoil",
+		"Please consent so we can add you to our mailing list. Thanks!": "Tabhair do thoiliú le do thoil ionas gur féidir linn tú a chur ar ár liosta seoltaí. Go raibh maith agat!",
+		"This email is already subscribed":                              "Tá an seoladh ríomhphoist seo cláraithe cheana féin",
+		"Thank you for subscribing!":                                    "Go raibh maith agat as clárú!",
+		"You should receive an email shortly to verify your email. Then we'll keep you up to date on offers on all your favourite brands and products!": "Gheobhaidh tú ríomhphost go luath chun do sheoladh a fhíorú. Ansin coinneoimid ar an eolas thú faoi thairiscintí ar na brandaí agus na táirgí is fearr leat!",
+		"Thank you for verifying your email!":                                            "Go raibh maith agat as do ríomhphost a fhíorú!",
+		"We'll keep you up to date on offers on all your favourite brands and products!": "Coinneoimid ar an eolas thú faoi thairiscintí ar na brandaí agus na táirgí is fearr leat!",
+
+		// price alerts
+		"Price alerts":                       "Foláirimh praghais",
+		"Price alerts for %s":                "Foláirimh praghais do %s",
+		"Get an email when %s gets cheaper.": "Faigh ríomhphost nuair a éiríonn %s níos saoire.",
+		"Now from €%.2f":                     "Anois ó €%.2f",
+		"Only tell me when it's this price or less (optional)": "Ná hinis dom ach nuair atá sé ar an bpraghas seo nó níos lú (roghnach)",
+		"Price alerts are for newsletter subscribers.":         "Is do shíntiúsóirí na nuachtlitreach na foláirimh praghais.",
+		"Please enter a price like 9.99 or leave it empty":     "Cuir isteach praghas mar 9.99 nó fág folamh é",
+		"Watch the price": "Coinnigh súil ar an bpraghas",
+		"Check your email. If you're a subscriber we've sent you a link to start this alert.": "Seiceáil do ríomhphost. Má tá tú cláraithe, sheolamar nasc chugat chun an foláireamh seo a thosú.",
+		"Price alert confirmed":                             "Deimhníodh an foláireamh praghais",
+		"Done! We'll email you when %s gets cheaper.":       "Déanta! Seolfaimid ríomhphost chugat nuair a éiríonn %s níos saoire.",
+		"This link has expired or was already used.":        "Tá an nasc seo imithe in éag nó úsáideadh cheana é.",
+		"Price alert removed":                               "Baineadh an foláireamh praghais",
+		"You won't get emails about this product any more.": "Ní bhfaighidh tú ríomhphoist faoin táirge seo a thuilleadh.",
+		"This price alert was already removed.":             "Baineadh an foláireamh praghais seo cheana.",
+
+		// price comparison
+		"Compare prices":      "Cuir praghsanna i gcomparáid",
+		"%s price comparison": "Comparáid praghsanna do %s",
+		"Compare the price of %s at Irish beauty retailers.": "Cuir praghas %s i gcomparáid ag miondíoltóirí áilleachta na hÉireann.",
+		"Prices":       "Praghsanna",
+		"Out of stock": "As stoc",
+		"No retailer lists this product right now.": "Níl an táirge seo ag aon mhiondíoltóir faoi láthair.",
+		"Coupon codes":  "Cóid chúpóin",
+		"Price history": "Stair praghsanna",
+		// trending hashtags
+		"Trending":                        "Ag treochtáil",
+		"New":                             "Nua",
+		"%d recent posts, %d before that": "%d phostáil le déanaí, %d roimhe sin",
+	},
+}
+
+// translate looks msg up in locale's catalogue, falling back to msg itself, and formats it
+// with args when there are any
+func translate(locale, msg string, args ...any) string {
+	if translated, ok := messages[locale][msg]; ok {
+		msg = translated
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// localDuration is humanDuration in locale
+func localDuration(locale string, d time.Duration) string {
+	if days := int(d.Hours() / 24); days > 0 {
+		if days == 1 {
+			return translate(locale, "1 day")
+		}
+		return translate(locale, "%d days", days)
+	}
+	hours := int(d.Hours())
+	if hours == 1 {
+		return translate(locale, "1 hour")
+	}
+	return translate(locale, "%d hours", hours)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTranslate(t *testing.T) {
+	cases := []struct {
+		locale string
+		msg    string
+		args   []any
+		want   string
+	}{
+		{"en", "Home", nil, "Home"},
+		{"ga", "Home", nil, "Baile"},
+		{"ga", "Beauty Offers for %s", []any{"Boots"}, "Tairiscintí Áilleachta do Boots"},
+		{"en", "Beauty Offers for %s", []any{"Boots"}, "Beauty Offers for Boots"},
+		// missing from the catalogue falls back to english
+		{"ga", "Not translated %d", []any{3}, "Not translated 3"},
+		{"fr", "Home", nil, "Home"},
+	}
+	for _, c := range cases {
+		if got := translate(c.locale, c.msg, c.args...); got != c.want {
+			t.Errorf("translate(%q, %q) = %q, want %q", c.locale, c.msg, got, c.want)
+		}
+	}
+}
+
+func TestLocalDuration(t *testing.T) {
+	if got := localDuration("ga", 3*24*time.Hour); got != "3 lá" {
+		t.Errorf("got %q", got)
+	}
+	if got := localDuration("en", 3*24*time.Hour); got != humanDuration(3*24*time.Hour) {
+		t.Errorf("english duration %q differs from humanDuration", got)
+	}
+}
+
+func TestAlternates(t *testing.T) {
+	en, _ := getLocale("en")
+	ga, _ := getLocale("ga")
+	cases := []struct {
+		current Locale
+		path    string
+		want    map[string]string
+	}{
+		{en, "/", map[string]string{"en": "/", "ga": "/ga/"}},
+		{en, "/website/boots", map[string]string{"en": "/website/boots", "ga": "/ga/website/boots"}},
+		{ga, "/ga/", map[string]string{"en": "/", "ga": "/ga/"}},
+		{ga, "/ga/coupons", map[string]string{"en": "/coupons", "ga": "/ga/coupons"}},
+	}
+	for _, c := range cases {
+		got := alternates(c.current, c.path)
+		if len(got) != len(locales) {
+			t.Fatalf("expected an alternate per locale, got %d", len(got))
+		}
+		for _, a := range got {
+			if a.Path != c.want[a.Locale.Code] {
+				t.Errorf("alternate of %s in %s = %q, want %q", c.path, a.Locale.Code, a.Path, c.want[a.Locale.Code])
+			}
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"beautybargains/internal/chat"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+/*
+The ingest subcommand runs the banner job for a single website without starting the server, so
+a new or broken scraping rule can be checked in isolation.
+
+	server ingest -website beautyfeatures              print the banners that would be ingested
+	server ingest -website 3 -analyze                  also ask the llm to describe new banners
+	server ingest -website beautyfeatures -analyze -commit
+	                                                   save the results as the job would
+
+Nothing is written unless -commit is given.
+*/
+
+// ingestResult is what ingesting one banner produced, or would produce in a dry run
+type ingestResult struct {
+	Banner BannerData `json:"banner"`
+	// Seen is set for banners already posted, they are not processed again
+	Seen        bool                      `json:"seen"`
+	Image       *StoredImage              `json:"image,omitempty"`
+	DuplicateOf int                       `json:"duplicate_of,omitempty"`
+	Offer       *OfferDescriptionResponse `json:"offer,omitempty"`
+	Review      *offerReview              `json:"review,omitempty"`
+	PostID      int                       `json:"post_id,omitempty"`
+	Error       string                    `json:"error,omitempty"`
+}
+
+type ingestOptions struct {
+	analyze bool
+	commit  bool
+}
+
+func runIngest(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("ingest", flag.ContinueOnError)
+	configFile := fs.String("config", defaultConfigFile, "optional env style config file")
+	dbPath := fs.String("db", "", "path to the sqlite database")
+	websiteFlag := fs.String("website", "", "path or id of the website to ingest")
+	analyze := fs.Bool("analyze", false, "describe new banners with the llm")
+	commit := fs.Bool("commit", false, "save posts, duplicates and banner presence instead of printing a dry run")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *websiteFlag == "" {
+		return errors.New("-website is required")
+	}
+	if *commit && !*analyze {
+		return errors.New("-commit needs -analyze, posts can't be saved without a description")
+	}
+
+	website, err := getWebsiteByPath(*websiteFlag)
+	if err != nil {
+		id, convErr := strconv.Atoi(*websiteFlag)
+		if convErr != nil {
+			return err
+		}
+		if website, err = getWebsiteByID(id); err != nil {
+			return err
+		}
+	}
+
+	if err := godotenv.Load(*configFile); err != nil {
+		if *configFile != defaultConfigFile || !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to load config file %s: %w", *configFile, err)
+		}
+	}
+	cfg := defaultConfig()
+	if err := cfg.applyEnv(); err != nil {
+		return err
+	}
+	if *dbPath != "" {
+		cfg.DBPath = *dbPath
+	}
+	// the llm is only needed to analyze
+	cfg.Skip = !*analyze
+	if err := cfg.ValidateJobs(); err != nil {
+		return err
+	}
+
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// a dry run may answer from the llm cache but leaves no answers or usage behind
+	var chatStore chat.Store = chat.NewSQLStore(db)
+	if !*commit {
+		chatStore = chat.ReadOnlyStore{Store: chatStore}
+	}
+	service, err := newConfiguredService(cfg, db, func(err error) error {
+		log.Println(err)
+		return nil
+	}, chatStore)
+	if err != nil {
+		return err
+	}
+	defer service.Close()
+
+	results, err := ingestWebsite(context.Background(), service, website, ingestOptions{analyze: *analyze, commit: *commit})
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
+
+// ingestWebsite scrapes website and takes each new banner as far as opts allow. Failures for a
+// single banner are recorded on its result rather than stopping the rest.
+func ingestWebsite(ctx context.Context, service *Service, website Website, opts ingestOptions) ([]ingestResult, error) {
+	banners, err := extractWebsiteBannerURLs(ctx, website)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract banner URLs for website %s: %w", website.WebsiteName, err)
+	}
+
+	if opts.commit {
+		if err := recordBannerPresence(service.db, website, banners, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]ingestResult, 0, len(banners))
+	for _, banner := range banners {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		result, err := ingestBanner(ctx, service, website, banner, opts)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func ingestBanner(ctx context.Context, service *Service, website Website, banner BannerData, opts ingestOptions) (ingestResult, error) {
+	result := ingestResult{Banner: banner}
+	if banner.Src == "" {
+		return result, nil
+	}
+
+	seen, err := bannerExists(service.db, banner.Src)
+	if err != nil {
+		return result, err
+	}
+	if seen {
+		result.Seen = true
+		return result, nil
+	}
+
+	// a dry run still downloads the banner so duplicates can be spotted, it just isn't stored.
+	// A banner we fail to archive is still posted, it falls back to the retailer's url.
+	var image StoredImage
+	switch {
+	case service.media == nil:
+	case opts.commit:
+		image, err = service.media.Archive(ctx, banner.Src)
+	default:
+		image, err = service.media.Inspect(ctx, banner.Src)
+	}
+	if err != nil {
+		result.Error = err.Error()
+	} else if image.Hash != "" {
+		result.Image = &image
+		originalID, distance, err := findDuplicatePost(service.db, website.WebsiteID, image.PHash, time.Now().Add(-duplicateWindow))
+		if err != nil {
+			return result, err
+		}
+		if originalID != 0 {
+			result.DuplicateOf = originalID
+			if opts.commit {
+				return result, saveBannerDuplicate(service.db, originalID, banner, distance)
+			}
+			return result, nil
+		}
+	}
+
+	if !opts.analyze {
+		return result, nil
+	}
+
+	if !opts.commit {
+		offer, review, err := analyzeBanner(ctx, service.db, website, banner, image.Hash)
+		result.Offer = offer
+		if err != nil {
+			result.Error = err.Error()
+			return result, nil
+		}
+		result.Review = &review
+		return result, nil
+	}
+
+	// the banner is queued first so a failure, or a crash, leaves it to be retried
+	queued, err := enqueueAnalysis(service.db, website, banner, image, time.Now())
+	if err != nil {
+		return result, err
+	}
+	offer, review, postID, err := service.attemptAnalysis(ctx, website, queued, time.Now())
+	result.Offer = offer
+	if err != nil {
+		result.Error = err.Error()
+		return result, nil
+	}
+	result.Review = &review
+	result.PostID = postID
+	return result, nil
+}
@@ -1,16 +1,21 @@
 package main
 
 import (
+	"beautybargains/internal/chat"
+	"beautybargains/internal/fetch"
+	"beautybargains/internal/metrics"
+	"context"
 	"database/sql"
-	"flag"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/joho/godotenv"
 	_ "github.com/mattn/go-sqlite3"
-	"github.com/seanomeara96/tgram"
 )
 
 const (
@@ -22,61 +27,182 @@ const (
 
 func main() {
 
-	if err := godotenv.Load(); err != nil {
-		log.Fatal(fmt.Errorf("failed to load .env file: %w", err))
+	if len(os.Args) > 1 && os.Args[1] == "ingest" {
+		if err := runIngest(os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	db, err := sql.Open("sqlite3", "main.db?_busy_timeout=5000&_journal_mode=WAL")
+	cfg, err := loadConfig(os.Args[1:])
 	if err != nil {
-		log.Fatal(fmt.Errorf("failed to open database: %w", err))
+		log.Fatal(err)
+	}
+
+	db, err := openDB(cfg)
+	if err != nil {
+		log.Fatal(err)
 	}
 	defer db.Close()
 
-	reportErr, err := tgram.NewErrorReporter(
-		"beautybargains.ie", os.Getenv("TGRAM_BOT_API_TOKEN"), os.Getenv("TGRAM_CHAT_ID"),
-	)
+	reporter, err := newErrorReporter(db, cfg)
 	if err != nil {
 		log.Fatalf("failed to connect the error reporter: %v", err)
 	}
+	reportErr := reporter.Report
 
-	service, err := NewService(db, reportErr)
+	service, err := newConfiguredService(cfg, db, reportErr, chat.NewSQLStore(db))
 	if err != nil {
-		log.Fatal(fmt.Errorf("failed to create new service: %w", err))
+		log.Fatal(err)
 	}
 	defer service.Close()
 
-	_skip := flag.Bool("skip", false, "skip bannner extraction and hashtag jobs")
-	_port := flag.String("port", "", "http port")
-	_mode := flag.String("mode", "", "deployment mode")
+	// ctx is cancelled on SIGINT/SIGTERM which starts the shutdown sequence
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	flag.Parse()
+	if cfg.MetricsPort != "" {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", metrics.Handler())
+		metricsServer := &http.Server{
+			Addr:         ":" + cfg.MetricsPort,
+			Handler:      mux,
+			ReadTimeout:  cfg.Timeouts.Read,
+			WriteTimeout: cfg.Timeouts.Write,
+			IdleTimeout:  cfg.Timeouts.Idle,
+		}
+		go func() {
+			log.Println("Metrics listening on http://localhost:" + cfg.MetricsPort + "/metrics")
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				reportErr(fmt.Errorf("metrics server error: %w", err))
+			}
+		}()
+		defer metricsServer.Close()
+	}
 
-	port := *_port
-	mode := Mode(*_mode)
-	skip := *_skip
-	// comment
+	// shutdownCtx expires Timeouts.Shutdown after ctx is cancelled, the server drain and the
+	// running jobs share that one grace period
+	shutdownCtx, cancelShutdown := shutdownContext(ctx, cfg.Timeouts.Shutdown)
+	defer cancelShutdown()
 
-	if !skip {
+	jobs := []struct {
+		name    string
+		failure string
+		run     func() error
+	}{
+		{"extract_offers", "extract offers from banners", func() error { return extractOffersFromBanners(ctx, service, cfg.Scraper.Workers) }},
+		{"scrape_products", "scrape products", func() error { return scrapeProducts(ctx, service, cfg.Scraper.ProductInterval) }},
+		{"match_products", "match products", func() error { return matchProducts(service) }},
+		{"send_price_alerts", "send price alerts", func() error { return sendPriceAlerts(service, cfg.Domain) }},
+		{"retry_analyses", "retry banner analyses", func() error { return retryAnalyses(ctx, service) }},
+		{"translate_posts", "translate posts", func() error { return translatePosts(ctx, service) }},
+		{"process_hashtags", "process hashtags", func() error { return processHashtags(service) }},
+		{"refresh_trending", "refresh trending hashtags", func() error { return refreshTrending(service) }},
+		{"score_posts", "score posts", func() error { return scorePosts(service) }},
+	}
+
+	// jobsDone is closed once the ingestion loop has exited
+	jobsDone := make(chan struct{})
+	if cfg.Skip {
+		close(jobsDone)
+	} else {
 		go func() {
+			defer close(jobsDone)
 			for {
 				log.Println("start jobs")
-				if err := extractOffersFromBanners(service); err != nil {
-					reportErr(fmt.Errorf("failed to extract offers from banners: %w", err))
-				}
-				if err := processHashtags(service); err != nil {
-					reportErr(fmt.Errorf("failed to process hashtags: %w", err))
-				}
-				if err := scorePosts(service); err != nil {
-					reportErr(fmt.Errorf("failed to score posts: %w", err))
+				for _, job := range jobs {
+					// the rest of the cycle waits for the next start
+					if ctx.Err() != nil {
+						log.Println("stopped jobs for shutdown")
+						return
+					}
+					if err := timeJob(job.name, job.run); err != nil && ctx.Err() == nil {
+						reportErr(fmt.Errorf("failed to %s: %w", job.failure, err))
+					}
 				}
 				log.Println("finished jobs")
-				time.Sleep(5 * time.Minute)
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(cfg.JobInterval):
+				}
 			}
 		}()
 	}
 
-	if err := server(port, mode, service); err != nil {
-		log.Fatal(fmt.Errorf("server error: %w", err))
+	serverErr := server(ctx, shutdownCtx, cfg, service)
+	if serverErr != nil {
+		stop()
+	}
+
+	select {
+	case <-jobsDone:
+	case <-shutdownCtx.Done():
+		// closing the database would fail the running job's writes half way, exiting leaves
+		// sqlite to roll an unfinished write back on the next start
+		log.Fatal("ingestion jobs did not finish before the shutdown deadline")
+	}
+
+	if serverErr != nil {
+		service.Close()
+		db.Close()
+		log.Fatal(fmt.Errorf("server error: %w", serverErr))
 	}
 
+	log.Println("shutdown complete")
+}
+
+func openDB(cfg Config) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", cfg.DBPath+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return db, nil
+}
+
+// newConfiguredService wires up the service, the scraper and the llm as cfg describes. The
+// server and the ingest command share it so a website is ingested the same way by both.
+// chatStore caches llm answers and records their usage.
+func newConfiguredService(cfg Config, db *sql.DB, reportErr func(error) error, chatStore chat.Store) (*Service, error) {
+	fetch.Configure(fetch.Config{
+		UserAgent:    cfg.Scraper.UserAgent,
+		Timeout:      cfg.Scraper.Timeout,
+		HostInterval: cfg.Scraper.HostInterval,
+		MaxRetries:   cfg.Scraper.MaxRetries,
+	})
+	configureChat(cfg.LLM, chatStore)
+
+	service, err := NewService(db, reportErr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new service: %w", err)
+	}
+	service.llm = chat.NewSQLStore(db)
+	service.moderate = cfg.ModeratePosts
+	service.retry = retryPolicy{MaxAttempts: cfg.LLM.MaxAttempts, Backoff: cfg.LLM.RetryBackoff}
+	service.translateTo = cfg.TranslateLocales
+	service.priceDrops = cfg.PriceDrops
+	service.scoreHalfLife = cfg.ScoreHalfLife
+	service.mail = newMailer(cfg.Mailer)
+	service.trendingConfig = cfg.Trending
+	if service.media, err = newMediaStore(cfg.MediaDir); err == nil {
+		service.ocr, err = newOCREngine(cfg.OCR)
+	}
+	if err != nil {
+		service.Close()
+		return nil, err
+	}
+	return service, nil
+}
+
+// shutdownContext returns a context that's done grace after ctx is
+func shutdownContext(ctx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	shutdownCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(grace, cancel)
+	})
+	return shutdownCtx, func() {
+		stop()
+		cancel()
+	}
 }
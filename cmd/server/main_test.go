@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestShutdownContext(t *testing.T) {
+	ctx, stop := context.WithCancel(context.Background())
+	shutdownCtx, cancel := shutdownContext(ctx, 20*time.Millisecond)
+	defer cancel()
+
+	select {
+	case <-shutdownCtx.Done():
+		t.Fatal("expected the grace period not to start before ctx is cancelled")
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	stop()
+	if shutdownCtx.Err() != nil {
+		t.Fatal("expected the grace period to start when ctx is cancelled")
+	}
+	select {
+	case <-shutdownCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the grace period to end")
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gosimple/slug"
+)
+
+/*
+Retailers sell the same product under slightly different names so offers are matched into
+catalog products for price comparison. An offer with a GTIN joins the catalog product with the
+same GTIN. Otherwise it joins the catalog product of the same brand and size whose name shares
+enough words with its own, names are compared without the brand, the size and filler words.
+Offers that match nothing start a new catalog product.
+*/
+
+// matchThreshold is the share of name words two products need in common to be the same product
+const matchThreshold = 0.75
+
+// sizePattern finds sizes like 30ml, 1,5 l, 50 g and 1.7 fl. oz
+var sizePattern = regexp.MustCompile(`(?i)(\d+(?:[.,]\d+)?)\s*(ml|l|g|kg|fl\.?\s?oz|oz)\b`)
+
+// nameFillers are left out when comparing names
+var nameFillers = map[string]bool{"the": true, "and": true, "with": true, "for": true, "of": true, "by": true}
+
+type CatalogProduct struct {
+	ID        int
+	Slug      string
+	BrandID   sql.NullInt64
+	Brand     string
+	Name      string
+	Size      string
+	GTIN      sql.NullString
+	Image     string
+	CreatedAt time.Time
+}
+
+// matchKey is what offers and catalog products are compared by
+type matchKey struct {
+	BrandID sql.NullInt64
+	Size    string
+	GTIN    string
+	Words   []string
+}
+
+type catalogCandidate struct {
+	ID int
+	matchKey
+}
+
+// parseSize returns the first size in text normalised like 30ml or 1.5l, "" when there's none
+func parseSize(text string) string {
+	m := sizePattern.FindStringSubmatch(text)
+	if m == nil {
+		return ""
+	}
+	amount, err := strconv.ParseFloat(strings.Replace(m[1], ",", ".", 1), 64)
+	if err != nil || amount == 0 {
+		return ""
+	}
+	unit := strings.ToLower(m[2])
+	if strings.HasPrefix(unit, "fl") {
+		unit = "floz"
+	}
+	return strconv.FormatFloat(amount, 'f', -1, 64) + unit
+}
+
+// nameWords are the sorted distinct words of name without the brand, sizes and fillers
+func nameWords(name, brand string) []string {
+	brandWords := map[string]bool{}
+	for _, w := range splitWords(brand) {
+		brandWords[w] = true
+	}
+	seen := map[string]bool{}
+	var words []string
+	for _, w := range splitWords(sizePattern.ReplaceAllString(name, " ")) {
+		if brandWords[w] || nameFillers[w] || seen[w] {
+			continue
+		}
+		seen[w] = true
+		words = append(words, w)
+	}
+	sort.Strings(words)
+	return words
+}
+
+func splitWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// similarity is the share of words in common, 1 for the same words
+func similarity(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	common := 0
+	for _, w := range a {
+		if i := sort.SearchStrings(b, w); i < len(b) && b[i] == w {
+			common++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
+
+// bestMatch returns the catalog product offer belongs to, false when it's a new product.
+// A GTIN match wins, otherwise the most similar name of the same brand and size. Products
+// without a brand only match on the exact same words, and products with different GTINs
+// never match.
+func bestMatch(offer matchKey, candidates []catalogCandidate) (int, bool) {
+	if offer.GTIN != "" {
+		for _, c := range candidates {
+			if c.GTIN == offer.GTIN {
+				return c.ID, true
+			}
+		}
+	}
+
+	threshold := matchThreshold
+	if !offer.BrandID.Valid {
+		threshold = 1
+	}
+	best, bestScore := 0, 0.0
+	for _, c := range candidates {
+		if c.BrandID != offer.BrandID || c.Size != offer.Size {
+			continue
+		}
+		if offer.GTIN != "" && c.GTIN != "" {
+			continue
+		}
+		if score := similarity(offer.Words, c.Words); score >= threshold && score > bestScore {
+			best, bestScore = c.ID, score
+		}
+	}
+	return best, bestScore > 0
+}
+
+// unmatchedOffer is an offer waiting for its catalog product
+type unmatchedOffer struct {
+	ID      int
+	Title   string
+	Product string
+	Brand   string
+	BrandID sql.NullInt64
+	GTIN    string
+	Image   string
+}
+
+func (o unmatchedOffer) key() matchKey {
+	size := parseSize(o.Title)
+	return matchKey{BrandID: o.BrandID, Size: size, GTIN: o.GTIN, Words: nameWords(o.Product, o.Brand)}
+}
+
+// matchProducts files every offer that isn't in the catalog yet under its catalog product
+func matchProducts(service *Service) error {
+	tx, err := service.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	offers, err := getUnmatchedOffers(tx)
+	if err != nil || len(offers) == 0 {
+		return err
+	}
+	candidates, slugs, err := getCatalogCandidates(tx)
+	if err != nil {
+		return err
+	}
+
+	created := 0
+	now := time.Now()
+	for _, offer := range offers {
+		key := offer.key()
+		catalogID, ok := bestMatch(key, candidates)
+		if !ok {
+			catalogID, err = createCatalogProduct(tx, offer, key, slugs, now)
+			if err != nil {
+				return err
+			}
+			candidates = append(candidates, catalogCandidate{ID: catalogID, matchKey: key})
+			created++
+		} else if key.GTIN != "" {
+			// a name match teaches the catalog product its GTIN
+			for i := range candidates {
+				if candidates[i].ID == catalogID && candidates[i].GTIN == "" {
+					candidates[i].GTIN = key.GTIN
+					if _, err := tx.Exec(`UPDATE catalog_products SET gtin = ? WHERE id = ?`, key.GTIN, catalogID); err != nil {
+						return fmt.Errorf("could not set the gtin of catalog product %d: %w", catalogID, err)
+					}
+				}
+			}
+		}
+		if _, err := tx.Exec(`UPDATE product_offers SET catalog_id = ? WHERE id = ?`, catalogID, offer.ID); err != nil {
+			return fmt.Errorf("could not match offer %d: %w", offer.ID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("matched %d offers, %d new catalog products", len(offers), created)
+	return nil
+}
+
+// getUnmatchedOffers returns the offers of crawled products that aren't in the catalog yet
+func getUnmatchedOffers(tx *sql.Tx) ([]unmatchedOffer, error) {
+	rows, err := tx.Query(`
+	SELECT
+		o.id,
+		o.name,
+		COALESCE(o.gtin, ''),
+		CASE WHEN o.image != '' THEN o.image ELSE p.image END,
+		p.name,
+		p.brand_id,
+		COALESCE(b.name, '')
+	FROM
+		product_offers o
+		JOIN products p ON p.id = o.product_id
+		LEFT JOIN brands b ON b.id = p.brand_id
+	WHERE
+		o.catalog_id IS NULL
+		AND p.name != ''
+	ORDER BY
+		o.id`)
+	if err != nil {
+		return nil, fmt.Errorf("could not get unmatched offers: %w", err)
+	}
+	defer rows.Close()
+
+	var offers []unmatchedOffer
+	for rows.Next() {
+		var o unmatchedOffer
+		var offerName string
+		if err := rows.Scan(&o.ID, &offerName, &o.GTIN, &o.Image, &o.Product, &o.BrandID, &o.Brand); err != nil {
+			return nil, fmt.Errorf("could not scan unmatched offer: %w", err)
+		}
+		o.Title = offerTitle(o.Product, offerName)
+		offers = append(offers, o)
+	}
+	return offers, rows.Err()
+}
+
+// getCatalogCandidates loads the whole catalog to match against, and the slugs in use
+func getCatalogCandidates(tx *sql.Tx) ([]catalogCandidate, map[string]bool, error) {
+	rows, err := tx.Query(`
+	SELECT
+		c.id,
+		c.slug,
+		c.brand_id,
+		COALESCE(b.name, ''),
+		c.name,
+		c.size,
+		COALESCE(c.gtin, '')
+	FROM
+		catalog_products c
+		LEFT JOIN brands b ON b.id = c.brand_id`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not get catalog products: %w", err)
+	}
+	defer rows.Close()
+
+	var candidates []catalogCandidate
+	slugs := map[string]bool{}
+	for rows.Next() {
+		var c catalogCandidate
+		var slug, brand, name string
+		if err := rows.Scan(&c.ID, &slug, &c.BrandID, &brand, &name, &c.Size, &c.GTIN); err != nil {
+			return nil, nil, fmt.Errorf("could not scan catalog product: %w", err)
+		}
+		c.Words = nameWords(name, brand)
+		candidates = append(candidates, c)
+		slugs[slug] = true
+	}
+	return candidates, slugs, rows.Err()
+}
+
+func createCatalogProduct(tx *sql.Tx, offer unmatchedOffer, key matchKey, slugs map[string]bool, now time.Time) (int, error) {
+	base := slug.Make(strings.Join([]string{offer.Brand, sizePattern.ReplaceAllString(offer.Product, ""), key.Size}, " "))
+	productSlug := base
+	for i := 2; slugs[productSlug]; i++ {
+		productSlug = fmt.Sprintf("%s-%d", base, i)
+	}
+	slugs[productSlug] = true
+
+	var id int
+	err := tx.QueryRow(`
+	INSERT INTO
+		catalog_products (slug, brand_id, name, size, gtin, image, created_at)
+	VALUES
+		(?, ?, ?, ?, ?, ?, ?)
+	RETURNING id`,
+		productSlug, key.BrandID, offer.Product, key.Size, sql.NullString{String: key.GTIN, Valid: key.GTIN != ""},
+		offer.Image, now.UTC(),
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("could not create catalog product %s: %w", productSlug, err)
+	}
+	return id, nil
+}
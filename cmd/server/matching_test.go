@@ -0,0 +1,78 @@
+package main
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]string{
+		"Hydrating Serum 30ml":         "30ml",
+		"Shampoo 1,5 L":                "1.5l",
+		"Body Butter 200.0g":           "200g",
+		"Eau de Parfum 1.7 fl. oz":     "1.7floz",
+		"Niacinamide 10% + Zinc 1%":    "",
+		"Lipstick 0ml":                 "",
+		"Travel Set 3 x 50ml and 10ml": "50ml",
+	}
+	for in, want := range cases {
+		if got := parseSize(in); got != want {
+			t.Errorf("parseSize(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestNameWords(t *testing.T) {
+	got := nameWords("The Ordinary Niacinamide 10% + Zinc 1% Serum 30ml", "The Ordinary")
+	want := []string{"1", "10", "niacinamide", "serum", "zinc"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBestMatch(t *testing.T) {
+	brand := sql.NullInt64{Int64: 1, Valid: true}
+	key := func(name, size, gtin string) matchKey {
+		return matchKey{BrandID: brand, Size: size, GTIN: gtin, Words: nameWords(name, "The Ordinary")}
+	}
+	candidates := []catalogCandidate{
+		{ID: 1, matchKey: key("Niacinamide 10% + Zinc 1%", "30ml", "")},
+		{ID: 2, matchKey: key("Niacinamide 10% + Zinc 1%", "60ml", "")},
+		{ID: 3, matchKey: key("Hyaluronic Acid 2% + B5", "30ml", "05060150185380")},
+	}
+
+	cases := []struct {
+		name string
+		key  matchKey
+		want int
+	}{
+		{"same words", key("The Ordinary Niacinamide 10% + Zinc 1% Serum", "30ml", ""), 1},
+		{"other size", key("Niacinamide 10% + Zinc 1%", "60ml", ""), 2},
+		{"gtin wins over the name", key("HA 2% Serum", "30ml", "05060150185380"), 3},
+		{"different gtin", key("Niacinamide 10% + Zinc 1%", "30ml", "00000096385074"), 1},
+		{"too different", key("Niacinamide Powder", "30ml", ""), 0},
+		{"no size", key("Niacinamide 10% + Zinc 1%", "", ""), 0},
+	}
+	for _, c := range cases {
+		got, ok := bestMatch(c.key, candidates)
+		if got != c.want || ok != (c.want != 0) {
+			t.Errorf("%s: got %d, %v, want %d", c.name, got, ok, c.want)
+		}
+	}
+
+	// the gtin conflicts with the only candidate
+	candidates[0].GTIN = "05000000000000"
+	if _, ok := bestMatch(key("Niacinamide 10% + Zinc 1%", "30ml", "00000096385074"), candidates[:1]); ok {
+		t.Error("expected products with different gtins not to match")
+	}
+
+	// without a brand only the exact same words match
+	unbranded := []catalogCandidate{{ID: 4, matchKey: matchKey{Words: nameWords("Rose Water Toner", "")}}}
+	if _, ok := bestMatch(matchKey{Words: nameWords("Rose Water Toner Spray", "")}, unbranded); ok {
+		t.Error("expected a similar unbranded name not to match")
+	}
+	if id, _ := bestMatch(matchKey{Words: nameWords("rose water toner", "")}, unbranded); id != 4 {
+		t.Error("expected the same unbranded name to match")
+	}
+}
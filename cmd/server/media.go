@@ -0,0 +1,283 @@
+package main
+
+import (
+	"beautybargains/internal/fetch"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+/*
+Retailers rotate their CDN assets so hotlinked banners break once a promotion ends. New banners
+are downloaded and stored by the sha256 of their contents under the media dir along with
+resized jpeg variants, then served from /media/.
+
+	media/ab/abcdef....jpg       original
+	media/ab/abcdef...-640.jpg   variant 640px wide
+
+Variants are jpeg only. golang.org/x/image can read webp but not write it and the encoders
+that can need cgo and libwebp, which the build doesn't otherwise depend on. The originals are
+served as the retailer sent them, webp included.
+*/
+
+// maxImagePixels bounds what we decode, a small file can claim huge dimensions and exhaust memory
+const maxImagePixels = 40_000_000
+
+// mediaWidths are the widths variants are generated at, wider than the original are skipped
+var mediaWidths = []int{320, 640, 1024}
+
+type MediaStore struct {
+	dir string
+}
+
+func newMediaStore(dir string) (*MediaStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create media dir %s: %w", dir, err)
+	}
+	return &MediaStore{dir: dir}, nil
+}
+
+// StoredImage describes an archived banner
+type StoredImage struct {
+	Hash   string `json:"hash"`
+	Ext    string `json:"ext"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	// PHash is the perceptual hash used to spot re-uploads of the same creative, see phash.go
+	PHash uint64 `json:"phash"`
+}
+
+// Archive downloads src and stores it. Images we already have are not written twice.
+func (m *MediaStore) Archive(ctx context.Context, src string) (StoredImage, error) {
+	res, err := fetch.Get(ctx, src)
+	if err != nil {
+		return StoredImage{}, fmt.Errorf("could not download banner %s: %w", src, err)
+	}
+	return m.Store(res.Body)
+}
+
+// Inspect downloads src and describes it as Archive would without writing anything
+func (m *MediaStore) Inspect(ctx context.Context, src string) (StoredImage, error) {
+	res, err := fetch.Get(ctx, src)
+	if err != nil {
+		return StoredImage{}, fmt.Errorf("could not download banner %s: %w", src, err)
+	}
+	stored, _, err := describeImage(res.Body)
+	return stored, err
+}
+
+// Read returns the original of an archived banner
+func (m *MediaStore) Read(image StoredImage) ([]byte, error) {
+	return os.ReadFile(filepath.Join(m.dir, filepath.FromSlash(image.Path())))
+}
+
+func describeImage(data []byte) (StoredImage, image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return StoredImage{}, nil, fmt.Errorf("could not decode image: %w", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxImagePixels {
+		return StoredImage{}, nil, fmt.Errorf("image is %dx%d, over the %d pixel limit", config.Width, config.Height, maxImagePixels)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return StoredImage{}, nil, fmt.Errorf("could not decode image: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return StoredImage{
+		Hash:   hex.EncodeToString(sum[:]),
+		Ext:    imageExt(format),
+		Width:  img.Bounds().Dx(),
+		Height: img.Bounds().Dy(),
+		PHash:  perceptualHash(img),
+	}, img, nil
+}
+
+// Store saves data content addressed and writes its resized variants
+func (m *MediaStore) Store(data []byte) (StoredImage, error) {
+	stored, img, err := describeImage(data)
+	if err != nil {
+		return StoredImage{}, err
+	}
+
+	if err := m.writeOnce(stored.Path(), data); err != nil {
+		return StoredImage{}, err
+	}
+
+	for _, width := range stored.Widths() {
+		path := stored.VariantPath(width)
+		if _, err := os.Stat(filepath.Join(m.dir, path)); err == nil {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, resizeToWidth(img, width), &jpeg.Options{Quality: 82}); err != nil {
+			return StoredImage{}, fmt.Errorf("could not encode %dpx variant: %w", width, err)
+		}
+		if err := m.writeOnce(path, buf.Bytes()); err != nil {
+			return StoredImage{}, err
+		}
+	}
+
+	return stored, nil
+}
+
+// writeOnce writes data to path relative to the media dir unless it already exists. Files are
+// written to a temp file and renamed so a half written image is never served.
+func (m *MediaStore) writeOnce(path string, data []byte) error {
+	full := filepath.Join(m.dir, path)
+	if _, err := os.Stat(full); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		return fmt.Errorf("could not create media dir: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(full), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("could not create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), full); err != nil {
+		return fmt.Errorf("could not move %s into place: %w", path, err)
+	}
+	return nil
+}
+
+// Handler serves stored media. Paths are content addressed so they can be cached forever.
+func (m *MediaStore) Handler() http.Handler {
+	files := http.StripPrefix("/media/", http.FileServer(http.Dir(m.dir)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		files.ServeHTTP(w, r)
+	})
+}
+
+func imageExt(format string) string {
+	switch format {
+	case "jpeg":
+		return "jpg"
+	case "":
+		return "img"
+	}
+	return format
+}
+
+// Path is the original image relative to the media dir
+func (s StoredImage) Path() string {
+	return filepath.ToSlash(filepath.Join(s.Hash[:2], s.Hash+"."+s.Ext))
+}
+
+// VariantPath is the resized jpeg of the given width relative to the media dir
+func (s StoredImage) VariantPath(width int) string {
+	return filepath.ToSlash(filepath.Join(s.Hash[:2], s.Hash+"-"+strconv.Itoa(width)+".jpg"))
+}
+
+// Widths are the variant widths that exist for the image
+func (s StoredImage) Widths() []int {
+	var widths []int
+	for _, w := range mediaWidths {
+		if w < s.Width {
+			widths = append(widths, w)
+		}
+	}
+	return widths
+}
+
+// URL is where the original is served from
+func (s StoredImage) URL() string {
+	return "/media/" + s.Path()
+}
+
+// SrcSet lists the variants and the original for an img srcset attribute
+func (s StoredImage) SrcSet() string {
+	var parts []string
+	for _, w := range s.Widths() {
+		parts = append(parts, fmt.Sprintf("/media/%s %dw", s.VariantPath(w), w))
+	}
+	parts = append(parts, fmt.Sprintf("%s %dw", s.URL(), s.Width))
+	return strings.Join(parts, ", ")
+}
+
+func resizeToWidth(src image.Image, width int) image.Image {
+	b := src.Bounds()
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	// jpeg has no alpha, without a background transparent areas would encode as black
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+	return dst
+}
+
+// dataURL inlines an image for the llm, which can't fetch /media/ from a private server
+func dataURL(image StoredImage, data []byte) string {
+	return "data:" + mime.TypeByExtension("."+image.Ext) + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// storedImageFromPost rebuilds the stored image details saved on a post
+func storedImageFromPost(p Post) (StoredImage, bool) {
+	if !p.ImageHash.Valid || len(p.ImageHash.String) < 2 {
+		return StoredImage{}, false
+	}
+	return StoredImage{
+		Hash:   p.ImageHash.String,
+		Ext:    p.ImageExt.String,
+		Width:  int(p.ImageWidth.Int64),
+		Height: int(p.ImageHeight.Int64),
+	}, true
+}
+
+func savePostImage(tx *sql.Tx, postID int, img StoredImage) error {
+	if img.Hash == "" {
+		return errors.New("expected an image hash")
+	}
+	if _, err := tx.Exec(`
+	UPDATE
+		posts
+	SET
+		image_hash = ?,
+		image_ext = ?,
+		image_width = ?,
+		image_height = ?,
+		image_phash = ?
+	WHERE
+		id = ?`,
+		img.Hash, img.Ext, img.Width, img.Height, int64(img.PHash), postID,
+	); err != nil {
+		return fmt.Errorf("could not save image for post %d: %w", postID, err)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestMediaStoreStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := newMediaStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := store.Store(testPNG(t, 800, 400))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Ext != "png" || stored.Width != 800 || stored.Height != 400 {
+		t.Fatalf("unexpected stored image %+v", stored)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, stored.Path())); err != nil {
+		t.Fatalf("original not written: %v", err)
+	}
+
+	// 1024 is wider than the original so only two variants are made
+	if got := stored.Widths(); len(got) != 2 || got[0] != 320 || got[1] != 640 {
+		t.Fatalf("expected variants 320 and 640 got %v", got)
+	}
+	f, err := os.Open(filepath.Join(dir, stored.VariantPath(320)))
+	if err != nil {
+		t.Fatalf("variant not written: %v", err)
+	}
+	defer f.Close()
+	variant, err := jpeg.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := variant.Bounds(); b.Dx() != 320 || b.Dy() != 160 {
+		t.Fatalf("expected 320x160 variant got %dx%d", b.Dx(), b.Dy())
+	}
+
+	want := "/media/" + stored.VariantPath(320) + " 320w, /media/" + stored.VariantPath(640) + " 640w, " + stored.URL() + " 800w"
+	if got := stored.SrcSet(); got != want {
+		t.Fatalf("expected srcset %q got %q", want, got)
+	}
+
+	again, err := store.Store(testPNG(t, 800, 400))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Hash != stored.Hash {
+		t.Fatal("expected identical images to share a hash")
+	}
+}
+
+func TestMediaStoreRejectsNonImages(t *testing.T) {
+	store, err := newMediaStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Store([]byte("<html>not an image</html>")); err == nil {
+		t.Fatal("expected an error storing a non image")
+	}
+}
+
+func TestMediaStoreVariantsFillTransparency(t *testing.T) {
+	dir := t.TempDir()
+	store, err := newMediaStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 800, 400))); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.Store(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, stored.VariantPath(320)))
+	if err != nil {
+		t.Fatalf("variant not written: %v", err)
+	}
+	defer f.Close()
+	variant, err := jpeg.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, g, b, _ := variant.At(160, 80).RGBA()
+	if r>>8 < 250 || g>>8 < 250 || b>>8 < 250 {
+		t.Fatalf("expected transparent areas to be white got %d,%d,%d", r>>8, g>>8, b>>8)
+	}
+}
+
+func TestMediaStoreRejectsOversizedImages(t *testing.T) {
+	store, err := newMediaStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a paletted png of this size compresses to a few kb
+	var buf bytes.Buffer
+	img := image.NewPaletted(image.Rect(0, 0, 10000, 5000), color.Palette{color.White})
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Store(buf.Bytes()); err == nil {
+		t.Fatal("expected an error storing an image over the pixel limit")
+	}
+}
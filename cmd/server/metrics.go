@@ -0,0 +1,80 @@
+package main
+
+import (
+	"beautybargains/internal/metrics"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	httpRequestDuration = metrics.NewHistogram(
+		"beautybargains_http_request_duration_seconds", "HTTP request latency by route and status code.",
+		nil, "route", "code",
+	)
+	bannersSeen = metrics.NewCounter(
+		"beautybargains_banners_total", "Banners found on retailer homepages by website and status (discovered, new, skipped, duplicate).",
+		"website", "status",
+	)
+	analysisAttempts = metrics.NewCounter(
+		"beautybargains_analysis_attempts_total", "Banner analysis attempts by website and outcome (ok, retry, dead, deferred).",
+		"website", "outcome",
+	)
+	subscriberSignups = metrics.NewCounter(
+		"beautybargains_subscriber_signups_total", "Newsletter signups by outcome.",
+		"status",
+	)
+	jobDuration = metrics.NewHistogram(
+		"beautybargains_job_duration_seconds", "Background job run time by job and outcome.",
+		[]float64{1, 5, 15, 30, 60, 120, 300, 600, 1200}, "job", "status",
+	)
+)
+
+// statusRecorder captures the status code written by a handler so it can be used as a metric label
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// observeRequest records the duration of a request against its route pattern. Requests that
+// returned an error without writing a response are recorded as a 500.
+func observeRequest(route string, start time.Time, rec *statusRecorder, err error) {
+	code := rec.status
+	if code == 0 {
+		code = http.StatusOK
+		if err != nil {
+			code = http.StatusInternalServerError
+		}
+	}
+	httpRequestDuration.Observe(time.Since(start).Seconds(), route, strconv.Itoa(code))
+}
+
+// timeJob runs fn and records how long it took under the given job name
+func timeJob(name string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	jobDuration.Observe(time.Since(start).Seconds(), name, status)
+	return err
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+Posts are published as approved unless moderation is switched on with MODERATE_POSTS, or
+normalising the offer flagged it, in which case they wait as pending in the /admin/review queue.
+Public pages only ever show approved posts, and the coupons found on them.
+*/
+
+const (
+	postPending  = "pending"
+	postApproved = "approved"
+	postRejected = "rejected"
+)
+
+// errPostNotPending is returned when a post has been reviewed since the queue was loaded,
+// usually by another admin or a second submit
+var errPostNotPending = errors.New("post is no longer pending review")
+
+// reviewItem is a pending post with everything the llm said about its banner
+type reviewItem struct {
+	Post       Post
+	Website    Website
+	Image      ExtraImage
+	Brands     []string
+	Categories []string
+	Coupons    []CouponCode
+}
+
+// postEdit is what an admin changed while reviewing a post
+type postEdit struct {
+	Description string
+	Brands      []string
+	Categories  []string
+	// Coupons replace the post's coupons, they are only public once the post is approved
+	Coupons []CouponCode
+}
+
+// GetReviewQueue returns pending posts oldest first, so nothing waits forever
+func (s *Service) GetReviewQueue(limit, offset int) ([]reviewItem, error) {
+	rows, err := s.db.Query(`
+	SELECT
+		`+postColumns+`
+	FROM
+		posts
+	WHERE
+		status = ?
+	ORDER BY
+		timestamp ASC
+	LIMIT ? OFFSET ?`,
+		postPending, limit, offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not get posts for review: %w", err)
+	}
+	defer rows.Close()
+	posts, err := scanPosts(rows, make([]Post, 0, limit))
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]reviewItem, 0, len(posts))
+	for _, post := range posts {
+		item := reviewItem{Post: post, Image: ExtraImage{Src: post.SrcURL}}
+		if stored, ok := storedImageFromPost(post); ok {
+			item.Image = ExtraImage{Src: stored.URL(), SrcSet: stored.SrcSet(), Width: stored.Width, Height: stored.Height}
+		}
+		if item.Website, err = getWebsiteByID(post.WebsiteID); err != nil {
+			return nil, fmt.Errorf("could not get website by id %d: %w", post.WebsiteID, err)
+		}
+		if item.Brands, err = s.getPostNames(post.ID, "post_brands", "brands", "brand_id"); err != nil {
+			return nil, err
+		}
+		if item.Categories, err = s.getPostNames(post.ID, "post_categories", "categories", "category_id"); err != nil {
+			return nil, err
+		}
+		if item.Coupons, err = s.getPostCoupons(post.ID); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// getPostNames returns the names of the brands or categories linked to a post. The table names
+// are our own, never user input.
+func (s *Service) getPostNames(postID int, joinTable, table, column string) ([]string, error) {
+	rows, err := s.db.Query(`
+	SELECT
+		t.name
+	FROM
+		`+joinTable+` j
+		JOIN `+table+` t ON t.id = j.`+column+`
+	WHERE
+		j.post_id = ?
+	ORDER BY
+		j.id`,
+		postID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not get %s for post %d: %w", table, postID, err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+func (s *Service) getPostCoupons(postID int) ([]CouponCode, error) {
+	rows, err := s.db.Query(`
+	SELECT
+		id,
+		code,
+		description,
+		valid_until
+	FROM
+		coupon_codes
+	WHERE
+		post_id = ?
+	ORDER BY
+		id`,
+		postID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not get coupons for post %d: %w", postID, err)
+	}
+	defer rows.Close()
+
+	var coupons []CouponCode
+	for rows.Next() {
+		var coupon CouponCode
+		if err := rows.Scan(&coupon.ID, &coupon.Code, &coupon.Description, &coupon.ValidUntil); err != nil {
+			return nil, err
+		}
+		coupons = append(coupons, coupon)
+	}
+	return coupons, rows.Err()
+}
+
+// ReviewPost applies an admin's edits to a pending post and sets its status. Passing
+// postPending saves the edits and leaves the post in the queue. Edits are ignored on rejection.
+func (s *Service) ReviewPost(postID int, edit postEdit, status string) error {
+	switch status {
+	case postPending, postApproved, postRejected:
+	default:
+		return fmt.Errorf("unknown post status %q", status)
+	}
+
+	// edited names go through the same aliases as the llm's, loaded before the transaction
+	// takes the connection
+	brands, err := loadNameResolver(s.db, "brand", "brands")
+	if err != nil {
+		return err
+	}
+	categories, err := loadNameResolver(s.db, "category", "categories")
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current string
+	var websiteID int
+	if err := tx.QueryRow(`SELECT status, website_id FROM posts WHERE id = ?`, postID).Scan(&current, &websiteID); err != nil {
+		return fmt.Errorf("could not get status of post %d: %w", postID, err)
+	}
+	if current != postPending {
+		return fmt.Errorf("post %d has already been %s: %w", postID, current, errPostNotPending)
+	}
+
+	if status != postRejected {
+		if err := savePostEdit(tx, postID, websiteID, edit, brands, categories); err != nil {
+			return err
+		}
+	}
+
+	var reviewedAt sql.NullTime
+	if status != postPending {
+		reviewedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	}
+	if _, err := tx.Exec(`
+	UPDATE
+		posts
+	SET
+		status = ?,
+		reviewed_at = ?,
+		scored_at = NULL
+	WHERE
+		id = ?`,
+		status, reviewedAt, postID,
+	); err != nil {
+		return fmt.Errorf("could not set status of post %d: %w", postID, err)
+	}
+
+	return tx.Commit()
+}
+
+func savePostEdit(tx *sql.Tx, postID, websiteID int, edit postEdit, brands, categories nameResolver) error {
+	description := strings.TrimSpace(edit.Description)
+	if description == "" {
+		return fmt.Errorf("post %d needs a description", postID)
+	}
+	if _, err := tx.Exec(`UPDATE posts SET description = ? WHERE id = ?`, description, postID); err != nil {
+		return fmt.Errorf("could not update description of post %d: %w", postID, err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM post_brands WHERE post_id = ?`, postID); err != nil {
+		return fmt.Errorf("could not clear brands of post %d: %w", postID, err)
+	}
+	if err := savePostBrands(tx, postID, canonicalNames(edit.Brands, brands)); err != nil {
+		return fmt.Errorf("error saving post brands for post %d: %w", postID, err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM post_categories WHERE post_id = ?`, postID); err != nil {
+		return fmt.Errorf("could not clear categories of post %d: %w", postID, err)
+	}
+	if err := savePostCategories(tx, postID, canonicalNames(edit.Categories, categories)); err != nil {
+		return fmt.Errorf("error saving post categories for post %d: %w", postID, err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM coupon_codes WHERE post_id = ?`, postID); err != nil {
+		return fmt.Errorf("could not clear coupons of post %d: %w", postID, err)
+	}
+	if err := saveOfferCouponCodes(tx, Website{WebsiteID: websiteID}, postID, edit.Coupons); err != nil {
+		return fmt.Errorf("error saving coupons for post %d: %w", postID, err)
+	}
+	return nil
+}
+
+// parseCouponRows reads the coupon rows of the review form, a row per coupon with the code,
+// description and optional expiry date. Rows without a code are left out.
+func parseCouponRows(form url.Values) ([]CouponCode, error) {
+	codes, descriptions, expiries := form["coupon_code"], form["coupon_description"], form["coupon_valid_until"]
+	if len(descriptions) != len(codes) || len(expiries) != len(codes) {
+		return nil, fmt.Errorf("every coupon needs a code, description and expiry field")
+	}
+
+	var coupons []CouponCode
+	seen := map[string]bool{}
+	for i, code := range codes {
+		code = strings.TrimSpace(code)
+		if code == "" {
+			continue
+		}
+		if reason := implausibleCouponCode(code); reason != "" {
+			return nil, fmt.Errorf("coupon code %q was rejected: %s", code, reason)
+		}
+		if seen[strings.ToUpper(code)] {
+			return nil, fmt.Errorf("coupon code %q is listed twice", code)
+		}
+		seen[strings.ToUpper(code)] = true
+
+		coupon := CouponCode{Code: code, Description: strings.TrimSpace(descriptions[i])}
+		if expiry := strings.TrimSpace(expiries[i]); expiry != "" {
+			validUntil, err := time.Parse(time.DateOnly, expiry)
+			if err != nil {
+				return nil, fmt.Errorf("coupon %s expiry %q is not a date", code, expiry)
+			}
+			coupon.ValidUntil = &validUntil
+		}
+		coupons = append(coupons, coupon)
+	}
+	return coupons, nil
+}
+
+// splitNameList splits a comma separated list typed into a form
+func splitNameList(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// joinNames is the inverse of splitNameList
+func joinNames(names []string) string {
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitNameList(t *testing.T) {
+	cases := map[string][]string{
+		"":                           nil,
+		" , ,":                       nil,
+		"Clinique":                   {"Clinique"},
+		"Clinique, La Roche-Posay ,": {"Clinique", "La Roche-Posay"},
+	}
+	for in, want := range cases {
+		got := splitNameList(in)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("splitNameList(%q) = %q, want %q", in, got, want)
+		}
+		if want != nil && splitNameList(joinNames(got)) == nil {
+			t.Errorf("joinNames(%q) did not round trip", got)
+		}
+	}
+}
+
+func TestReviewPost(t *testing.T) {
+	s := newTestService(t)
+	mustExec(t, s.db, `INSERT INTO brands (id, name, path) VALUES (1, 'La Roche-Posay', 'la-roche-posay')`)
+	mustExec(t, s.db, `INSERT INTO posts (id, website_id, description, status) VALUES (1, 1, 'old', ?)`, postPending)
+	mustExec(t, s.db, `INSERT INTO coupon_codes (code, description, website_id, post_id) VALUES ('SAVE2O', 'misread', 1, 1)`)
+
+	edit := postEdit{
+		Description: " 20% off skincare ",
+		Brands:      []string{"la roche posay"},
+		Categories:  []string{"skin care", "Skincare"},
+		Coupons:     []CouponCode{{Code: "SAVE20", Description: "20% off"}},
+	}
+	if err := s.ReviewPost(1, edit, postApproved); err != nil {
+		t.Fatal(err)
+	}
+
+	brands, err := s.getPostNames(1, "post_brands", "brands", "brand_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(brands, []string{"La Roche-Posay"}) {
+		t.Errorf("expected the existing brand got %q", brands)
+	}
+	categories, err := s.getPostNames(1, "post_categories", "categories", "category_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(categories, []string{"Skincare"}) {
+		t.Errorf("expected the aliased category once got %q", categories)
+	}
+
+	coupons, err := s.getPostCoupons(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(coupons) != 1 || coupons[0].Code != "SAVE20" {
+		t.Errorf("expected the edited coupon to replace the old one got %+v", coupons)
+	}
+
+	if err := s.ReviewPost(1, edit, postRejected); !errors.Is(err, errPostNotPending) {
+		t.Errorf("expected reviewing an approved post to fail with errPostNotPending got %v", err)
+	}
+}
+
+func TestAdminHandlePostReviewNotPending(t *testing.T) {
+	s := newTestService(t)
+	mustExec(t, s.db, `INSERT INTO posts (id, website_id, description, status) VALUES (1, 1, 'approved already', ?)`, postApproved)
+	h := &Handler{service: s}
+
+	for id, want := range map[string]int{"1": http.StatusSeeOther, "2": http.StatusNotFound} {
+		form := url.Values{"decision": {"approve"}, "description": {"20% off"}}
+		r := httptest.NewRequest("POST", "/admin/review/"+id, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+		if err := h.adminHandlePostReview(w, r); err != nil {
+			t.Fatalf("post %s: %v", id, err)
+		}
+		if w.Code != want {
+			t.Errorf("post %s: expected status %d got %d", id, want, w.Code)
+		}
+	}
+}
+
+func TestParseCouponRows(t *testing.T) {
+	form := url.Values{
+		"coupon_code":        {"SAVE20", " ", "FREESHIP"},
+		"coupon_description": {"20% off", "", "free delivery"},
+		"coupon_valid_until": {"2026-05-31", "", ""},
+	}
+	coupons, err := parseCouponRows(form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(coupons) != 2 || coupons[0].Code != "SAVE20" || coupons[1].Code != "FREESHIP" {
+		t.Fatalf("expected the two filled in rows got %+v", coupons)
+	}
+	if coupons[0].ValidUntil == nil || coupons[0].ValidUntil.Format("2006-01-02") != "2026-05-31" || coupons[1].ValidUntil != nil {
+		t.Errorf("unexpected expiries %v %v", coupons[0].ValidUntil, coupons[1].ValidUntil)
+	}
+
+	bad := []url.Values{
+		{"coupon_code": {"!!"}, "coupon_description": {""}, "coupon_valid_until": {""}},
+		{"coupon_code": {"SAVE20", "save20"}, "coupon_description": {"", ""}, "coupon_valid_until": {"", ""}},
+		{"coupon_code": {"SAVE20"}, "coupon_description": {""}, "coupon_valid_until": {"31/05/2026"}},
+		{"coupon_code": {"SAVE20"}},
+	}
+	for _, form := range bad {
+		if _, err := parseCouponRows(form); err == nil {
+			t.Errorf("expected %v to be rejected", form)
+		}
+	}
+}
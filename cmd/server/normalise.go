@@ -0,0 +1,238 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+/*
+Whatever the llm returns is cleaned up before it is saved. Brand and category names are mapped
+to a canonical spelling, first through the aliases table and then by matching existing rows
+while ignoring case, spacing and punctuation, so "skin care" and "Skin-Care" land on the same
+category. Implausible coupon codes and deal values are dropped and dates are clamped. Every fix lowers
+the confidence of the result and posts below reviewConfidence are flagged for an admin to check.
+*/
+
+const (
+	// reviewConfidence is the confidence below which a post is queued for review
+	reviewConfidence = 0.7
+	// maxCouponValidity is the furthest ahead a coupon expiry is believed
+	maxCouponValidity = 365 * 24 * time.Hour
+	maxOfferBrands    = 10
+	maxOfferTags      = 6
+)
+
+var (
+	couponCodePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{2,19}$`)
+	// words the model sometimes reports as codes because they appear in big letters on a banner
+	notCouponCodes = map[string]bool{
+		"SALE": true, "SHOP": true, "SHOPNOW": true, "NOW": true, "NEW": true, "FREE": true,
+		"OFF": true, "CODE": true, "COUPON": true, "NONE": true, "NULL": true, "NA": true,
+		"DISCOUNT": true, "OFFER": true, "DEAL": true, "DEALS": true, "PROMO": true,
+	}
+)
+
+// offerReview is what normalising an offer found
+type offerReview struct {
+	Confidence float64  `json:"confidence"`
+	Issues     []string `json:"issues,omitempty"`
+}
+
+func (r offerReview) NeedsReview() bool {
+	return r.Confidence < reviewConfidence
+}
+
+func (r *offerReview) flag(penalty float64, format string, args ...any) {
+	r.Confidence -= penalty
+	if r.Confidence < 0 {
+		r.Confidence = 0
+	}
+	r.Issues = append(r.Issues, fmt.Sprintf(format, args...))
+}
+
+// nameResolver maps names to their canonical spelling. Both maps are keyed by aliasKey.
+type nameResolver struct {
+	aliases map[string]string
+	known   map[string]string
+}
+
+// aliasKey is name lowercased with anything that isn't a letter or digit removed
+func aliasKey(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// resolve returns the canonical name, the second result is false for names never seen before
+func (n nameResolver) resolve(name string) (string, bool) {
+	key := aliasKey(name)
+	if canonical, ok := n.aliases[key]; ok {
+		return canonical, true
+	}
+	if existing, ok := n.known[key]; ok {
+		return existing, true
+	}
+	return strings.Join(strings.Fields(name), " "), false
+}
+
+// loadNameResolver reads the aliases of kind ("brand" or "category") and the names already in
+// table. The oldest row wins when existing names only differ by case or punctuation.
+func loadNameResolver(db *sql.DB, kind, table string) (nameResolver, error) {
+	n := nameResolver{aliases: map[string]string{}, known: map[string]string{}}
+
+	rows, err := db.Query(`SELECT alias, canonical FROM aliases WHERE kind = ?`, kind)
+	if err != nil {
+		return n, fmt.Errorf("could not load %s aliases: %w", kind, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var alias, canonical string
+		if err := rows.Scan(&alias, &canonical); err != nil {
+			return n, err
+		}
+		n.aliases[aliasKey(alias)] = canonical
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+
+	// table is one of our own table names, never user input
+	names, err := db.Query(`SELECT name FROM ` + table + ` WHERE name IS NOT NULL ORDER BY id`)
+	if err != nil {
+		return n, fmt.Errorf("could not load %s names: %w", kind, err)
+	}
+	defer names.Close()
+	for names.Next() {
+		var name string
+		if err := names.Scan(&name); err != nil {
+			return n, err
+		}
+		if key := aliasKey(name); key != "" {
+			if _, ok := n.known[key]; !ok {
+				n.known[key] = name
+			}
+		}
+	}
+	return n, names.Err()
+}
+
+// normaliseOffer cleans offer in place and reports how much it had to change
+func normaliseOffer(db *sql.DB, offer *OfferDescriptionResponse, now time.Time) (offerReview, error) {
+	brands, err := loadNameResolver(db, "brand", "brands")
+	if err != nil {
+		return offerReview{}, err
+	}
+	categories, err := loadNameResolver(db, "category", "categories")
+	if err != nil {
+		return offerReview{}, err
+	}
+	return cleanOffer(offer, brands, categories, now), nil
+}
+
+func cleanOffer(offer *OfferDescriptionResponse, brands, categories nameResolver, now time.Time) offerReview {
+	review := offerReview{Confidence: 1}
+
+	offer.Description = strings.TrimSpace(offer.Description)
+	if offer.Description == "" {
+		review.flag(1, "the description is empty")
+	}
+
+	offer.Brands = canonicalNames(offer.Brands, brands)
+	if len(offer.Brands) > maxOfferBrands {
+		review.flag(0.2, "%d brands were listed, only the first %d were kept", len(offer.Brands), maxOfferBrands)
+		offer.Brands = offer.Brands[:maxOfferBrands]
+	}
+
+	offer.Categories = canonicalNames(offer.Categories, categories)
+	if len(offer.Categories) > maxOfferTags {
+		review.flag(0.1, "%d categories were listed, only the first %d were kept", len(offer.Categories), maxOfferTags)
+		offer.Categories = offer.Categories[:maxOfferTags]
+	}
+
+	coupons := make([]CouponCode, 0, len(offer.CouponCodes))
+	seen := map[string]bool{}
+	for _, coupon := range offer.CouponCodes {
+		coupon.Code = strings.TrimSpace(coupon.Code)
+		coupon.Description = strings.TrimSpace(coupon.Description)
+
+		if reason := implausibleCouponCode(coupon.Code); reason != "" {
+			review.flag(0.35, "coupon code %q was rejected: %s", coupon.Code, reason)
+			continue
+		}
+		if seen[strings.ToUpper(coupon.Code)] {
+			continue
+		}
+		seen[strings.ToUpper(coupon.Code)] = true
+
+		if coupon.ValidUntil != nil {
+			switch {
+			case coupon.ValidUntil.Before(now):
+				// usually the model guessing the wrong year for a "valid until 5th May" banner
+				review.flag(0.15, "coupon %s expiry %s is in the past and was dropped", coupon.Code, coupon.ValidUntil.Format(time.DateOnly))
+				coupon.ValidUntil = nil
+			case coupon.ValidUntil.After(now.Add(maxCouponValidity)):
+				review.flag(0.15, "coupon %s expiry %s is too far ahead and was clamped", coupon.Code, coupon.ValidUntil.Format(time.DateOnly))
+				clamped := now.Add(maxCouponValidity)
+				coupon.ValidUntil = &clamped
+			}
+		}
+		coupons = append(coupons, coupon)
+	}
+	offer.CouponCodes = coupons
+
+	// the rules only see what the llm wrote, the description and what its coupons are for
+	text := offer.Description
+	for _, coupon := range coupons {
+		text += "\n" + coupon.Description
+	}
+	cleanDeal(&offer.Deal, text, now, &review)
+
+	return review
+}
+
+// canonicalNames resolves names, dropping blanks and duplicates
+func canonicalNames(names []string, resolver nameResolver) []string {
+	out := make([]string, 0, len(names))
+	seen := map[string]bool{}
+	for _, name := range names {
+		if aliasKey(name) == "" {
+			continue
+		}
+		canonical, _ := resolver.resolve(name)
+		key := aliasKey(canonical)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, canonical)
+	}
+	return out
+}
+
+// implausibleCouponCode explains why code doesn't look like a coupon code, or returns ""
+func implausibleCouponCode(code string) string {
+	switch {
+	case code == "":
+		return "it is empty"
+	case !couponCodePattern.MatchString(code):
+		return "codes are 3 to 20 letters, digits, dashes or underscores"
+	case notCouponCodes[strings.ToUpper(code)]:
+		return "it is a word from the banner, not a code"
+	}
+	return ""
+}
+
+func savePostReviewReasons(tx *sql.Tx, postID int, issues []string) error {
+	if _, err := tx.Exec(`UPDATE posts SET review_reasons = ? WHERE id = ?`, strings.Join(issues, "\n"), postID); err != nil {
+		return fmt.Errorf("could not flag post %d for review: %w", postID, err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNameResolver(t *testing.T) {
+	n := nameResolver{
+		aliases: map[string]string{aliasKey("skin care"): "Skincare"},
+		known:   map[string]string{aliasKey("La Roche-Posay"): "La Roche-Posay"},
+	}
+	cases := map[string]struct {
+		want  string
+		known bool
+	}{
+		"Skin-Care":       {"Skincare", true},
+		"la roche posay":  {"La Roche-Posay", true},
+		"  New   Brand  ": {"New Brand", false},
+	}
+	for name, c := range cases {
+		got, known := n.resolve(name)
+		if got != c.want || known != c.known {
+			t.Errorf("resolve(%q) = %q, %v, want %q, %v", name, got, known, c.want, c.known)
+		}
+	}
+}
+
+func TestCleanOffer(t *testing.T) {
+	now := time.Date(2024, 11, 1, 9, 0, 0, 0, time.UTC)
+	past := now.Add(-48 * time.Hour)
+	soon := now.Add(7 * 24 * time.Hour)
+	categories := nameResolver{aliases: map[string]string{"skin": "Skincare"}, known: map[string]string{}}
+	brands := nameResolver{aliases: map[string]string{}, known: map[string]string{"clinique": "Clinique"}}
+
+	offer := &OfferDescriptionResponse{
+		Description: "  20% off Clinique  ",
+		Brands:      []string{"clinique", "CLINIQUE", ""},
+		Categories:  []string{"Skin", "skincare"},
+		CouponCodes: []CouponCode{
+			{Code: "SAVE20", ValidUntil: &soon},
+			{Code: "save20"},
+			{Code: "SALE"},
+			{Code: "OLD10", ValidUntil: &past},
+		},
+	}
+	review := cleanOffer(offer, brands, categories, now)
+
+	if offer.Description != "20% off Clinique" {
+		t.Errorf("description was not trimmed: %q", offer.Description)
+	}
+	if len(offer.Brands) != 1 || offer.Brands[0] != "Clinique" {
+		t.Errorf("unexpected brands %v", offer.Brands)
+	}
+	if len(offer.Categories) != 1 || offer.Categories[0] != "Skincare" {
+		t.Errorf("unexpected categories %v", offer.Categories)
+	}
+	if len(offer.CouponCodes) != 2 || offer.CouponCodes[0].Code != "SAVE20" || offer.CouponCodes[1].ValidUntil != nil {
+		t.Errorf("unexpected coupons %+v", offer.CouponCodes)
+	}
+	// a rejected code and a past expiry
+	if len(review.Issues) != 2 || review.Confidence != 0.5 || !review.NeedsReview() {
+		t.Errorf("unexpected review %+v", review)
+	}
+
+	clean := &OfferDescriptionResponse{Description: "Free gift", CouponCodes: []CouponCode{{Code: "GIFT"}}}
+	if review := cleanOffer(clean, brands, categories, now); review.NeedsReview() || len(review.Issues) != 0 {
+		t.Errorf("a clean offer was flagged: %+v", review)
+	}
+}
+
+func TestImplausibleCouponCode(t *testing.T) {
+	for code, ok := range map[string]bool{
+		"SAVE20":                 true,
+		"BF-2024":                true,
+		"":                       false,
+		"AB":                     false,
+		"20% OFF":                false,
+		"shopnow":                false,
+		"ABCDEFGHIJKLMNOPQRSTUV": false,
+	} {
+		if got := implausibleCouponCode(code) == ""; got != ok {
+			t.Errorf("implausibleCouponCode(%q) accepted = %v, want %v", code, got, ok)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"beautybargains/internal/fetch"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+When the llm can't describe a banner, because the budget is spent or it has failed on every
+attempt, the banner is read with an OCR engine that runs locally and the offer pulled out of
+the text with the rules below. The result is a plain post marked as machine extracted, so the
+feed keeps updating and an admin can tell which posts the llm never saw.
+
+The engine is the tesseract command line, reading the image from stdin:
+
+	tesseract stdin stdout -l eng
+*/
+
+// ocrModel is recorded as the model of machine extracted posts
+const ocrModel = "ocr"
+
+// errNothingExtracted is returned when a banner has no text worth posting
+var errNothingExtracted = errors.New("no offer could be extracted from the banner text")
+
+type OCRConfig struct {
+	// Command is the tesseract binary, empty disables the fallback
+	Command string
+	// Languages are passed to tesseract's -l, e.g. "eng" or "eng+gle"
+	Languages string
+	Timeout   time.Duration
+}
+
+type ocrEngine struct {
+	command   string
+	languages string
+	timeout   time.Duration
+}
+
+// newOCREngine returns nil when the fallback is disabled or the command isn't installed
+func newOCREngine(cfg OCRConfig) (*ocrEngine, error) {
+	if cfg.Command == "" {
+		return nil, nil
+	}
+	path, err := exec.LookPath(cfg.Command)
+	if err != nil {
+		return nil, fmt.Errorf("could not find ocr command %s: %w", cfg.Command, err)
+	}
+	return &ocrEngine{command: path, languages: cfg.Languages, timeout: cfg.Timeout}, nil
+}
+
+// Text reads the text in an image
+func (e *ocrEngine) Text(ctx context.Context, img []byte) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	args := []string{"stdin", "stdout"}
+	if e.languages != "" {
+		args = append(args, "-l", e.languages)
+	}
+	cmd := exec.CommandContext(ctx, e.command, args...)
+	cmd.Stdin = bytes.NewReader(img)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("ocr failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// extractOfferOffline reads banner and builds an offer from its text without the llm
+func (s *Service) extractOfferOffline(ctx context.Context, website Website, banner BannerData, image StoredImage, now time.Time) (*OfferDescriptionResponse, error) {
+	if s.ocr == nil {
+		return nil, errors.New("the ocr fallback is disabled")
+	}
+	img, err := s.bannerImage(ctx, banner, image)
+	if err != nil {
+		return nil, err
+	}
+	text, err := s.ocr.Text(ctx, img)
+	if err != nil {
+		return nil, err
+	}
+	// the retailer's own alt text or caption is often cleaner than what the OCR read
+	if banner.SupportingText != "" {
+		text = banner.SupportingText + "\n" + text
+	}
+	return offerFromText(website.WebsiteName, text, now)
+}
+
+// bannerImage returns the archived copy of a banner, downloading it when there isn't one
+func (s *Service) bannerImage(ctx context.Context, banner BannerData, image StoredImage) ([]byte, error) {
+	if s.media != nil && image.Hash != "" {
+		if data, err := s.media.Read(image); err == nil {
+			return data, nil
+		}
+	}
+	res, err := fetch.Get(ctx, banner.Src)
+	if err != nil {
+		return nil, fmt.Errorf("could not download banner %s: %w", banner.Src, err)
+	}
+	if len(res.Body) == 0 {
+		return nil, fmt.Errorf("banner %s came back empty", banner.Src)
+	}
+	return res.Body, nil
+}
+
+// the code itself is matched case sensitively, banners print codes in capitals
+var codePattern = regexp.MustCompile(`(?i:\b(?:promo\s*|coupon\s*|discount\s*)?(?:code|coupon))\s*[:\-]?\s*([A-Z0-9][A-Z0-9_-]{2,19})\b`)
+
+// offerFromText applies the extraction rules to the text of a banner on website
+func offerFromText(website, text string, now time.Time) (*OfferDescriptionResponse, error) {
+	text = strings.Join(strings.Fields(text), " ")
+
+	var deals []string
+	seen := map[string]bool{}
+	add := func(deal string) {
+		if key := strings.ToLower(deal); !seen[key] {
+			seen[key] = true
+			deals = append(deals, deal)
+		}
+	}
+	for _, m := range percentOffPattern.FindAllStringSubmatch(text, -1) {
+		if n, _ := strconv.Atoi(m[2]); n > 0 {
+			add(upTo(m[1]) + m[2] + "% off")
+		}
+	}
+	for _, m := range euroOffPattern.FindAllStringSubmatch(text, -1) {
+		add(upTo(m[1]) + "€" + strings.ReplaceAll(m[2], ",", ".") + " off")
+	}
+
+	ends := extractEndDate(text, now)
+
+	var coupons []CouponCode
+	for _, m := range codePattern.FindAllStringSubmatch(text, -1) {
+		code := m[1]
+		if implausibleCouponCode(code) != "" || seen["code "+code] {
+			continue
+		}
+		seen["code "+code] = true
+		coupon := CouponCode{Code: code, Description: strings.Join(deals, ", ")}
+		if coupon.Description == "" {
+			coupon.Description = "Discount code at " + website
+		}
+		coupon.ValidUntil = ends
+		coupons = append(coupons, coupon)
+	}
+
+	if len(deals) == 0 && len(coupons) == 0 {
+		return nil, errNothingExtracted
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s is running a promotion", website)
+	if len(deals) > 0 {
+		fmt.Fprintf(&b, ": %s", strings.Join(deals, ", "))
+	}
+	b.WriteString(".")
+	for _, coupon := range coupons {
+		fmt.Fprintf(&b, " Use code %s.", coupon.Code)
+	}
+	if ends != nil {
+		fmt.Fprintf(&b, " Ends %s.", ends.Format("2 January"))
+	}
+
+	return &OfferDescriptionResponse{
+		Description:      b.String(),
+		CouponCodes:      coupons,
+		Deal:             dealFromText(text, now),
+		Model:            ocrModel,
+		MachineExtracted: true,
+	}, nil
+}
+
+func upTo(match string) string {
+	if match == "" {
+		return ""
+	}
+	return "up to "
+}
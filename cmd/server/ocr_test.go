@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOfferFromText(t *testing.T) {
+	now := time.Date(2025, time.May, 10, 12, 0, 0, 0, time.UTC)
+
+	offer, err := offerFromText("Boots", "UP TO 30% OFF\nSKINCARE\nPlus €10 off when you spend €50\nUse code GLOW10 ends 25th May", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Boots is running a promotion: up to 30% off, €10 off. Use code GLOW10. Ends 25 May."
+	if offer.Description != want {
+		t.Errorf("description\n got %q\nwant %q", offer.Description, want)
+	}
+	if !offer.MachineExtracted || offer.Model != ocrModel {
+		t.Error("expected the offer to be marked as machine extracted")
+	}
+	if len(offer.CouponCodes) != 1 || offer.CouponCodes[0].Code != "GLOW10" {
+		t.Fatalf("expected coupon GLOW10, got %+v", offer.CouponCodes)
+	}
+	if ends := offer.CouponCodes[0].ValidUntil; ends == nil || ends.Format(time.DateOnly) != "2025-05-25" {
+		t.Errorf("expected the coupon to end on 2025-05-25, got %v", ends)
+	}
+
+	// words after "code" that aren't in capitals aren't codes
+	if _, err := offerFromText("Boots", "Discount applied at checkout, no code needed", now); !errors.Is(err, errNothingExtracted) {
+		t.Errorf("expected nothing to be extracted, got %v", err)
+	}
+
+	offer, err = offerFromText("Boots", "Coupon code: SUMMER25", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offer.CouponCodes) != 1 || offer.CouponCodes[0].Code != "SUMMER25" {
+		t.Errorf("expected coupon SUMMER25, got %+v", offer.CouponCodes)
+	}
+}
+
+func TestExtractEndDate(t *testing.T) {
+	now := time.Date(2025, time.May, 10, 12, 0, 0, 0, time.UTC)
+	cases := map[string]string{
+		"Sale ends 25th May":      "2025-05-25",
+		"Offer valid until 3 Jan": "2026-01-03",
+		"Ends 31/05":              "2025-05-31",
+		"Until 1.6.2025":          "2025-06-01",
+		"Ends 30/02":              "",
+		"Starts 25th May":         "",
+		"25% off everything":      "",
+	}
+	for text, want := range cases {
+		got := extractEndDate(text, now)
+		switch {
+		case want == "" && got != nil:
+			t.Errorf("%q: expected no end date, got %s", text, got.Format(time.DateOnly))
+		case want != "" && (got == nil || got.Format(time.DateOnly) != want):
+			t.Errorf("%q: expected %s, got %v", text, want, got)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"image"
+	"math/bits"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+/*
+Retailers re-upload the same creative under new urls, a different CDN query string or size
+parameter is enough to defeat the src_url check. Banners are fingerprinted with a difference
+hash which survives resizing and recompression, and a banner within a few bits of a recent post
+from the same retailer is recorded as a duplicate of that post instead of being posted again.
+*/
+
+const (
+	// phashMaxDistance is the most bits two hashes can differ by and still be the same creative
+	phashMaxDistance = 6
+	// duplicateWindow is how far back posts are compared, a creative reused after this is reposted
+	duplicateWindow = 90 * 24 * time.Hour
+)
+
+// perceptualHash is the 64 bit difference hash of img. The image is shrunk to 9x8 greyscale
+// and each bit records whether a pixel is brighter than its right hand neighbour.
+func perceptualHash(img image.Image) uint64 {
+	small := image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.BiLinear.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if small.GrayAt(x, y).Y > small.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// findDuplicatePost returns the id of the closest recent post from website whose banner is a
+// near duplicate of hash, or 0 when there is none
+func findDuplicatePost(db *sql.DB, websiteID int, hash uint64, since time.Time) (postID int, distance int, err error) {
+	rows, err := db.Query(`
+	SELECT
+		id,
+		image_phash
+	FROM
+		posts
+	WHERE
+		website_id = ?
+		AND image_phash IS NOT NULL
+		AND timestamp >= ?`,
+		websiteID,
+		since,
+	)
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not query recent banner hashes for website %d: %w", websiteID, err)
+	}
+	defer rows.Close()
+
+	best := phashMaxDistance + 1
+	for rows.Next() {
+		var id int
+		var phash int64
+		if err := rows.Scan(&id, &phash); err != nil {
+			return 0, 0, err
+		}
+		if d := hammingDistance(hash, uint64(phash)); d < best {
+			best, postID = d, id
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	if postID == 0 {
+		return 0, 0, nil
+	}
+	return postID, best, nil
+}
+
+// saveBannerDuplicate links a banner url to the post it duplicates so it isn't downloaded again
+func saveBannerDuplicate(db *sql.DB, postID int, banner BannerData, distance int) error {
+	if _, err := db.Exec(`
+	INSERT INTO
+		banner_duplicates (
+			post_id,
+			src_url,
+			href,
+			distance,
+			timestamp
+		)
+	VALUES
+		(?, ?, ?, ?, ?)`,
+		postID,
+		banner.Src,
+		banner.Href,
+		distance,
+		time.Now(),
+	); err != nil {
+		return fmt.Errorf("could not link banner %s to post %d: %w", banner.Src, postID, err)
+	}
+	return nil
+}
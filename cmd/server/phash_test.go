@@ -0,0 +1,44 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"golang.org/x/image/draw"
+)
+
+func gradientImage(width, height int, flip bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			v := uint8(x * 255 / width)
+			if flip {
+				v = 255 - v
+			}
+			if (y*8/height)%2 == 0 {
+				v /= 2
+			}
+			img.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	return img
+}
+
+func TestPerceptualHashSurvivesResizing(t *testing.T) {
+	original := gradientImage(800, 400, false)
+	resized := image.NewRGBA(image.Rect(0, 0, 320, 160))
+	draw.CatmullRom.Scale(resized, resized.Bounds(), original, original.Bounds(), draw.Over, nil)
+
+	if d := hammingDistance(perceptualHash(original), perceptualHash(resized)); d > phashMaxDistance {
+		t.Fatalf("expected a resized banner to be a near duplicate, distance %d", d)
+	}
+}
+
+func TestPerceptualHashDiffersForDifferentImages(t *testing.T) {
+	a := perceptualHash(gradientImage(800, 400, false))
+	b := perceptualHash(gradientImage(800, 400, true))
+	if d := hammingDistance(a, b); d <= phashMaxDistance {
+		t.Fatalf("expected different banners to be far apart, distance %d", d)
+	}
+}
@@ -16,8 +16,46 @@ type Post struct {
 	Timestamp   time.Time
 	AuthorID    int // supposed to correspond with a persona id
 	Score       float64
+
+	// archived copy of the banner, see media.go
+	ImageHash   sql.NullString
+	ImageExt    sql.NullString
+	ImageWidth  sql.NullInt64
+	ImageHeight sql.NullInt64
+
+	// when the banner was first and last seen on the retailer's homepage, see promotions.go
+	FirstSeen sql.NullTime
+	LastSeen  sql.NullTime
+	Active    bool
+
+	// ReviewReasons lists, one per line, why normalising the offer flagged it for review
+	ReviewReasons sql.NullString
+	// Status is one of postPending, postApproved or postRejected, see moderation.go
+	Status         string
+	SupportingText sql.NullString
+
+	// the prompt version and model that wrote Description, see prompts.go
+	PromptVersion sql.NullInt64
+	Model         sql.NullString
+	// MachineExtracted posts were written from the banner's text without the llm, see ocr.go
+	MachineExtracted bool
+	Deal             Deal
+	// ProductID is set on price drop posts, see pricedrops.go
+	ProductID sql.NullInt64
+	// Clicks counts readers sent to Link through /go, see clicks.go
+	Clicks int
 }
 
+// postColumns are the columns scanPost expects, in order
+const postColumns = `id, website_id, src_url, author_id, score, description, timestamp,
+	image_hash, image_ext, image_width, image_height,
+	first_seen, last_seen, active,
+	review_reasons, status, supporting_text,
+	prompt_version, model, machine_extracted,
+	percent_off, amount_off, min_spend, free_gift, free_shipping, bogof, starts_at, ends_at,
+	product_id, lowest_90_days, all_time_low,
+	link, clicks`
+
 type scannable interface {
 	Scan(dest ...any) error
 }
@@ -32,6 +70,32 @@ func scanPost(row scannable) (Post, error) {
 		&post.Score,
 		&post.Description,
 		&post.Timestamp,
+		&post.ImageHash,
+		&post.ImageExt,
+		&post.ImageWidth,
+		&post.ImageHeight,
+		&post.FirstSeen,
+		&post.LastSeen,
+		&post.Active,
+		&post.ReviewReasons,
+		&post.Status,
+		&post.SupportingText,
+		&post.PromptVersion,
+		&post.Model,
+		&post.MachineExtracted,
+		&post.Deal.PercentOff,
+		&post.Deal.AmountOff,
+		&post.Deal.MinSpend,
+		&post.Deal.FreeGift,
+		&post.Deal.FreeShipping,
+		&post.Deal.BOGOF,
+		&post.Deal.StartsAt,
+		&post.Deal.EndsAt,
+		&post.ProductID,
+		&post.Deal.LowestIn90Days,
+		&post.Deal.AllTimeLow,
+		&post.Link,
+		&post.Clicks,
 	); err != nil {
 		return Post{}, err
 	}
@@ -57,18 +121,25 @@ type getPostParams struct {
 	Offset        int
 	SortBy        string
 	SortAscending bool
+	// AnyStatus includes pending and rejected posts, only admin pages should set it
+	AnyStatus bool
 }
 
 func (s *Service) getPosts(params getPostParams) ([]Post, error) {
 
 	var queryBuilder strings.Builder
-	queryBuilder.WriteString("SELECT id, website_id, src_url, author_id, score, description, timestamp FROM posts")
+	queryBuilder.WriteString("SELECT " + postColumns + " FROM posts")
 
 	args := make([]any, 0)
-	if params.WebsiteID != 0 || len(params.IDs) > 0 {
+	if params.WebsiteID != 0 || len(params.IDs) > 0 || !params.AnyStatus {
 		queryBuilder.WriteString(" WHERE ")
 		conditions := make([]string, 0)
 
+		if !params.AnyStatus {
+			conditions = append(conditions, "status = ?")
+			args = append(args, postApproved)
+		}
+
 		if params.WebsiteID != 0 {
 			conditions = append(conditions, "website_id = ?")
 			args = append(args, params.WebsiteID)
@@ -136,34 +207,41 @@ func (s *Service) getPosts(params getPostParams) ([]Post, error) {
 	return posts, nil
 }
 
-func (s *Service) GetPreviewPosts(website Website, postIDs []int) ([]Post, error) {
-	args := []any{}
+func (s *Service) GetPreviewPosts(website Website, postIDs []int, filter dealFilter) ([]Post, error) {
+	args := []any{postApproved}
 	var q strings.Builder
 	q.WriteString(`
 	WITH orderedPosts AS (
 		SELECT 
-			id, 
-			website_id, 
-			src_url, 
-			author_id, 
-			Score, 
-			description, 
-			timestamp
-		FROM posts p `)
+			` + postColumns + `
+		FROM posts p
+		WHERE p.status = ? `)
 
 	if len(postIDs) > 0 {
-		q.WriteString(`WHERE p.id IN (`)
+		q.WriteString(`AND p.id IN (`)
 		q.WriteString(strings.Repeat("?,", len(postIDs)-1) + "?)")
 		for _, id := range postIDs {
 			args = append(args, id)
 		}
 	} else if website.WebsiteID != 0 {
-		q.WriteString(`WHERE website_id = ?`)
+		q.WriteString(`AND website_id = ?`)
 		args = append(args, website.WebsiteID)
 	}
 
-	q.WriteString(` ORDER BY timestamp DESC LIMIT 6)
+	conditions, filterArgs := filter.conditions(time.Now())
+	for _, condition := range conditions {
+		q.WriteString(` AND ` + condition)
+	}
+	args = append(args, filterArgs...)
+
+	// a chosen sort replaces the usual pick of the best scoring recent posts
+	if orderBy := filter.orderBy(); orderBy != "" {
+		q.WriteString(` ORDER BY ` + orderBy + ` LIMIT 6)
+	SELECT * FROM orderedPosts ORDER BY ` + orderBy)
+	} else {
+		q.WriteString(` ORDER BY timestamp DESC LIMIT 6)
 	SELECT * FROM orderedPosts ORDER BY score DESC LIMIT 6`)
+	}
 
 	rows, err := s.db.Query(q.String(), args...)
 	if err != nil {
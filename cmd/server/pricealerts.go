@@ -0,0 +1,325 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+Subscribers can watch a product from its price drop posts. They get an email when one of its
+offers drops, see pricedrops.go, or when it reaches the price they asked for. Each alert is only
+sent again once the price is lower than the one we last told them about. Alerts are batched per
+subscriber and only go to verified subscribers.
+
+An alert starts once the subscriber follows the link we email when they ask for it, so nobody
+can sign someone else up. Asking looks the same whether or not the email is subscribed.
+*/
+
+// priceAlertRequestTTL is how long a confirmation link works
+const priceAlertRequestTTL = 7 * 24 * time.Hour
+
+// RequestPriceAlert saves an alert on productID for the subscriber with email and emails them
+// a link to confirm it, see ConfirmPriceAlert. A zero targetPrice alerts on every drop. Emails
+// that aren't verified subscribers are ignored so callers can answer the same either way and
+// the form doesn't tell anyone who's subscribed. siteURL is where the link points, the domain
+// with the reader's locale prefix.
+func (s *Service) RequestPriceAlert(email string, productID int, targetPrice float64, siteURL string, now time.Time) error {
+	if _, err := s.db.Exec(`DELETE FROM price_alert_requests WHERE created_at <= ?`, now.Add(-priceAlertRequestTTL).UTC()); err != nil {
+		return fmt.Errorf("could not remove expired price alert requests: %w", err)
+	}
+
+	var subscriberID int
+	err := s.db.QueryRow(`SELECT id FROM subscribers WHERE email = ? AND is_verified = 1`, email).Scan(&subscriberID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not get subscriber: %w", err)
+	}
+	var product string
+	if err := s.db.QueryRow(`SELECT name FROM products WHERE id = ?`, productID).Scan(&product); err != nil {
+		return fmt.Errorf("could not get product %d: %w", productID, err)
+	}
+
+	token, err := newPriceAlertToken()
+	if err != nil {
+		return err
+	}
+	// asking again replaces the request and its link
+	if _, err := s.db.Exec(`
+	INSERT INTO
+		price_alert_requests (subscriber_id, product_id, target_price, token, created_at)
+	VALUES
+		(?, ?, ?, ?, ?)
+	ON CONFLICT(subscriber_id, product_id) DO UPDATE SET
+		target_price = excluded.target_price,
+		token = excluded.token,
+		created_at = excluded.created_at`,
+		subscriberID, productID, sql.NullFloat64{Float64: targetPrice, Valid: targetPrice > 0}, token, now.UTC(),
+	); err != nil {
+		return fmt.Errorf("could not save price alert request for product %d: %w", productID, err)
+	}
+
+	if s.mail == nil {
+		log.Printf("no mail server to confirm the price alert on product %d", productID)
+		return nil
+	}
+	subject, body := priceAlertConfirmationEmail(product, siteURL, token)
+	// sent in the background so the response doesn't take longer for subscribers
+	go func() {
+		if err := s.mail.Send(email, subject, body); err != nil {
+			s.ReportErr(err)
+		}
+	}()
+	return nil
+}
+
+func priceAlertConfirmationEmail(product, siteURL, token string) (subject, body string) {
+	subject = "Confirm your price alert for " + product
+	body = fmt.Sprintf("Someone, hopefully you, asked us to email you when %s gets cheaper.\n\n"+
+		"To start the alert:\n%s/alerts/confirm?token=%s\n\n"+
+		"If it wasn't you, ignore this email and nothing will be sent.\n",
+		product, siteURL, url.QueryEscape(token))
+	return subject, body
+}
+
+// ConfirmPriceAlert starts the alert requested with token, returning its product. Watching a
+// product again replaces the old target. sql.ErrNoRows is returned for unknown or expired tokens.
+func (s *Service) ConfirmPriceAlert(token string, now time.Time) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var subscriberID, productID int
+	var targetPrice sql.NullFloat64
+	err = tx.QueryRow(`
+	SELECT
+		subscriber_id,
+		product_id,
+		target_price
+	FROM
+		price_alert_requests
+	WHERE
+		token = ?
+		AND created_at > ?`,
+		token, now.Add(-priceAlertRequestTTL).UTC(),
+	).Scan(&subscriberID, &productID, &targetPrice)
+	if err != nil {
+		return 0, fmt.Errorf("could not get price alert request: %w", err)
+	}
+
+	alertToken, err := newPriceAlertToken()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`
+	INSERT INTO
+		price_alerts (subscriber_id, product_id, target_price, token, created_at)
+	VALUES
+		(?, ?, ?, ?, ?)
+	ON CONFLICT(subscriber_id, product_id) DO UPDATE SET
+		target_price = excluded.target_price,
+		notified_price = NULL,
+		notified_at = NULL,
+		created_at = excluded.created_at`,
+		subscriberID, productID, targetPrice, alertToken, now.UTC(),
+	); err != nil {
+		return 0, fmt.Errorf("could not save price alert for product %d: %w", productID, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM price_alert_requests WHERE token = ?`, token); err != nil {
+		return 0, fmt.Errorf("could not remove price alert request: %w", err)
+	}
+	return productID, tx.Commit()
+}
+
+func newPriceAlertToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", fmt.Errorf("failed to generate price alert token: %w", err)
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// RemovePriceAlert deletes the alert with token, reporting whether there was one
+func (s *Service) RemovePriceAlert(token string) (bool, error) {
+	res, err := s.db.Exec(`DELETE FROM price_alerts WHERE token = ?`, token)
+	if err != nil {
+		return false, fmt.Errorf("could not remove price alert: %w", err)
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// dueAlert is a price alert ready to send with the cheapest offer of its product
+type dueAlert struct {
+	ID             int
+	Token          string
+	Email          string
+	Product        string
+	WebsiteID      int
+	OfferID        int
+	Offer          string
+	Price          float64
+	Currency       string
+	LowestIn90Days bool
+	AllTimeLow     bool
+}
+
+// getDueAlerts returns the alerts whose product is cheaper than when they were last sent and has
+// either dropped since or reached their target, ordered by subscriber
+func (s *Service) getDueAlerts() ([]dueAlert, error) {
+	rows, err := s.db.Query(`
+	SELECT
+		a.id,
+		a.token,
+		s.email,
+		p.name,
+		p.website_id,
+		o.id,
+		o.name,
+		o.price,
+		o.currency,
+		o.lowest_90_days,
+		o.all_time_low
+	FROM
+		price_alerts a
+		JOIN subscribers s ON s.id = a.subscriber_id
+		JOIN products p ON p.id = a.product_id
+		JOIN product_offers o ON o.id = (
+			SELECT
+				id
+			FROM
+				product_offers
+			WHERE
+				product_id = p.id
+				AND last_seen = p.last_crawled
+			ORDER BY
+				price
+			LIMIT 1
+		)
+	WHERE
+		s.is_verified = 1
+		AND (a.notified_price IS NULL OR o.price < a.notified_price)
+		AND (
+			(a.target_price IS NOT NULL AND o.price <= a.target_price)
+			OR (
+				a.target_price IS NULL
+				AND EXISTS (
+					SELECT
+						1
+					FROM
+						price_drops d
+						JOIN product_offers po ON po.id = d.offer_id
+					WHERE
+						po.product_id = p.id
+						AND d.detected_at > COALESCE(a.notified_at, a.created_at)
+				)
+			)
+		)
+	ORDER BY
+		s.email,
+		p.name`)
+	if err != nil {
+		return nil, fmt.Errorf("could not get due price alerts: %w", err)
+	}
+	defer rows.Close()
+
+	var alerts []dueAlert
+	for rows.Next() {
+		var a dueAlert
+		if err := rows.Scan(
+			&a.ID,
+			&a.Token,
+			&a.Email,
+			&a.Product,
+			&a.WebsiteID,
+			&a.OfferID,
+			&a.Offer,
+			&a.Price,
+			&a.Currency,
+			&a.LowestIn90Days,
+			&a.AllTimeLow,
+		); err != nil {
+			return nil, fmt.Errorf("could not scan price alert: %w", err)
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+// priceAlertEmail writes one email for all of a subscriber's due alerts
+func priceAlertEmail(alerts []dueAlert, domain string) (subject, body string) {
+	subject = "Price drop on " + offerTitle(alerts[0].Product, alerts[0].Offer)
+	if len(alerts) > 1 {
+		subject = fmt.Sprintf("Price drops on %d products you're watching", len(alerts))
+	}
+
+	var b strings.Builder
+	b.WriteString("Good news, prices have dropped on products you're watching.\n")
+	for _, a := range alerts {
+		where := ""
+		if website, err := getWebsiteByID(a.WebsiteID); err == nil {
+			where = " at " + website.WebsiteName
+		}
+		fmt.Fprintf(&b, "\n%s: now %s%s", offerTitle(a.Product, a.Offer), formatPrice(a.Price, a.Currency), where)
+		if a.AllTimeLow {
+			b.WriteString(", the lowest price ever")
+		} else if a.LowestIn90Days {
+			b.WriteString(", the lowest price in 90 days")
+		}
+		// through /go so the click is counted and the affiliate template applied
+		fmt.Fprintf(&b, "\n%s/go/offer/%d\n", domain, a.OfferID)
+	}
+	b.WriteString("\nTo stop these alerts:\n")
+	for _, a := range alerts {
+		fmt.Fprintf(&b, "%s: %s/alerts/remove?token=%s\n", a.Product, domain, url.QueryEscape(a.Token))
+	}
+	return subject, b.String()
+}
+
+// sendPriceAlerts emails subscribers whose products got cheaper. Alerts wait while no mail
+// server is configured.
+func sendPriceAlerts(service *Service, domain string) error {
+	if service.mail == nil {
+		return nil
+	}
+	alerts, err := service.getDueAlerts()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for start := 0; start < len(alerts); {
+		end := start + 1
+		for end < len(alerts) && alerts[end].Email == alerts[start].Email {
+			end++
+		}
+		batch := alerts[start:end]
+		start = end
+
+		subject, body := priceAlertEmail(batch, domain)
+		if err := service.mail.Send(batch[0].Email, subject, body); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		now := time.Now().UTC()
+		for _, a := range batch {
+			if _, err := service.db.Exec(`UPDATE price_alerts SET notified_price = ?, notified_at = ? WHERE id = ?`,
+				a.Price, now, a.ID,
+			); err != nil {
+				return fmt.Errorf("could not mark price alert %d as sent: %w", a.ID, err)
+			}
+		}
+		log.Printf("sent %d price alerts to subscriber", len(batch))
+	}
+	return errors.Join(errs...)
+}
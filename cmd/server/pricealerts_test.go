@@ -0,0 +1,148 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestService runs sql/init.sql in a fresh in memory database
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	schema, err := os.ReadFile("../../sql/init.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: is a new database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	// sqlite creates sqlite_sequence itself
+	if _, err := db.Exec(strings.Replace(string(schema), "CREATE TABLE sqlite_sequence(name, seq);", "", 1)); err != nil {
+		t.Fatal(err)
+	}
+	service, err := NewService(db, func(err error) error { return err })
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { service.Close() })
+	return service
+}
+
+func mustExec(t *testing.T, db *sql.DB, query string, args ...any) {
+	t.Helper()
+	if _, err := db.Exec(query, args...); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+}
+
+func TestGetDueAlerts(t *testing.T) {
+	s := newTestService(t)
+	now := time.Date(2026, 5, 10, 12, 0, 0, 0, time.UTC)
+	crawled := now.Add(-time.Hour)
+
+	mustExec(t, s.db, `INSERT INTO subscribers (id, email, consent, is_verified) VALUES (1, 'a@example.com', 1, 1), (2, 'b@example.com', 1, 0), (3, 'c@example.com', 1, 1)`)
+	mustExec(t, s.db, `INSERT INTO products (id, website_id, name, url, last_crawled, created_at) VALUES
+		(1, 1, 'Serum', 'https://shop.ie/serum', ?, ?),
+		(2, 1, 'Cream', 'https://shop.ie/cream', ?, ?)`, crawled, now, crawled, now)
+	// the serum's current offers are 20 and 25, an old 15 is no longer listed
+	mustExec(t, s.db, `INSERT INTO product_offers (id, product_id, sku, price, url, last_seen) VALUES
+		(1, 1, 'a', 25, 'https://shop.ie/serum?a', ?),
+		(2, 1, 'b', 20, 'https://shop.ie/serum?b', ?),
+		(3, 1, 'c', 15, 'https://shop.ie/serum?c', ?),
+		(4, 2, 'a', 40, 'https://shop.ie/cream', ?)`, crawled, crawled, now.Add(-48*time.Hour), crawled)
+	mustExec(t, s.db, `INSERT INTO price_drops (offer_id, previous_price, reference_price, price, detected_at) VALUES (2, 30, 30, 20, ?)`, crawled)
+
+	for _, a := range []struct {
+		id, subscriber, product int
+		target                  any
+	}{
+		{1, 1, 1, nil},  // any drop, dropped since it was created
+		{2, 1, 2, 45.0}, // target reached
+		{3, 2, 1, nil},  // unverified subscriber
+		{4, 3, 1, 10.0}, // target not reached
+	} {
+		mustExec(t, s.db, `INSERT INTO price_alerts (id, subscriber_id, product_id, target_price, token, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			a.id, a.subscriber, a.product, a.target, a.id, now.Add(-24*time.Hour))
+	}
+
+	alerts, err := s.getDueAlerts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 2 {
+		t.Fatalf("expected 2 due alerts, got %+v", alerts)
+	}
+	if a := alerts[0]; a.ID != 2 || a.Product != "Cream" || a.Price != 40 {
+		t.Errorf("expected the cream's target alert first, got %+v", a)
+	}
+	if a := alerts[1]; a.ID != 1 || a.Price != 20 || a.OfferID != 2 {
+		t.Errorf("expected the serum's cheapest listed offer, got %+v", a)
+	}
+
+	// once sent, an alert waits for a lower price
+	mustExec(t, s.db, `UPDATE price_alerts SET notified_price = 20, notified_at = ? WHERE id = 1`, now)
+	if alerts, err := s.getDueAlerts(); err != nil || len(alerts) != 1 || alerts[0].ID != 2 {
+		t.Errorf("expected only the cream alert after sending the serum's, got %+v %v", alerts, err)
+	}
+}
+
+func TestConfirmPriceAlert(t *testing.T) {
+	s := newTestService(t)
+	now := time.Date(2026, 5, 10, 12, 0, 0, 0, time.UTC)
+	mustExec(t, s.db, `INSERT INTO subscribers (id, email, consent, is_verified) VALUES (1, 'a@example.com', 1, 1), (2, 'b@example.com', 1, 0)`)
+	mustExec(t, s.db, `INSERT INTO products (id, website_id, name, url, created_at) VALUES (1, 1, 'Serum', 'https://shop.ie/serum', ?)`, now)
+
+	requests := func() int {
+		var n int
+		if err := s.db.QueryRow(`SELECT COUNT(*) FROM price_alert_requests`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	// strangers and unverified subscribers get the same answer and nothing is saved
+	for _, email := range []string{"nobody@example.com", "b@example.com"} {
+		if err := s.RequestPriceAlert(email, 1, 0, "https://beautybargains.ie", now); err != nil {
+			t.Fatalf("%s: %v", email, err)
+		}
+	}
+	if n := requests(); n != 0 {
+		t.Fatalf("expected no requests for unverified emails, got %d", n)
+	}
+
+	if err := s.RequestPriceAlert("a@example.com", 1, 9.99, "https://beautybargains.ie", now); err != nil {
+		t.Fatal(err)
+	}
+	var token string
+	if err := s.db.QueryRow(`SELECT token FROM price_alert_requests`).Scan(&token); err != nil {
+		t.Fatal(err)
+	}
+	if alerts, _ := s.getDueAlerts(); len(alerts) != 0 {
+		t.Fatalf("expected no alerts before confirming, got %+v", alerts)
+	}
+
+	if _, err := s.ConfirmPriceAlert(token, now.Add(priceAlertRequestTTL)); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected an expired link to be refused, got %v", err)
+	}
+	productID, err := s.ConfirmPriceAlert(token, now.Add(time.Hour))
+	if err != nil || productID != 1 {
+		t.Fatalf("expected product 1 to be confirmed, got %d %v", productID, err)
+	}
+	var target float64
+	if err := s.db.QueryRow(`SELECT target_price FROM price_alerts WHERE subscriber_id = 1 AND product_id = 1`).Scan(&target); err != nil || target != 9.99 {
+		t.Errorf("expected the alert's target to be 9.99, got %v %v", target, err)
+	}
+	if _, err := s.ConfirmPriceAlert(token, now.Add(time.Hour)); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected a used link to be refused, got %v", err)
+	}
+	if n := requests(); n != 0 {
+		t.Errorf("expected the request to be removed once confirmed, got %d", n)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+Retailers' "was" prices can't be trusted so price drops are worked out from our own history.
+Every crawl compares each offer's new price with its usual price, the median of what we saw
+over the previous 90 days, which a short price hike before a sale barely moves. A price that
+falls below the usual price by PRICE_DROP_THRESHOLD is recorded in price_drops and alerts the
+product's subscribers, see pricealerts.go. Drops of PRICE_DROP_POST_THRESHOLD or more are also
+posted to the feed.
+
+Offers tracked for at least 90 days get a "lowest price in 90 days" badge while they are at the
+lowest price seen in that time, or "lowest price ever" for the lowest of their whole history.
+*/
+
+type PriceDropConfig struct {
+	// Threshold is how far below its usual price, as a fraction, an offer has to fall to count as a drop
+	Threshold float64
+	// PostThreshold is the drop that's big enough for a feed post
+	PostThreshold float64
+}
+
+// priceWindow is how far back an offer's usual price and its lowest price badge look
+const priceWindow = 90 * 24 * time.Hour
+
+// priceDropHashtag is added to price drop posts so they can be browsed together
+const priceDropHashtag = "#pricedrop"
+
+type pricePoint struct {
+	Price      float64
+	ObservedAt time.Time
+}
+
+// priceChange is what an offer's latest price says about its history
+type priceChange struct {
+	// Previous is the price seen before the latest, 0 the first time an offer is seen
+	Previous float64
+	// Reference is the usual price, the median of the window
+	Reference float64
+	Price     float64
+	// Drop is how far below Reference Price is, 0.25 for 25% cheaper
+	Drop           float64
+	LowestIn90Days bool
+	AllTimeLow     bool
+}
+
+// comparePrice compares latest with history, the earlier observations of an offer oldest first
+func comparePrice(history []pricePoint, latest pricePoint) priceChange {
+	c := priceChange{Price: latest.Price}
+	if len(history) == 0 {
+		return c
+	}
+	c.Previous = history[len(history)-1].Price
+
+	windowStart := latest.ObservedAt.Add(-priceWindow)
+	// whether the history reaches back to the start of the window, badges need the whole window
+	tracked := false
+	var window, all []float64
+	for i, p := range history {
+		all = append(all, p.Price)
+		switch {
+		case p.ObservedAt.After(windowStart):
+			window = append(window, p.Price)
+		case i == len(history)-1 || history[i+1].ObservedAt.After(windowStart):
+			// the price in effect when the window starts
+			window = append(window, p.Price)
+			tracked = true
+		}
+	}
+
+	c.Reference = median(window)
+	if c.Reference > 0 {
+		c.Drop = (c.Reference - c.Price) / c.Reference
+	}
+	c.LowestIn90Days = tracked && isLowest(c.Price, window)
+	c.AllTimeLow = tracked && isLowest(c.Price, all)
+	return c
+}
+
+// isDrop reports whether the price has just fallen at least threshold below the usual price
+func (c priceChange) isDrop(threshold float64) bool {
+	return c.Previous > 0 && c.Price < c.Previous && c.Drop >= threshold
+}
+
+// isLowest reports whether price is as low as any of prices, which must have varied, a price
+// that never changes isn't a deal
+func isLowest(price float64, prices []float64) bool {
+	if len(prices) == 0 {
+		return false
+	}
+	lowest, highest := prices[0], prices[0]
+	for _, p := range prices[1:] {
+		lowest = math.Min(lowest, p)
+		highest = math.Max(highest, p)
+	}
+	return price <= lowest && price < highest
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// formatPrice writes price in currency, e.g. €12.95
+func formatPrice(price float64, currency string) string {
+	if currency == "" || currency == "EUR" {
+		return fmt.Sprintf("€%.2f", price)
+	}
+	return fmt.Sprintf("%.2f %s", price, currency)
+}
+
+// offerTitle names an offer, adding the variant to the product name when it isn't already in it
+func offerTitle(product, offer string) string {
+	switch {
+	case offer == "" || strings.Contains(product, offer):
+		return product
+	case product == "" || strings.Contains(offer, product):
+		return offer
+	}
+	return product + " " + offer
+}
+
+// priceDropDescription is the text of a price drop post
+func priceDropDescription(title, website, currency string, c priceChange) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s is down %.0f%% at %s, now %s instead of the usual %s.",
+		title, c.Drop*100, website, formatPrice(c.Price, currency), formatPrice(c.Reference, currency))
+	if c.AllTimeLow {
+		b.WriteString(" That's the lowest price we've ever seen.")
+	} else if c.LowestIn90Days {
+		b.WriteString(" That's the lowest price in 90 days.")
+	}
+	b.WriteString(" " + priceDropHashtag)
+	return b.String()
+}
+
+// checkPrices compares the prices just recorded for the offers of product with their history,
+// updates their badges and records drops. The biggest drop is posted when it's big enough.
+func (s *Service) checkPrices(tx *sql.Tx, product Product, scraped scrapedProduct, offerIDs []int, now time.Time) error {
+	best := -1
+	var bestChange priceChange
+	var bestDropID int64
+
+	for i, offerID := range offerIDs {
+		history, err := getPriceHistory(tx, offerID, now)
+		if err != nil {
+			return err
+		}
+		c := comparePrice(history, pricePoint{Price: scraped.Offers[i].Price, ObservedAt: now})
+
+		if _, err := tx.Exec(`UPDATE product_offers SET lowest_90_days = ?, all_time_low = ? WHERE id = ?`,
+			c.LowestIn90Days, c.AllTimeLow, offerID,
+		); err != nil {
+			return fmt.Errorf("could not update the badges of offer %d: %w", offerID, err)
+		}
+
+		if !c.isDrop(s.priceDrops.Threshold) {
+			continue
+		}
+		res, err := tx.Exec(`
+		INSERT INTO
+			price_drops (offer_id, previous_price, reference_price, price, lowest_90_days, all_time_low, detected_at)
+		VALUES
+			(?, ?, ?, ?, ?, ?, ?)`,
+			offerID, c.Previous, c.Reference, c.Price, c.LowestIn90Days, c.AllTimeLow, now.UTC(),
+		)
+		if err != nil {
+			return fmt.Errorf("could not save the price drop of offer %d: %w", offerID, err)
+		}
+		if best == -1 || c.Drop > bestChange.Drop {
+			best, bestChange = i, c
+			if bestDropID, err = res.LastInsertId(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if best == -1 || bestChange.Drop < s.priceDrops.PostThreshold {
+		return nil
+	}
+	offer := scraped.Offers[best]
+	image := offer.Image
+	if image == "" {
+		image = scraped.Image
+	}
+	if image == "" {
+		// every post shows an image
+		return nil
+	}
+	website, err := getWebsiteByID(product.WebsiteID)
+	if err != nil {
+		return err
+	}
+
+	status := postApproved
+	if s.moderate {
+		status = postPending
+	}
+	description := priceDropDescription(offerTitle(scraped.Name, offer.Name), website.WebsiteName, offer.Currency, bestChange)
+	percentOff := math.Round(bestChange.Drop * 100)
+	res, err := tx.Exec(`
+	INSERT INTO
+		posts (
+			website_id,
+			src_url,
+			link,
+			author_id,
+			description,
+			timestamp,
+			status,
+			percent_off,
+			product_id,
+			lowest_90_days,
+			all_time_low
+		)
+	VALUES
+		(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		product.WebsiteID,
+		image,
+		offer.URL,
+		getRandomPersona().ID,
+		description,
+		now,
+		status,
+		percentOff,
+		product.ID,
+		bestChange.LowestIn90Days,
+		bestChange.AllTimeLow,
+	)
+	if err != nil {
+		return fmt.Errorf("could not post the price drop of product %d: %w", product.ID, err)
+	}
+	postID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE price_drops SET post_id = ? WHERE id = ?`, postID, bestDropID); err != nil {
+		return fmt.Errorf("could not link price drop %d to post %d: %w", bestDropID, postID, err)
+	}
+	return nil
+}
+
+// getPriceHistory returns the prices of an offer observed before now, oldest first
+func getPriceHistory(tx *sql.Tx, offerID int, now time.Time) ([]pricePoint, error) {
+	rows, err := tx.Query(`
+	SELECT
+		price,
+		observed_at
+	FROM
+		price_points
+	WHERE
+		offer_id = ?
+		AND observed_at < ?
+	ORDER BY
+		observed_at`,
+		offerID, now.UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not get the price history of offer %d: %w", offerID, err)
+	}
+	defer rows.Close()
+
+	var history []pricePoint
+	for rows.Next() {
+		var p pricePoint
+		if err := rows.Scan(&p.Price, &p.ObservedAt); err != nil {
+			return nil, err
+		}
+		history = append(history, p)
+	}
+	return history, rows.Err()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComparePrice(t *testing.T) {
+	start := time.Date(2026, 1, 1, 6, 0, 0, 0, time.UTC)
+	day := func(n int) time.Time { return start.AddDate(0, 0, n) }
+
+	// a single hike before the sale doesn't move the usual price
+	history := []pricePoint{{20, day(0)}, {20, day(30)}, {24, day(60)}, {20, day(61)}, {20, day(95)}}
+	c := comparePrice(history, pricePoint{15, day(100)})
+	if c.Previous != 20 || c.Reference != 20 || c.Drop != 0.25 {
+		t.Errorf("unexpected change %+v", c)
+	}
+	if !c.isDrop(0.1) || c.isDrop(0.3) {
+		t.Errorf("expected a 25%% drop, got %v", c.Drop)
+	}
+	if !c.LowestIn90Days || !c.AllTimeLow {
+		t.Errorf("expected both badges, got %+v", c)
+	}
+
+	// cheaper once before the window, so only the 90 day badge
+	history = []pricePoint{{10, day(0)}, {20, day(5)}, {20, day(100)}}
+	c = comparePrice(history, pricePoint{15, day(110)})
+	if !c.LowestIn90Days || c.AllTimeLow {
+		t.Errorf("expected only the 90 day badge, got %+v", c)
+	}
+
+	// not tracked for 90 days yet, a drop but no badges
+	history = []pricePoint{{20, day(0)}, {20, day(10)}}
+	c = comparePrice(history, pricePoint{12, day(20)})
+	if !c.isDrop(0.1) || c.LowestIn90Days || c.AllTimeLow {
+		t.Errorf("expected a drop without badges, got %+v", c)
+	}
+
+	// a price that never changed isn't a low
+	history = []pricePoint{{20, day(0)}, {20, day(100)}}
+	if c = comparePrice(history, pricePoint{20, day(120)}); c.LowestIn90Days || c.isDrop(0) {
+		t.Errorf("expected no change, got %+v", c)
+	}
+
+	// staying at the sale price is a low but not a new drop
+	history = []pricePoint{{20, day(0)}, {20, day(95)}, {15, day(100)}}
+	if c = comparePrice(history, pricePoint{15, day(101)}); c.isDrop(0.1) || !c.LowestIn90Days {
+		t.Errorf("expected a low without a drop, got %+v", c)
+	}
+
+	if c = comparePrice(nil, pricePoint{15, day(0)}); c.isDrop(0) || c.LowestIn90Days {
+		t.Errorf("expected nothing for a new offer, got %+v", c)
+	}
+}
+
+func TestMedian(t *testing.T) {
+	cases := []struct {
+		values []float64
+		want   float64
+	}{
+		{nil, 0},
+		{[]float64{3}, 3},
+		{[]float64{5, 1, 3}, 3},
+		{[]float64{4, 1, 3, 2}, 2.5},
+	}
+	for _, c := range cases {
+		if got := median(c.values); got != c.want {
+			t.Errorf("median(%v) = %v, want %v", c.values, got, c.want)
+		}
+	}
+}
+
+func TestPriceDropDescription(t *testing.T) {
+	c := priceChange{Previous: 20, Reference: 20, Price: 15, Drop: 0.25, LowestIn90Days: true}
+	got := priceDropDescription(offerTitle("Hydrating Serum", "30ml"), "Look Fantastic", "EUR", c)
+	want := "Hydrating Serum 30ml is down 25% at Look Fantastic, now €15.00 instead of the usual €20.00. That's the lowest price in 90 days. #pricedrop"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPriceAlertEmail(t *testing.T) {
+	alerts := []dueAlert{
+		{Token: "abc", Email: "a@b.ie", Product: "Hydrating Serum", OfferID: 3, Offer: "30ml", Price: 9.5, Currency: "EUR", AllTimeLow: true},
+		{Token: "def", Email: "a@b.ie", Product: "Cleanser", OfferID: 4, Price: 5, Currency: "EUR"},
+	}
+	subject, body := priceAlertEmail(alerts, "https://beautybargains.ie")
+	if subject != "Price drops on 2 products you're watching" {
+		t.Errorf("unexpected subject %q", subject)
+	}
+	for _, want := range []string{
+		"Hydrating Serum 30ml: now €9.50",
+		"the lowest price ever",
+		"https://beautybargains.ie/go/offer/4",
+		"https://beautybargains.ie/alerts/remove?token=def",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in\n%s", want, body)
+		}
+	}
+	if subject, _ = priceAlertEmail(alerts[:1], ""); subject != "Price drop on Hydrating Serum 30ml" {
+		t.Errorf("unexpected subject %q", subject)
+	}
+}
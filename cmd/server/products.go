@@ -0,0 +1,722 @@
+package main
+
+import (
+	"beautybargains/internal/fetch"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+/*
+Besides homepage banners we track the prices of individual products. An admin adds a retailer's
+product page at /admin/products and the scrape_products job crawls it, reading the schema.org
+Product (or ProductGroup) JSON-LD most shops publish for search engines:
+
+	{"@type": "Product", "name": "...", "brand": {"name": "..."},
+	 "offers": [{"@type": "Offer", "sku": "...", "gtin13": "...", "price": "12.95", ...}]}
+
+Each variant is a product_offers row and every crawl adds a price_points row per offer, so a
+price history builds up. Pages are crawled again once they're older than
+SCRAPER_PRODUCT_INTERVAL.
+*/
+
+// productBatch caps how many product pages one run of scrapeProducts crawls
+const productBatch = 50
+
+var errNoProduct = errors.New("no schema.org Product with a price was found")
+
+type Product struct {
+	ID          int
+	WebsiteID   int
+	BrandID     sql.NullInt64
+	Name        string
+	Description string
+	Image       string
+	URL         string
+	LastCrawled sql.NullTime
+	LastError   sql.NullString
+	CreatedAt   time.Time
+}
+
+type ProductOffer struct {
+	ID        int
+	ProductID int
+	SKU       string
+	Name      string
+	// GTIN is the barcode padded to 14 digits, empty when the page doesn't give a valid one
+	GTIN         string
+	Image        string
+	URL          string
+	Price        float64
+	Currency     string
+	Availability string
+	LastSeen     time.Time
+}
+
+// DailyPrice is an offer's price on a day. Days without an observation carry the last price
+// forward and aren't Observed, Price is nil before the first observation.
+type DailyPrice struct {
+	Day      time.Time
+	Price    *float64
+	Observed bool
+}
+
+// scrapedProduct is a product as its page describes it
+type scrapedProduct struct {
+	Name        string
+	Description string
+	Image       string
+	Brand       string
+	Offers      []scrapedOffer
+}
+
+type scrapedOffer struct {
+	SKU          string
+	Name         string
+	GTIN         string
+	Image        string
+	URL          string
+	Price        float64
+	Currency     string
+	Availability string
+}
+
+// parseProductPage reads the product from the JSON-LD of the page at pageURL. A ProductGroup
+// is preferred over a Product as product pages often list related products too.
+func parseProductPage(doc *goquery.Document, pageURL string) (scrapedProduct, error) {
+	embedded := extractEmbeddedData(doc)
+
+	for _, group := range embedded.JSONLDOfType("ProductGroup") {
+		product := productFromLD(group)
+		for _, v := range ldList(group["hasVariant"]) {
+			if variant, ok := v.(map[string]any); ok {
+				product.Offers = append(product.Offers, offersFromLD(variant, pageURL)...)
+			}
+		}
+		if len(product.Offers) > 0 {
+			product.Offers = uniqueOffers(product.Offers)
+			return product, nil
+		}
+	}
+	for _, obj := range embedded.JSONLDOfType("Product") {
+		product := productFromLD(obj)
+		product.Offers = uniqueOffers(offersFromLD(obj, pageURL))
+		if len(product.Offers) > 0 {
+			return product, nil
+		}
+	}
+	return scrapedProduct{}, errNoProduct
+}
+
+func productFromLD(obj map[string]any) scrapedProduct {
+	return scrapedProduct{
+		Name:        strings.TrimSpace(ldText(obj["name"])),
+		Description: strings.TrimSpace(ldText(obj["description"])),
+		Image:       ldImage(obj["image"]),
+		Brand:       strings.TrimSpace(ldText(obj["brand"])),
+	}
+}
+
+// offersFromLD returns the priced offers of product with anything an offer leaves out, like
+// its sku or barcode, taken from the product
+func offersFromLD(product map[string]any, pageURL string) []scrapedOffer {
+	defaults := scrapedOffer{
+		SKU:   ldText(product["sku"]),
+		Name:  strings.TrimSpace(ldText(product["name"])),
+		GTIN:  ldGTIN(product),
+		Image: ldImage(product["image"]),
+		URL:   pageURL,
+	}
+
+	var out []scrapedOffer
+	offers := ldList(product["offers"])
+	for i := 0; i < len(offers); i++ {
+		obj, ok := offers[i].(map[string]any)
+		if !ok {
+			continue
+		}
+		// an AggregateOffer wraps the actual offers, when it lists them
+		if nested := ldList(obj["offers"]); len(nested) > 0 {
+			offers = append(offers, nested...)
+			continue
+		}
+
+		offer := defaults
+		if v := ldText(obj["sku"]); v != "" {
+			offer.SKU = v
+		}
+		if v := strings.TrimSpace(ldText(obj["name"])); v != "" {
+			offer.Name = v
+		}
+		if v := ldGTIN(obj); v != "" {
+			offer.GTIN = v
+		}
+		if v := ldImage(obj["image"]); v != "" {
+			offer.Image = v
+		}
+		if v := ldText(obj["url"]); v != "" {
+			offer.URL = resolveURL(pageURL, v)
+		}
+		offer.Price = ldPrice(obj["price"])
+		if offer.Price == 0 {
+			offer.Price = ldPrice(obj["lowPrice"])
+		}
+		if offer.Price <= 0 {
+			continue
+		}
+		offer.Currency = strings.ToUpper(ldText(obj["priceCurrency"]))
+		if offer.Currency == "" {
+			offer.Currency = "EUR"
+		}
+		offer.Availability = ldText(obj["availability"])
+		if slash := strings.LastIndex(offer.Availability, "/"); slash >= 0 {
+			// https://schema.org/InStock
+			offer.Availability = offer.Availability[slash+1:]
+		}
+		// offers are told apart by sku, failing that by barcode or link
+		if offer.SKU == "" {
+			offer.SKU = offer.GTIN
+		}
+		if offer.SKU == "" {
+			offer.SKU = offer.URL
+		}
+		out = append(out, offer)
+	}
+	return out
+}
+
+// uniqueOffers drops repeats of an sku, shops sometimes list a variant under more than one url
+func uniqueOffers(offers []scrapedOffer) []scrapedOffer {
+	seen := map[string]bool{}
+	out := offers[:0]
+	for _, offer := range offers {
+		if !seen[offer.SKU] {
+			seen[offer.SKU] = true
+			out = append(out, offer)
+		}
+	}
+	return out
+}
+
+// ldList returns v as a list, JSON-LD allows a single value wherever a list is expected
+func ldList(v any) []any {
+	switch t := v.(type) {
+	case nil:
+		return nil
+	case []any:
+		return t
+	}
+	return []any{v}
+}
+
+// ldText returns v as text. Objects like a Brand are represented by their name.
+func ldText(v any) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case map[string]any:
+		return ldText(t["name"])
+	case []any:
+		if len(t) > 0 {
+			return ldText(t[0])
+		}
+	}
+	return ""
+}
+
+func ldImage(v any) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case map[string]any:
+		if s := ldText(t["url"]); s != "" {
+			return s
+		}
+		return ldText(t["contentUrl"])
+	case []any:
+		if len(t) > 0 {
+			return ldImage(t[0])
+		}
+	}
+	return ""
+}
+
+func ldPrice(v any) float64 {
+	switch t := v.(type) {
+	case float64:
+		return t
+	case string:
+		n, _ := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(t), ",", "."), 64)
+		return n
+	}
+	return 0
+}
+
+// ldGTIN returns the first valid barcode of obj
+func ldGTIN(obj map[string]any) string {
+	for _, key := range []string{"gtin14", "gtin13", "gtin12", "gtin8", "gtin"} {
+		if gtin := normaliseGTIN(ldText(obj[key])); gtin != "" {
+			return gtin
+		}
+	}
+	return ""
+}
+
+// normaliseGTIN pads an EAN, UPC or GTIN to 14 digits so the same barcode matches whichever
+// way a retailer writes it. Anything without a valid check digit is dropped.
+func normaliseGTIN(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	digits := b.String()
+	switch len(digits) {
+	case 8, 12, 13, 14:
+	default:
+		return ""
+	}
+	digits = strings.Repeat("0", 14-len(digits)) + digits
+
+	sum := 0
+	for i, r := range digits[:13] {
+		n := int(r - '0')
+		if i%2 == 0 {
+			n *= 3
+		}
+		sum += n
+	}
+	if check := (10 - sum%10) % 10; check != int(digits[13]-'0') {
+		return ""
+	}
+	if strings.Trim(digits, "0") == "" {
+		return ""
+	}
+	return digits
+}
+
+// resolveURL resolves ref, which may be relative, against base
+func resolveURL(base, ref string) string {
+	b, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return b.ResolveReference(r).String()
+}
+
+// sameSite reports whether pageURL is an http(s) url on the same host as siteURL, ignoring www.
+func sameSite(siteURL, pageURL string) bool {
+	site, err := url.Parse(siteURL)
+	if err != nil {
+		return false
+	}
+	page, err := url.Parse(pageURL)
+	if err != nil || (page.Scheme != "https" && page.Scheme != "http") {
+		return false
+	}
+	return strings.TrimPrefix(site.Hostname(), "www.") == strings.TrimPrefix(page.Hostname(), "www.")
+}
+
+// AddProduct starts tracking the product page at rawURL, adding a page twice does nothing
+func (s *Service) AddProduct(websiteID int, rawURL string) error {
+	if _, err := s.db.Exec(`
+	INSERT INTO
+		products (website_id, url, created_at)
+	VALUES
+		(?, ?, ?)
+	ON CONFLICT(url) DO NOTHING`,
+		websiteID, rawURL, time.Now().UTC(),
+	); err != nil {
+		return fmt.Errorf("could not add product %s: %w", rawURL, err)
+	}
+	return nil
+}
+
+// scrapeProducts crawls the product pages that are due. A page that can't be read is recorded
+// against its product and tried again after the usual interval.
+func scrapeProducts(ctx context.Context, service *Service, interval time.Duration) error {
+	now := time.Now()
+	products, err := service.getProductsDue(now.Add(-interval), productBatch)
+	if err != nil {
+		return err
+	}
+	if len(products) == 0 {
+		return nil
+	}
+	brands, err := loadNameResolver(service.db, "brand", "brands")
+	if err != nil {
+		return err
+	}
+
+	for _, product := range products {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := service.crawlProduct(ctx, product, brands, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getProductsDue returns products never crawled or last crawled before cutoff, oldest first
+func (s *Service) getProductsDue(cutoff time.Time, limit int) ([]Product, error) {
+	rows, err := s.db.Query(`
+	SELECT
+		id,
+		website_id,
+		brand_id,
+		name,
+		description,
+		image,
+		url,
+		last_crawled,
+		last_error,
+		created_at
+	FROM
+		products
+	WHERE
+		last_crawled IS NULL
+		OR last_crawled < ?
+	ORDER BY
+		last_crawled IS NOT NULL,
+		last_crawled
+	LIMIT ?`,
+		cutoff.UTC(), limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not get products to crawl: %w", err)
+	}
+	defer rows.Close()
+
+	products := make([]Product, 0, limit)
+	for rows.Next() {
+		var p Product
+		if err := rows.Scan(
+			&p.ID,
+			&p.WebsiteID,
+			&p.BrandID,
+			&p.Name,
+			&p.Description,
+			&p.Image,
+			&p.URL,
+			&p.LastCrawled,
+			&p.LastError,
+			&p.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("could not scan product: %w", err)
+		}
+		products = append(products, p)
+	}
+	return products, rows.Err()
+}
+
+// crawlProduct records the current prices of product. Only database errors are returned, a page
+// that can't be fetched or has no product data is logged and saved as the product's last error.
+func (s *Service) crawlProduct(ctx context.Context, product Product, brands nameResolver, now time.Time) error {
+	scraped, err := fetchProduct(ctx, product.URL)
+	// a fetch cut short by shutdown says nothing about the page
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		log.Printf("could not crawl product %d: %v", product.ID, err)
+		if _, dbErr := s.db.Exec(`UPDATE products SET last_crawled = ?, last_error = ? WHERE id = ?`,
+			now.UTC(), err.Error(), product.ID,
+		); dbErr != nil {
+			return fmt.Errorf("could not save the crawl error of product %d: %w", product.ID, dbErr)
+		}
+		return nil
+	}
+
+	brand := ""
+	if scraped.Brand != "" {
+		brand, _ = brands.resolve(scraped.Brand)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	offerIDs, err := saveScrapedProduct(tx, product.ID, scraped, brand, now)
+	if err != nil {
+		return err
+	}
+	// a zero threshold means price drops aren't configured
+	if s.priceDrops.Threshold > 0 {
+		if err := s.checkPrices(tx, product, scraped, offerIDs, now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func fetchProduct(ctx context.Context, pageURL string) (scrapedProduct, error) {
+	res, err := fetch.Get(ctx, pageURL)
+	if err != nil {
+		return scrapedProduct{}, err
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(res.Body))
+	if err != nil {
+		return scrapedProduct{}, fmt.Errorf("could not parse %s: %w", pageURL, err)
+	}
+	return parseProductPage(doc, pageURL)
+}
+
+// saveScrapedProduct updates a product from its page and records a price point for each offer.
+// It returns the ids of the offers in the order of scraped.Offers.
+func saveScrapedProduct(tx *sql.Tx, productID int, scraped scrapedProduct, brand string, now time.Time) ([]int, error) {
+	var brandID sql.NullInt64
+	if brand != "" {
+		id, err := getOrCreateBrand(tx, brand)
+		if err != nil {
+			return nil, err
+		}
+		brandID = sql.NullInt64{Int64: int64(id), Valid: true}
+	}
+
+	if _, err := tx.Exec(`
+	UPDATE
+		products
+	SET
+		brand_id = ?,
+		name = ?,
+		description = ?,
+		image = ?,
+		last_crawled = ?,
+		last_error = NULL
+	WHERE
+		id = ?`,
+		brandID, scraped.Name, scraped.Description, scraped.Image, now.UTC(), productID,
+	); err != nil {
+		return nil, fmt.Errorf("could not update product %d: %w", productID, err)
+	}
+
+	offerIDs := make([]int, len(scraped.Offers))
+	for i, offer := range scraped.Offers {
+		var offerID int
+		if err := tx.QueryRow(`
+		INSERT INTO
+			product_offers (product_id, sku, name, gtin, image, url, price, currency, availability, last_seen)
+		VALUES
+			(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(product_id, sku) DO UPDATE SET
+			name = excluded.name,
+			gtin = excluded.gtin,
+			image = excluded.image,
+			url = excluded.url,
+			price = excluded.price,
+			currency = excluded.currency,
+			availability = excluded.availability,
+			last_seen = excluded.last_seen
+		RETURNING id`,
+			productID, offer.SKU, offer.Name, sql.NullString{String: offer.GTIN, Valid: offer.GTIN != ""},
+			offer.Image, offer.URL, offer.Price, offer.Currency, offer.Availability, now.UTC(),
+		).Scan(&offerID); err != nil {
+			return nil, fmt.Errorf("could not save offer %s of product %d: %w", offer.SKU, productID, err)
+		}
+
+		if _, err := tx.Exec(`
+		INSERT INTO
+			price_points (offer_id, price, currency, availability, observed_at)
+		VALUES
+			(?, ?, ?, ?, ?)`,
+			offerID, offer.Price, offer.Currency, offer.Availability, now.UTC(),
+		); err != nil {
+			return nil, fmt.Errorf("could not save the price of offer %d: %w", offerID, err)
+		}
+		offerIDs[i] = offerID
+	}
+	return offerIDs, nil
+}
+
+// GetDailyPrices returns the price of an offer on every day from from to to, both included.
+// A day's price is the last one observed on or before it, so the series has no gaps.
+func (s *Service) GetDailyPrices(offerID int, from, to time.Time) ([]DailyPrice, error) {
+	rows, err := s.db.Query(`
+	WITH RECURSIVE days(day) AS (
+		SELECT
+			date(?)
+		UNION ALL
+		SELECT
+			date(day, '+1 day')
+		FROM
+			days
+		WHERE
+			day < date(?)
+	)
+	SELECT
+		day,
+		(
+			SELECT
+				price
+			FROM
+				price_points
+			WHERE
+				offer_id = ?
+				AND date(observed_at) <= day
+			ORDER BY
+				observed_at DESC
+			LIMIT
+				1
+		),
+		EXISTS (
+			SELECT
+				1
+			FROM
+				price_points
+			WHERE
+				offer_id = ?
+				AND date(observed_at) = day
+		)
+	FROM
+		days`,
+		from.UTC().Format(time.DateOnly), to.UTC().Format(time.DateOnly), offerID, offerID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not get daily prices of offer %d: %w", offerID, err)
+	}
+	defer rows.Close()
+
+	var prices []DailyPrice
+	for rows.Next() {
+		var day string
+		var price sql.NullFloat64
+		var p DailyPrice
+		if err := rows.Scan(&day, &price, &p.Observed); err != nil {
+			return nil, err
+		}
+		if p.Day, err = time.Parse(time.DateOnly, day); err != nil {
+			return nil, err
+		}
+		if price.Valid {
+			p.Price = &price.Float64
+		}
+		prices = append(prices, p)
+	}
+	return prices, rows.Err()
+}
+
+// ProductSummary is a tracked product as listed at /admin/products
+type ProductSummary struct {
+	Product
+	Website Website
+	Brand   string
+	Offers  int
+	// LowestPrice is the cheapest offer as of the last crawl
+	LowestPrice sql.NullFloat64
+}
+
+func (s *Service) GetProductSummaries(limit, offset int) ([]ProductSummary, error) {
+	rows, err := s.db.Query(`
+	SELECT
+		p.id,
+		p.website_id,
+		p.name,
+		p.url,
+		p.last_crawled,
+		p.last_error,
+		p.created_at,
+		COALESCE(b.name, ''),
+		COUNT(o.id),
+		MIN(o.price)
+	FROM
+		products p
+		LEFT JOIN brands b ON b.id = p.brand_id
+		LEFT JOIN product_offers o ON o.product_id = p.id
+	GROUP BY
+		p.id
+	ORDER BY
+		p.created_at DESC
+	LIMIT ? OFFSET ?`,
+		limit, offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not get products: %w", err)
+	}
+	defer rows.Close()
+
+	summaries := make([]ProductSummary, 0, limit)
+	for rows.Next() {
+		var p ProductSummary
+		if err := rows.Scan(
+			&p.ID,
+			&p.WebsiteID,
+			&p.Name,
+			&p.URL,
+			&p.LastCrawled,
+			&p.LastError,
+			&p.CreatedAt,
+			&p.Brand,
+			&p.Offers,
+			&p.LowestPrice,
+		); err != nil {
+			return nil, fmt.Errorf("could not scan product: %w", err)
+		}
+		p.Website, _ = getWebsiteByID(p.WebsiteID)
+		summaries = append(summaries, p)
+	}
+	return summaries, rows.Err()
+}
+
+// GetProductSummary returns the tracked product with id, sql.ErrNoRows when there isn't one
+func (s *Service) GetProductSummary(id int) (ProductSummary, error) {
+	var p ProductSummary
+	err := s.db.QueryRow(`
+	SELECT
+		p.id,
+		p.website_id,
+		p.name,
+		p.image,
+		p.url,
+		p.last_crawled,
+		p.last_error,
+		p.created_at,
+		COALESCE(b.name, ''),
+		COUNT(o.id),
+		MIN(o.price)
+	FROM
+		products p
+		LEFT JOIN brands b ON b.id = p.brand_id
+		LEFT JOIN product_offers o ON o.product_id = p.id
+	WHERE
+		p.id = ?
+	GROUP BY
+		p.id`,
+		id,
+	).Scan(
+		&p.ID,
+		&p.WebsiteID,
+		&p.Name,
+		&p.Image,
+		&p.URL,
+		&p.LastCrawled,
+		&p.LastError,
+		&p.CreatedAt,
+		&p.Brand,
+		&p.Offers,
+		&p.LowestPrice,
+	)
+	if err != nil {
+		return ProductSummary{}, fmt.Errorf("could not get product %d: %w", id, err)
+	}
+	p.Website, _ = getWebsiteByID(p.WebsiteID)
+	return p, nil
+}
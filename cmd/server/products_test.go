@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const productPage = `<html><head>
+<script type="application/ld+json">{"@context":"https://schema.org","@type":"Product","name":"Hydrating Serum",
+"brand":{"@type":"Brand","name":"The Ordinary"},"image":["https://shop.ie/serum.jpg"],"sku":"SER",
+"offers":[
+ {"@type":"Offer","sku":"SER-30","name":"30ml","price":"12.95","priceCurrency":"eur","availability":"https://schema.org/InStock","gtin13":5060150185380,"url":"/serum?size=30"},
+ {"@type":"Offer","sku":"SER-60","name":"60ml","price":19.5,"availability":"https://schema.org/OutOfStock"},
+ {"@type":"Offer","sku":"SER-60","price":"19.50"},
+ {"@type":"Offer","sku":"SER-SAMPLE","price":"0"}
+]}</script>
+<script type="application/ld+json">{"@type":"Product","name":"Related Cleanser","offers":{"@type":"Offer","price":"9.99"}}</script>
+</head></html>`
+
+func TestParseProductPage(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(productPage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	product, err := parseProductPage(doc, "https://shop.ie/serum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if product.Name != "Hydrating Serum" || product.Brand != "The Ordinary" || product.Image != "https://shop.ie/serum.jpg" {
+		t.Errorf("unexpected product %+v", product)
+	}
+	if len(product.Offers) != 2 {
+		t.Fatalf("expected the repeated and free offers to be dropped, got %+v", product.Offers)
+	}
+
+	small := product.Offers[0]
+	if small.SKU != "SER-30" || small.Price != 12.95 || small.Currency != "EUR" || small.Availability != "InStock" {
+		t.Errorf("unexpected offer %+v", small)
+	}
+	if small.GTIN != "05060150185380" {
+		t.Errorf("expected the numeric gtin13 to be padded to 14 digits, got %q", small.GTIN)
+	}
+	if small.URL != "https://shop.ie/serum?size=30" {
+		t.Errorf("expected the offer url to be resolved against the page, got %q", small.URL)
+	}
+
+	large := product.Offers[1]
+	if large.Name != "60ml" || large.Price != 19.5 || large.URL != "https://shop.ie/serum" || large.Image != "https://shop.ie/serum.jpg" {
+		t.Errorf("expected the offer to fall back to the product, got %+v", large)
+	}
+
+	doc, _ = goquery.NewDocumentFromReader(strings.NewReader(`<html><head><script type="application/ld+json">{"@type":"WebPage"}</script></head></html>`))
+	if _, err := parseProductPage(doc, "https://shop.ie/"); err != errNoProduct {
+		t.Errorf("expected errNoProduct, got %v", err)
+	}
+}
+
+func TestNormaliseGTIN(t *testing.T) {
+	cases := map[string]string{
+		"5060150185380":    "05060150185380",
+		"05060150185380":   "05060150185380",
+		"5060-1501-8538-0": "05060150185380",
+		"036000291452":     "00036000291452",
+		"96385074":         "00000096385074",
+		"5060150185381":    "",
+		"12345":            "",
+		"00000000000000":   "",
+	}
+	for in, want := range cases {
+		if got := normaliseGTIN(in); got != want {
+			t.Errorf("normaliseGTIN(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+A post's banner is live while it is still on the retailer's homepage. Every scrape marks the
+posts whose banner, or a duplicate of it, is still there as active and bumps last_seen; the rest
+are marked ended. first_seen to last_seen is then how long the promotion ran.
+*/
+
+// recordBannerPresence marks which of website's posts are still showing. A scrape that finds no
+// banners is ignored, that's more likely a broken selector than every promotion ending at once.
+func recordBannerPresence(db *sql.DB, website Website, banners []BannerData, now time.Time) error {
+	srcs := make([]any, 0, len(banners))
+	for _, b := range banners {
+		if b.Src != "" {
+			srcs = append(srcs, b.Src)
+		}
+	}
+	if len(srcs) == 0 {
+		return nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(srcs)), ",")
+	present := fmt.Sprintf(`(
+		src_url IN (%s)
+		OR id IN (SELECT post_id FROM banner_duplicates WHERE src_url IN (%s))
+	)`, placeholders, placeholders)
+	presentArgs := append(append([]any{}, srcs...), srcs...)
+
+	// args follow the placeholders: active, last_seen, then the where clause
+	var args []any
+	args = append(args, presentArgs...)
+	args = append(args, presentArgs...)
+	args = append(args, now, website.WebsiteID)
+	args = append(args, presentArgs...)
+
+	if _, err := db.Exec(`
+	UPDATE
+		posts
+	SET
+		active = CASE WHEN `+present+` THEN 1 ELSE 0 END,
+		last_seen = CASE WHEN `+present+` THEN ? ELSE last_seen END
+	WHERE
+		website_id = ?
+		AND (active = 1 OR `+present+`)`,
+		args...,
+	); err != nil {
+		return fmt.Errorf("could not record banners still showing on %s: %w", website.WebsiteName, err)
+	}
+	return nil
+}
+
+// PromoStats summarises how long a retailer's promotions run for. Durations only count
+// promotions that have ended.
+type PromoStats struct {
+	Website     Website
+	Tracked     int
+	Live        int
+	Ended       int
+	AvgDuration time.Duration
+	MinDuration time.Duration
+	MaxDuration time.Duration
+}
+
+type promoSpan struct {
+	websiteID int
+	active    bool
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// GetPromoStats returns duration stats per website, or for one website when websiteID isn't 0
+func (s *Service) GetPromoStats(websiteID int) ([]PromoStats, error) {
+	q := `
+	SELECT
+		website_id,
+		active,
+		first_seen,
+		last_seen
+	FROM
+		posts
+	WHERE
+		first_seen IS NOT NULL
+		AND last_seen IS NOT NULL`
+	args := []any{}
+	if websiteID != 0 {
+		q += ` AND website_id = ?`
+		args = append(args, websiteID)
+	}
+
+	rows, err := s.db.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query promotion spans: %w", err)
+	}
+	defer rows.Close()
+
+	var spans []promoSpan
+	for rows.Next() {
+		var span promoSpan
+		if err := rows.Scan(&span.websiteID, &span.active, &span.firstSeen, &span.lastSeen); err != nil {
+			return nil, err
+		}
+		spans = append(spans, span)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return summarisePromoSpans(spans, getWebsites(0, 0)), nil
+}
+
+// summarisePromoSpans aggregates spans per website in the order websites are listed, websites
+// without any tracked promotions are left out
+func summarisePromoSpans(spans []promoSpan, websites []Website) []PromoStats {
+	byWebsite := map[int]*PromoStats{}
+	totals := map[int]time.Duration{}
+	for _, span := range spans {
+		stats, ok := byWebsite[span.websiteID]
+		if !ok {
+			stats = &PromoStats{}
+			byWebsite[span.websiteID] = stats
+		}
+		stats.Tracked++
+		if span.active {
+			stats.Live++
+			continue
+		}
+		d := span.lastSeen.Sub(span.firstSeen)
+		if stats.Ended == 0 || d < stats.MinDuration {
+			stats.MinDuration = d
+		}
+		if d > stats.MaxDuration {
+			stats.MaxDuration = d
+		}
+		stats.Ended++
+		totals[span.websiteID] += d
+	}
+
+	out := make([]PromoStats, 0, len(byWebsite))
+	for _, website := range websites {
+		stats, ok := byWebsite[website.WebsiteID]
+		if !ok {
+			continue
+		}
+		stats.Website = website
+		if stats.Ended > 0 {
+			stats.AvgDuration = totals[website.WebsiteID] / time.Duration(stats.Ended)
+		}
+		out = append(out, *stats)
+	}
+	return out
+}
+
+// humanDuration rounds d to whole days, or hours when it is under a day
+func humanDuration(d time.Duration) string {
+	return localDuration(defaultLocale, d)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSummarisePromoSpans(t *testing.T) {
+	start := time.Date(2024, 11, 1, 9, 0, 0, 0, time.UTC)
+	websites := []Website{{WebsiteID: 1, WebsiteName: "A"}, {WebsiteID: 2, WebsiteName: "B"}, {WebsiteID: 3, WebsiteName: "C"}}
+	spans := []promoSpan{
+		{websiteID: 2, firstSeen: start, lastSeen: start.Add(48 * time.Hour)},
+		{websiteID: 2, firstSeen: start, lastSeen: start.Add(96 * time.Hour)},
+		{websiteID: 2, active: true, firstSeen: start, lastSeen: start.Add(500 * time.Hour)},
+		{websiteID: 1, active: true, firstSeen: start, lastSeen: start},
+	}
+
+	stats := summarisePromoSpans(spans, websites)
+	if len(stats) != 2 {
+		t.Fatalf("expected stats for 2 websites got %d", len(stats))
+	}
+	if stats[0].Website.WebsiteID != 1 || stats[1].Website.WebsiteID != 2 {
+		t.Fatalf("expected stats in website order got %+v", stats)
+	}
+	if stats[0].Live != 1 || stats[0].Ended != 0 || stats[0].AvgDuration != 0 {
+		t.Fatalf("unexpected stats for A: %+v", stats[0])
+	}
+
+	b := stats[1]
+	if b.Tracked != 3 || b.Live != 1 || b.Ended != 2 {
+		t.Fatalf("unexpected counts for B: %+v", b)
+	}
+	// the live promotion is still running so it doesn't count towards durations
+	if b.AvgDuration != 72*time.Hour || b.MinDuration != 48*time.Hour || b.MaxDuration != 96*time.Hour {
+		t.Fatalf("unexpected durations for B: %+v", b)
+	}
+}
+
+func TestHumanDuration(t *testing.T) {
+	cases := map[time.Duration]string{
+		0:              "0 hours",
+		time.Hour:      "1 hour",
+		5 * time.Hour:  "5 hours",
+		30 * time.Hour: "1 day",
+		72 * time.Hour: "3 days",
+	}
+	for d, want := range cases {
+		if got := humanDuration(d); got != want {
+			t.Errorf("humanDuration(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
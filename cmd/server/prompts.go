@@ -0,0 +1,399 @@
+package main
+
+import (
+	"beautybargains/internal/chat"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+/*
+Prompts are versioned per job in the prompts table so they can change without a deploy. One
+version per job is active and every post records the version and model that wrote it. A new
+version can be tried against stored banners at /admin/prompts before it is activated.
+
+Templates are text/template executed with promptData.
+*/
+
+const offerPromptJob = "extract_offers"
+
+// maxPromptSample caps how many banners a comparison sends to the llm
+const maxPromptSample = 10
+
+type Prompt struct {
+	ID       int
+	Job      string
+	Version  int
+	Template string
+	Schema   string
+	// Model overrides the configured model when set
+	Model     sql.NullString
+	Notes     string
+	Active    bool
+	CreatedAt time.Time
+	// Posts is how many posts this version wrote, only set by GetPrompts
+	Posts int
+}
+
+type promptData struct {
+	Website        string
+	SupportingText string
+	// Language and Text are what translate_post prompts translate into and from
+	Language string
+	Text     string
+}
+
+// render executes the template for a banner on website
+func (p Prompt) render(website string, banner BannerData) (string, error) {
+	return p.execute(promptData{Website: website, SupportingText: banner.SupportingText})
+}
+
+func (p Prompt) execute(data promptData) (string, error) {
+	tmpl, err := template.New(fmt.Sprintf("%s v%d", p.Job, p.Version)).Parse(p.Template)
+	if err != nil {
+		return "", fmt.Errorf("could not parse prompt %s v%d: %w", p.Job, p.Version, err)
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("could not render prompt %s v%d: %w", p.Job, p.Version, err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// validate catches templates that won't render and schemas that aren't json before they are saved
+func (p Prompt) validate() error {
+	if strings.TrimSpace(p.Template) == "" {
+		return errors.New("the prompt template is empty")
+	}
+	if _, err := p.execute(promptData{
+		Website:        "Example Retailer",
+		SupportingText: "Up to 30% off skincare",
+		Language:       "Irish",
+		Text:           "Up to 30% off skincare at Example Retailer #skincare",
+	}); err != nil {
+		return err
+	}
+	var schema map[string]any
+	if err := json.Unmarshal([]byte(p.Schema), &schema); err != nil {
+		return fmt.Errorf("the response schema is not a json object: %w", err)
+	}
+	return nil
+}
+
+// model is the model the prompt runs on
+func (p Prompt) model() string {
+	if p.Model.Valid && p.Model.String != "" {
+		return p.Model.String
+	}
+	return chat.Model()
+}
+
+const promptColumns = `id, job, version, template, schema, model, notes, active, created_at`
+
+func scanPrompt(row scannable) (Prompt, error) {
+	var p Prompt
+	err := row.Scan(&p.ID, &p.Job, &p.Version, &p.Template, &p.Schema, &p.Model, &p.Notes, &p.Active, &p.CreatedAt)
+	return p, err
+}
+
+// getActivePrompt returns the version of job's prompt in use
+func getActivePrompt(db *sql.DB, job string) (Prompt, error) {
+	p, err := scanPrompt(db.QueryRow(`SELECT `+promptColumns+` FROM prompts WHERE job = ? AND active = 1`, job))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Prompt{}, fmt.Errorf("there is no active prompt for %s", job)
+	}
+	if err != nil {
+		return Prompt{}, fmt.Errorf("could not get the active prompt for %s: %w", job, err)
+	}
+	return p, nil
+}
+
+func (s *Service) GetPrompt(id int) (Prompt, error) {
+	p, err := scanPrompt(s.db.QueryRow(`SELECT `+promptColumns+` FROM prompts WHERE id = ?`, id))
+	if err != nil {
+		return Prompt{}, fmt.Errorf("could not get prompt %d: %w", id, err)
+	}
+	return p, nil
+}
+
+// GetPrompts lists every version of job's prompt, newest first
+func (s *Service) GetPrompts(job string) ([]Prompt, error) {
+	rows, err := s.db.Query(`
+	SELECT
+		`+promptColumns+`,
+		CASE WHEN job = ? THEN (SELECT COUNT(id) FROM posts WHERE prompt_version = prompts.version) ELSE 0 END
+	FROM
+		prompts
+	WHERE
+		job = ?
+	ORDER BY
+		version DESC`,
+		offerPromptJob, job,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not list prompts for %s: %w", job, err)
+	}
+	defer rows.Close()
+
+	var prompts []Prompt
+	for rows.Next() {
+		var p Prompt
+		if err := rows.Scan(&p.ID, &p.Job, &p.Version, &p.Template, &p.Schema, &p.Model, &p.Notes, &p.Active, &p.CreatedAt, &p.Posts); err != nil {
+			return nil, err
+		}
+		prompts = append(prompts, p)
+	}
+	return prompts, rows.Err()
+}
+
+// CreatePrompt saves p as the next version of its job. It isn't used until it is activated.
+func (s *Service) CreatePrompt(p Prompt) (Prompt, error) {
+	if err := p.validate(); err != nil {
+		return Prompt{}, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Prompt{}, err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) + 1 FROM prompts WHERE job = ?`, p.Job).Scan(&p.Version); err != nil {
+		return Prompt{}, fmt.Errorf("could not get the next version of %s: %w", p.Job, err)
+	}
+	p.CreatedAt = time.Now()
+	res, err := tx.Exec(`
+	INSERT INTO
+		prompts (job, version, template, schema, model, notes, created_at)
+	VALUES
+		(?, ?, ?, ?, ?, ?, ?)`,
+		p.Job, p.Version, p.Template, p.Schema, p.Model, p.Notes, p.CreatedAt,
+	)
+	if err != nil {
+		return Prompt{}, fmt.Errorf("could not save %s v%d: %w", p.Job, p.Version, err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Prompt{}, err
+	}
+	p.ID = int(id)
+
+	return p, tx.Commit()
+}
+
+// ActivatePrompt makes prompt id the version used for its job
+func (s *Service) ActivatePrompt(id int) error {
+	if _, err := s.db.Exec(`
+	UPDATE
+		prompts
+	SET
+		active = (id = ?)
+	WHERE
+		job = (SELECT job FROM prompts WHERE id = ?)`,
+		id, id,
+	); err != nil {
+		return fmt.Errorf("could not activate prompt %d: %w", id, err)
+	}
+	return nil
+}
+
+// promptComparison is a stored post next to what a candidate prompt made of the same banner
+type promptComparison struct {
+	Post       Post
+	Brands     []string
+	Categories []string
+	Candidate  *OfferDescriptionResponse
+	Error      string
+	// Pending comparisons haven't been sent to the llm yet
+	Pending bool
+}
+
+// StartPromptComparison queues candidate against a random sample of stored banners and runs it
+// in the background, every banner is an llm call which doesn't fit in a request. It reports
+// false when candidate is already being compared. Nothing is saved to posts, the calls are
+// recorded under their own job so they don't count towards extract_offers.
+func (s *Service) StartPromptComparison(candidate Prompt, sample int) (bool, error) {
+	if _, running := s.promptRuns.LoadOrStore(candidate.ID, true); running {
+		return false, nil
+	}
+	if err := s.queuePromptComparison(candidate.ID, sample, time.Now()); err != nil {
+		s.promptRuns.Delete(candidate.ID)
+		return false, err
+	}
+	go func() {
+		defer s.promptRuns.Delete(candidate.ID)
+		// the comparison outlives the request that started it
+		if err := s.runPromptComparison(context.Background(), candidate); err != nil {
+			s.ReportErr(fmt.Errorf("could not compare prompt %d: %w", candidate.ID, err))
+		}
+	}()
+	return true, nil
+}
+
+// PromptComparisonRunning reports whether prompt id is being compared right now
+func (s *Service) PromptComparisonRunning(id int) bool {
+	_, running := s.promptRuns.Load(id)
+	return running
+}
+
+// queuePromptComparison replaces the prompt's last comparison with a new sample of banners
+func (s *Service) queuePromptComparison(promptID, sample int, now time.Time) error {
+	if sample < 1 || sample > maxPromptSample {
+		sample = maxPromptSample
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM prompt_comparisons WHERE prompt_id = ?`, promptID); err != nil {
+		return fmt.Errorf("could not clear the comparisons of prompt %d: %w", promptID, err)
+	}
+	if _, err := tx.Exec(`
+	INSERT INTO
+		prompt_comparisons (prompt_id, post_id, created_at)
+	SELECT
+		?,
+		id,
+		?
+	FROM
+		posts
+	WHERE
+		status = ?
+	ORDER BY
+		RANDOM()
+	LIMIT ?`,
+		promptID, now.UTC(), postApproved, sample,
+	); err != nil {
+		return fmt.Errorf("could not sample banners: %w", err)
+	}
+	return tx.Commit()
+}
+
+// runPromptComparison sends the prompt's pending banners to the llm one at a time
+func (s *Service) runPromptComparison(ctx context.Context, candidate Prompt) error {
+	rows, err := s.db.Query(`SELECT id, post_id FROM prompt_comparisons WHERE prompt_id = ? AND finished_at IS NULL ORDER BY id`, candidate.ID)
+	if err != nil {
+		return fmt.Errorf("could not get pending comparisons: %w", err)
+	}
+	var pending [][2]int
+	for rows.Next() {
+		var ids [2]int
+		if err := rows.Scan(&ids[0], &ids[1]); err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, ids)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, ids := range pending {
+		var answer, failure sql.NullString
+		candidateAnswer, err := s.comparePost(ctx, candidate, ids[1])
+		if err != nil {
+			failure = sql.NullString{String: err.Error(), Valid: true}
+		} else {
+			b, err := json.Marshal(candidateAnswer)
+			if err != nil {
+				return err
+			}
+			answer = sql.NullString{String: string(b), Valid: true}
+		}
+		if _, err := s.db.Exec(`UPDATE prompt_comparisons SET answer = ?, error = ?, finished_at = ? WHERE id = ?`,
+			answer, failure, time.Now().UTC(), ids[0],
+		); err != nil {
+			return fmt.Errorf("could not save comparison %d: %w", ids[0], err)
+		}
+	}
+	return nil
+}
+
+// comparePost runs candidate on the banner of post postID, sending the archived copy of the
+// banner as the retailer may have taken the original down
+func (s *Service) comparePost(ctx context.Context, candidate Prompt, postID int) (*OfferDescriptionResponse, error) {
+	posts, err := s.getPosts(getPostParams{IDs: []int{postID}, AnyStatus: true})
+	if err != nil {
+		return nil, err
+	}
+	if len(posts) == 0 {
+		return nil, fmt.Errorf("post %d no longer exists", postID)
+	}
+	post := posts[0]
+	website, err := getWebsiteByID(post.WebsiteID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get website by id %d: %w", post.WebsiteID, err)
+	}
+	banner := BannerData{Src: post.SrcURL, SupportingText: post.SupportingText.String}
+	if stored, ok := storedImageFromPost(post); ok && s.media != nil {
+		data, err := s.media.Read(stored)
+		if err != nil {
+			return nil, err
+		}
+		banner.Src = dataURL(stored, data)
+	}
+	return runOfferPrompt(ctx, candidate, "compare_prompts", website.WebsiteName, banner, post.ImageHash.String)
+}
+
+// GetPromptComparisons returns the last comparison of candidate, finished or not
+func (s *Service) GetPromptComparisons(candidate Prompt) ([]promptComparison, error) {
+	id := candidate.ID
+	rows, err := s.db.Query(`SELECT post_id, answer, error, finished_at IS NULL FROM prompt_comparisons WHERE prompt_id = ? ORDER BY id`, id)
+	if err != nil {
+		return nil, fmt.Errorf("could not get the comparisons of prompt %d: %w", id, err)
+	}
+	type row struct {
+		postID        int
+		answer, error sql.NullString
+		pending       bool
+	}
+	var found []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.postID, &r.answer, &r.error, &r.pending); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		found = append(found, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	comparisons := make([]promptComparison, 0, len(found))
+	for _, r := range found {
+		posts, err := s.getPosts(getPostParams{IDs: []int{r.postID}, AnyStatus: true})
+		if err != nil {
+			return nil, err
+		}
+		if len(posts) == 0 {
+			continue
+		}
+		c := promptComparison{Post: posts[0], Error: r.error.String, Pending: r.pending}
+		if c.Brands, err = s.getPostNames(r.postID, "post_brands", "brands", "brand_id"); err != nil {
+			return nil, err
+		}
+		if c.Categories, err = s.getPostNames(r.postID, "post_categories", "categories", "category_id"); err != nil {
+			return nil, err
+		}
+		if r.answer.Valid {
+			c.Candidate = new(OfferDescriptionResponse)
+			if err := json.Unmarshal([]byte(r.answer.String), c.Candidate); err != nil {
+				return nil, fmt.Errorf("could not read the answer for post %d: %w", r.postID, err)
+			}
+			c.Candidate.Model = candidate.model()
+		}
+		comparisons = append(comparisons, c)
+	}
+	return comparisons, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPromptRender(t *testing.T) {
+	p := Prompt{Job: offerPromptJob, Version: 3, Template: `What is {{.Website}} advertising?
+{{- if .SupportingText}}
+The banner says '{{.SupportingText}}'
+{{- end}}`}
+
+	got, err := p.render("Boots", BannerData{SupportingText: "3 for 2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "What is Boots advertising?\nThe banner says '3 for 2'" {
+		t.Errorf("unexpected prompt %q", got)
+	}
+
+	got, err = p.render("Boots", BannerData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "What is Boots advertising?" {
+		t.Errorf("unexpected prompt without supporting text %q", got)
+	}
+}
+
+func TestPromptValidate(t *testing.T) {
+	cases := map[string]struct {
+		prompt Prompt
+		err    string
+	}{
+		"valid":        {Prompt{Template: "Hi {{.Website}}", Schema: `{"type": "object"}`}, ""},
+		"empty":        {Prompt{Template: " ", Schema: `{}`}, "empty"},
+		"unknown":      {Prompt{Template: "{{.Retailer}}", Schema: `{}`}, "can't evaluate field Retailer"},
+		"unparsable":   {Prompt{Template: "{{if}}", Schema: `{}`}, "could not parse"},
+		"schema":       {Prompt{Template: "Hi", Schema: `not json`}, "not a json object"},
+		"schema array": {Prompt{Template: "Hi", Schema: `[]`}, "not a json object"},
+	}
+	for name, c := range cases {
+		err := c.prompt.validate()
+		if c.err == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+		if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%s: expected error containing %q got %v", name, c.err, err)
+		}
+	}
+}
+
+func TestPromptComparisonQueue(t *testing.T) {
+	s := newTestService(t)
+	now := time.Date(2026, 5, 10, 12, 0, 0, 0, time.UTC)
+	for id, status := range map[int]string{1: postApproved, 2: postApproved, 3: postApproved, 4: postPending} {
+		mustExec(t, s.db, `INSERT INTO posts (id, website_id, src_url, author_id, description, timestamp, status) VALUES (?, 1, 'https://shop.ie/banner.jpg', 1, 'deal', ?, ?)`, id, now, status)
+	}
+	candidate := Prompt{ID: 7, Job: offerPromptJob, Model: sql.NullString{String: "gpt-test", Valid: true}}
+
+	if err := s.queuePromptComparison(candidate.ID, 2, now); err != nil {
+		t.Fatal(err)
+	}
+	comparisons, err := s.GetPromptComparisons(candidate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comparisons) != 2 {
+		t.Fatalf("expected 2 queued banners, got %d", len(comparisons))
+	}
+	for _, c := range comparisons {
+		if !c.Pending || c.Post.Status != postApproved {
+			t.Errorf("expected a pending approved post, got %+v", c)
+		}
+	}
+
+	// the background run fills rows in
+	mustExec(t, s.db, `UPDATE prompt_comparisons SET answer = '{"description": "20% off"}', finished_at = ? WHERE id = (SELECT MIN(id) FROM prompt_comparisons)`, now)
+	mustExec(t, s.db, `UPDATE prompt_comparisons SET error = 'no answer', finished_at = ? WHERE id = (SELECT MAX(id) FROM prompt_comparisons)`, now)
+	comparisons, err = s.GetPromptComparisons(candidate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := comparisons[0]; c.Pending || c.Candidate == nil || c.Candidate.Description != "20% off" || c.Candidate.Model != "gpt-test" {
+		t.Errorf("expected the candidate's answer, got %+v", c)
+	}
+	if c := comparisons[1]; c.Pending || c.Candidate != nil || c.Error != "no answer" {
+		t.Errorf("expected the candidate's error, got %+v", c)
+	}
+
+	// comparing again replaces the last run
+	if err := s.queuePromptComparison(candidate.ID, 0, now); err != nil {
+		t.Fatal(err)
+	}
+	if comparisons, _ := s.GetPromptComparisons(candidate); len(comparisons) != 3 || !comparisons[0].Pending {
+		t.Errorf("expected a new run over all 3 approved posts, got %+v", comparisons)
+	}
+}
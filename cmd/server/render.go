@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"net/http"
 )
 
 type Renderer struct {
@@ -11,9 +12,16 @@ type Renderer struct {
 	tmpl *Tmpl
 }
 
-func (r *Renderer) Page(w io.Writer, name string, data map[string]any) error {
+func (r *Renderer) Page(w io.Writer, req *http.Request, name string, data map[string]any) error {
+	locale, localised := requestLocale(req)
 	templateData := map[string]any{
-		"Env": r.mode,
+		"Env":          r.mode,
+		"CSRFToken":    csrfToken(req),
+		"Locale":       locale.Code,
+		"LocalePrefix": locale.Prefix,
+	}
+	if localised {
+		templateData["Alternates"] = alternates(locale, req.URL.Path)
 	}
 
 	for k, v := range data {
@@ -23,6 +31,19 @@ func (r *Renderer) Page(w io.Writer, name string, data map[string]any) error {
 	return r.Template(w, name, templateData)
 }
 
+// Partial renders a fragment for htmx with the request's locale added to data
+func (r *Renderer) Partial(w io.Writer, req *http.Request, name string, data map[string]any) error {
+	locale, _ := requestLocale(req)
+	templateData := map[string]any{
+		"Locale":       locale.Code,
+		"LocalePrefix": locale.Prefix,
+	}
+	for k, v := range data {
+		templateData[k] = v
+	}
+	return r.Template(w, name, templateData)
+}
+
 func (r *Renderer) Template(w io.Writer, name string, data any) error {
 	var buf bytes.Buffer
 	if err := r.tmpl.Get().ExecuteTemplate(&buf, name, data); err != nil {
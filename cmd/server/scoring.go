@@ -0,0 +1,290 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+/*
+A post's score ranks it in the feed. It's the sum of these signals, each between 0 and 1,
+multiplied by the weights admins set at /admin/scoring:
+
+	retailer    the website's score out of 10
+	brand       the best score out of 10 of the post's brands
+	discount    how big the deal is, 70% or €50 off is as big as it gets and buy one get one free is half way
+	recency     halves every SCORE_HALF_LIFE
+	engagement  outbound clicks, 20 clicks is half way
+	coupon      1 when the post has a coupon code
+
+Posts are only scored when something changed. Clearing scored_at queues a post, which happens
+when it's saved, reviewed, clicked, its brands are rescored or the weights change. Recent posts
+are also rescored every scoreRefresh as their recency fades.
+*/
+
+const (
+	// fullPercentOff and fullAmountOff are discounts that get the whole discount signal
+	fullPercentOff = 70
+	fullAmountOff  = 50
+	clicksPivot    = 20
+	// scoreRefresh is how stale the recency part of a recent post's score may get
+	scoreRefresh = 6 * time.Hour
+	// recencyHalfLives is how long posts keep being rescored for, after that recency is under 1%
+	recencyHalfLives = 7
+	scoreBatch       = 500
+)
+
+type ScoreWeights struct {
+	Retailer   float64
+	Brand      float64
+	Discount   float64
+	Recency    float64
+	Engagement float64
+	Coupon     float64
+	UpdatedAt  sql.NullTime
+}
+
+// scoreSignals are the parts of a score before weighting
+type scoreSignals struct {
+	Retailer   float64
+	Brand      float64
+	Discount   float64
+	Recency    float64
+	Engagement float64
+	Coupon     float64
+}
+
+func (w ScoreWeights) score(s scoreSignals) float64 {
+	return w.Retailer*s.Retailer +
+		w.Brand*s.Brand +
+		w.Discount*s.Discount +
+		w.Recency*s.Recency +
+		w.Engagement*s.Engagement +
+		w.Coupon*s.Coupon
+}
+
+// scoreInput is what a post is scored on
+type scoreInput struct {
+	PostID      int
+	WebsiteID   int
+	Description string
+	Timestamp   sql.NullTime
+	Clicks      int
+	PercentOff  sql.NullFloat64
+	AmountOff   sql.NullFloat64
+	BOGOF       bool
+	// BrandScore is the best score of the post's brands
+	BrandScore float64
+	HasCoupon  bool
+	Score      float64
+}
+
+func (in scoreInput) signals(halfLife time.Duration, now time.Time) scoreSignals {
+	var s scoreSignals
+	if website, err := getWebsiteByID(in.WebsiteID); err == nil {
+		s.Retailer = clamp01(website.Score / 10)
+	}
+	s.Brand = clamp01(in.BrandScore / 10)
+
+	if in.PercentOff.Valid {
+		s.Discount = math.Max(s.Discount, in.PercentOff.Float64/fullPercentOff)
+	}
+	if in.AmountOff.Valid {
+		s.Discount = math.Max(s.Discount, in.AmountOff.Float64/fullAmountOff)
+	}
+	if in.BOGOF {
+		s.Discount = math.Max(s.Discount, 0.5)
+	}
+	s.Discount = clamp01(s.Discount)
+
+	if in.Timestamp.Valid && halfLife > 0 {
+		age := max(now.Sub(in.Timestamp.Time), 0)
+		s.Recency = math.Pow(0.5, float64(age)/float64(halfLife))
+	}
+	s.Engagement = float64(in.Clicks) / float64(in.Clicks+clicksPivot)
+	if in.HasCoupon {
+		s.Coupon = 1
+	}
+	return s
+}
+
+func clamp01(v float64) float64 {
+	return math.Min(math.Max(v, 0), 1)
+}
+
+func (s *Service) GetScoreWeights() (ScoreWeights, error) {
+	var w ScoreWeights
+	err := s.db.QueryRow(`
+	SELECT
+		retailer,
+		brand,
+		discount,
+		recency,
+		engagement,
+		coupon,
+		updated_at
+	FROM
+		score_weights
+	WHERE
+		id = 1`,
+	).Scan(&w.Retailer, &w.Brand, &w.Discount, &w.Recency, &w.Engagement, &w.Coupon, &w.UpdatedAt)
+	if err != nil {
+		return ScoreWeights{}, fmt.Errorf("could not get score weights: %w", err)
+	}
+	return w, nil
+}
+
+// SaveScoreWeights stores new weights and queues every post to be scored with them
+func (s *Service) SaveScoreWeights(w ScoreWeights) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+	UPDATE
+		score_weights
+	SET
+		retailer = ?,
+		brand = ?,
+		discount = ?,
+		recency = ?,
+		engagement = ?,
+		coupon = ?,
+		updated_at = ?
+	WHERE
+		id = 1`,
+		w.Retailer, w.Brand, w.Discount, w.Recency, w.Engagement, w.Coupon, time.Now().UTC(),
+	); err != nil {
+		return fmt.Errorf("could not save score weights: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE posts SET scored_at = NULL`); err != nil {
+		return fmt.Errorf("could not queue posts for scoring: %w", err)
+	}
+	return tx.Commit()
+}
+
+const scoreInputQuery = `
+	SELECT
+		p.id,
+		p.website_id,
+		COALESCE(p.description, ''),
+		p.timestamp,
+		p.clicks,
+		p.percent_off,
+		p.amount_off,
+		p.bogof,
+		COALESCE((
+			SELECT
+				MAX(b.score)
+			FROM
+				post_brands pb
+				JOIN brands b ON b.id = pb.brand_id
+			WHERE
+				pb.post_id = p.id
+		), 0),
+		EXISTS (SELECT 1 FROM coupon_codes c WHERE c.post_id = p.id),
+		p.score
+	FROM
+		posts p`
+
+func (s *Service) queryScoreInputs(where string, args ...any) ([]scoreInput, error) {
+	rows, err := s.db.Query(scoreInputQuery+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not get posts to score: %w", err)
+	}
+	defer rows.Close()
+
+	var inputs []scoreInput
+	for rows.Next() {
+		var in scoreInput
+		if err := rows.Scan(
+			&in.PostID,
+			&in.WebsiteID,
+			&in.Description,
+			&in.Timestamp,
+			&in.Clicks,
+			&in.PercentOff,
+			&in.AmountOff,
+			&in.BOGOF,
+			&in.BrandScore,
+			&in.HasCoupon,
+			&in.Score,
+		); err != nil {
+			return nil, fmt.Errorf("could not scan post to score: %w", err)
+		}
+		inputs = append(inputs, in)
+	}
+	return inputs, rows.Err()
+}
+
+// getPostsToScore returns posts queued for scoring and recent posts whose recency is stale
+func (s *Service) getPostsToScore(halfLife time.Duration, now time.Time, limit int) ([]scoreInput, error) {
+	return s.queryScoreInputs(`
+	WHERE
+		p.scored_at IS NULL
+		OR (p.timestamp > ? AND p.scored_at < ?)
+	ORDER BY
+		p.id
+	LIMIT ?`,
+		now.Add(-recencyHalfLives*halfLife).UTC(), now.Add(-scoreRefresh).UTC(), limit,
+	)
+}
+
+// GetTopScoredPosts returns the approved posts with the highest scores for /admin/scoring
+func (s *Service) GetTopScoredPosts(limit int) ([]scoreInput, error) {
+	return s.queryScoreInputs(`
+	WHERE
+		p.status = ?
+	ORDER BY
+		p.score DESC
+	LIMIT ?`,
+		postApproved, limit,
+	)
+}
+
+// scorePosts scores the posts that need it in batches
+func scorePosts(service *Service) error {
+	halfLife := service.scoreHalfLife
+	weights, err := service.GetScoreWeights()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	scored := 0
+	for {
+		inputs, err := service.getPostsToScore(halfLife, now, scoreBatch)
+		if err != nil {
+			return err
+		}
+		if err := service.saveScores(inputs, weights, halfLife, now); err != nil {
+			return err
+		}
+		scored += len(inputs)
+		if len(inputs) < scoreBatch {
+			break
+		}
+	}
+	if scored > 0 {
+		log.Printf("scored %d posts", scored)
+	}
+	return nil
+}
+
+func (s *Service) saveScores(inputs []scoreInput, weights ScoreWeights, halfLife time.Duration, now time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, in := range inputs {
+		score := weights.score(in.signals(halfLife, now))
+		if _, err := tx.Exec(`UPDATE posts SET score = ?, scored_at = ? WHERE id = ?`, score, now.UTC(), in.PostID); err != nil {
+			return fmt.Errorf("error updating score for post %d: %w", in.PostID, err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestScoreSignals(t *testing.T) {
+	now := time.Date(2026, 5, 10, 12, 0, 0, 0, time.UTC)
+	week := 7 * 24 * time.Hour
+	in := scoreInput{
+		WebsiteID:  1, // BeautyFeatures scores 10
+		Timestamp:  sql.NullTime{Time: now.Add(-week), Valid: true},
+		Clicks:     20,
+		PercentOff: sql.NullFloat64{Float64: 35, Valid: true},
+		BrandScore: 5,
+		HasCoupon:  true,
+	}
+	got := in.signals(week, now)
+	want := scoreSignals{Retailer: 1, Brand: 0.5, Discount: 0.5, Recency: 0.5, Engagement: 0.5, Coupon: 1}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	weights := ScoreWeights{Retailer: 1, Brand: 2, Discount: 4, Recency: 1, Engagement: 0, Coupon: 0.5}
+	if score := weights.score(got); score != 5 {
+		t.Errorf("expected a weighted sum of 5, got %v", score)
+	}
+
+	// the biggest of the discounts counts, capped at 1
+	in = scoreInput{AmountOff: sql.NullFloat64{Float64: 100, Valid: true}, BOGOF: true}
+	if s := in.signals(week, now); s.Discount != 1 || s.Recency != 0 || s.Engagement != 0 {
+		t.Errorf("unexpected signals %+v", s)
+	}
+	in = scoreInput{BOGOF: true, PercentOff: sql.NullFloat64{Float64: 7, Valid: true}}
+	if s := in.signals(week, now); s.Discount != 0.5 {
+		t.Errorf("expected buy one get one free to count as half, got %v", s.Discount)
+	}
+
+	// posts dated in the future aren't fresher than new ones
+	in = scoreInput{Timestamp: sql.NullTime{Time: now.Add(time.Hour), Valid: true}}
+	if s := in.signals(week, now); s.Recency != 1 {
+		t.Errorf("expected full recency, got %v", s.Recency)
+	}
+	in = scoreInput{Timestamp: sql.NullTime{Time: now.Add(-recencyHalfLives * week), Valid: true}}
+	if s := in.signals(week, now); math.Abs(s.Recency-1.0/128) > 1e-9 {
+		t.Errorf("expected recency to have halved 7 times, got %v", s.Recency)
+	}
+}
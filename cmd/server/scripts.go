@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gosimple/slug"
@@ -91,62 +94,80 @@ func processHashtags(service *Service) error {
 	return nil
 }
 
-func extractUniqueBanners(service *Service, website Website) ([]BannerData, error) {
-	banners, err := extractWebsiteBannerURLs(website)
-	if err != nil {
-		return nil, fmt.Errorf("failed to extract banner URLs for website %s: %w", website.WebsiteName, err)
-	}
-
-	uniqueBanners := []BannerData{}
-	for _, banner := range banners {
-
-		var bannerCount int
-		if err := service.db.QueryRow(`
-		SELECT 
-			count(id) 
-		FROM 
-			posts 
-		WHERE 
-			src_url = ?`,
-			banner.Src,
-		).Scan(&bannerCount); err != nil {
-			return nil, fmt.Errorf(
-				"error checking existence of banner %s: %w",
-				banner.Src,
-				err,
-			)
-		}
-
-		bannerExists := bannerCount > 0
-
-		if bannerExists {
-			continue
-		}
-
-		uniqueBanners = append(uniqueBanners, banner)
+// bannerExists reports whether src has been posted. Banners already linked to a post as a
+// duplicate, or waiting in the analysis queue, count too.
+func bannerExists(db *sql.DB, src string) (bool, error) {
+	var bannerCount int
+	if err := db.QueryRow(`
+	SELECT 
+		(SELECT count(id) FROM posts WHERE src_url = ?) +
+		(SELECT count(id) FROM banner_duplicates WHERE src_url = ?) +
+		(SELECT count(id) FROM analysis_queue WHERE src_url = ?)`,
+		src,
+		src,
+		src,
+	).Scan(&bannerCount); err != nil {
+		return false, fmt.Errorf(
+			"error checking existence of banner %s: %w",
+			src,
+			err,
+		)
 	}
-
-	return uniqueBanners, nil
+	return bannerCount > 0, nil
 }
 
-func saveOfferDescriptionAsPost(tx *sql.Tx, website Website, banner BannerData, description string) (int, error) {
+func saveOfferDescriptionAsPost(tx *sql.Tx, website Website, banner BannerData, offer *OfferDescriptionResponse, status string) (int, error) {
 	// I picked 8 randomly for author id
+	now := time.Now()
 	res, err := tx.Exec(
 		`INSERT INTO 
 			posts (
 				website_id,
 				src_url,
+				link,
 				author_id,
 				description,
-				timestamp
+				timestamp,
+				first_seen,
+				last_seen,
+				active,
+				status,
+				supporting_text,
+				prompt_version,
+				model,
+				machine_extracted,
+				percent_off,
+				amount_off,
+				min_spend,
+				free_gift,
+				free_shipping,
+				bogof,
+				starts_at,
+				ends_at
 			) 
 		VALUES 
-			(? , ? , ?, ?, ?)`,
+			(? , ? , ?, ?, ?, ?, ?, ?, 1, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		website.WebsiteID,
 		banner.Src,
+		sql.NullString{String: banner.Href, Valid: banner.Href != ""},
 		getRandomPersona().ID,
-		description,
-		time.Now(),
+		offer.Description,
+		now,
+		now,
+		now,
+		status,
+		sql.NullString{String: banner.SupportingText, Valid: banner.SupportingText != ""},
+		sql.NullInt64{Int64: int64(offer.PromptVersion), Valid: offer.PromptVersion != 0},
+		sql.NullString{String: offer.Model, Valid: offer.Model != ""},
+		offer.MachineExtracted,
+		offer.Deal.PercentOff,
+		offer.Deal.AmountOff,
+		offer.Deal.MinSpend,
+		offer.Deal.FreeGift,
+		offer.Deal.FreeShipping,
+		offer.Deal.BOGOF,
+		offer.Deal.StartsAt,
+		offer.Deal.EndsAt,
 	)
 	if err != nil {
 		return -1, fmt.Errorf("error saving banner promotion for website %s: %w", website.WebsiteName, err)
@@ -160,57 +181,68 @@ func saveOfferDescriptionAsPost(tx *sql.Tx, website Website, banner BannerData,
 	return int(id), nil
 }
 
-func extractOffersFromBanners(service *Service) error {
+// extractOffersFromBanners scrapes every website using up to workers websites at a time so a
+// slow retailer doesn't hold up the rest. An error for one website doesn't stop the others.
+func extractOffersFromBanners(ctx context.Context, service *Service, workers int) error {
 	websites := getWebsites(0, 0)
-	for _, website := range websites {
-		banners, err := extractUniqueBanners(service, website)
-		if err != nil {
-			return fmt.Errorf("error extracting unique banners for website %s: %w", website.WebsiteName, err)
-		}
-		for _, banner := range banners {
-			if banner.Src == "" {
-				continue
-			}
-
-			offer, err := analyzeOffer(website.WebsiteName, banner)
-			if err != nil {
-				log.Printf("error getting offer description from chatgpt for website %s and banner %s: %v", website.WebsiteName, banner.Src, err)
-				continue
-			}
-
-			tx, err := service.db.Begin()
-			if err != nil {
-				return err
-			}
+	if workers < 1 {
+		workers = 1
+	}
 
-			postID, err := saveOfferDescriptionAsPost(tx, website, banner, offer.Description)
-			if err != nil {
-				tx.Rollback()
-				log.Printf("error saving offer description as post for website %s: %v", website.WebsiteName, err)
-				continue
+	jobs := make(chan Website)
+	errs := make(chan error, len(websites))
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for website := range jobs {
+				if err := extractOffersFromWebsite(ctx, service, website); err != nil {
+					errs <- err
+				}
 			}
+		}()
+	}
 
-			if err := savePostCategories(tx, postID, offer.Categories); err != nil {
-				tx.Rollback()
-				log.Printf("error saving post categories for post %d: %v", postID, err)
-				continue
-			}
+	// websites not started by shutdown are left for the next run
+feed:
+	for _, website := range websites {
+		select {
+		case jobs <- website:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
 
-			if err := savePostBrands(tx, postID, offer.Brands); err != nil {
-				tx.Rollback()
-				log.Printf("error saving post brands for post %d: %v", postID, err)
-				continue
-			}
+	var all []error
+	for err := range errs {
+		all = append(all, err)
+	}
+	return errors.Join(all...)
+}
 
-			if err := saveOfferCouponCodes(tx, website, offer.CouponCodes); err != nil {
-				tx.Rollback()
-				log.Printf("error saving offer coupon codes for website %s: %v", website.WebsiteName, err)
-				continue
-			}
+func extractOffersFromWebsite(ctx context.Context, service *Service, website Website) error {
+	results, err := ingestWebsite(ctx, service, website, ingestOptions{analyze: true, commit: true})
+	if err != nil {
+		return fmt.Errorf("error ingesting banners for website %s: %w", website.WebsiteName, err)
+	}
 
-			if err := tx.Commit(); err != nil {
-				return err
-			}
+	bannersSeen.Add(float64(len(results)), website.WebsiteName, "discovered")
+	for _, result := range results {
+		switch {
+		case result.Seen:
+			bannersSeen.Inc(website.WebsiteName, "skipped")
+		case result.DuplicateOf != 0:
+			bannersSeen.Inc(website.WebsiteName, "duplicate")
+		default:
+			bannersSeen.Inc(website.WebsiteName, "new")
+		}
+		if result.Error != "" {
+			log.Printf("error ingesting banner %s for website %s: %s", result.Banner.Src, website.WebsiteName, result.Error)
 		}
 	}
 	return nil
@@ -306,7 +338,8 @@ func savePostCategories(tx *sql.Tx, postID int, offerCategories []string) error
 	return nil
 }
 
-func savePostBrand(tx *sql.Tx, postID int, brandName string) error {
+// getOrCreateBrand returns the id of the brand called brandName, creating it if there isn't one
+func getOrCreateBrand(tx *sql.Tx, brandName string) (int, error) {
 	var count int
 	if err := tx.QueryRow(`
 		SELECT
@@ -317,7 +350,7 @@ func savePostBrand(tx *sql.Tx, postID int, brandName string) error {
 			name = ?`,
 		brandName,
 	).Scan(&count); err != nil {
-		return err
+		return 0, err
 	}
 	exists := count > 0
 	if !exists {
@@ -328,7 +361,7 @@ func savePostBrand(tx *sql.Tx, postID int, brandName string) error {
 			(?, ?, ?)`,
 			brandName, slug.Make(brandName), 0,
 		); err != nil {
-			return fmt.Errorf("could not create brand: %w", err)
+			return 0, fmt.Errorf("could not create brand: %w", err)
 		}
 
 	}
@@ -349,7 +382,15 @@ func savePostBrand(tx *sql.Tx, postID int, brandName string) error {
 		&brand.Path,
 		&brand.Score,
 	); err != nil {
-		return fmt.Errorf("failed to get brand by name: %w", err)
+		return 0, fmt.Errorf("failed to get brand by name: %w", err)
+	}
+	return brand.ID, nil
+}
+
+func savePostBrand(tx *sql.Tx, postID int, brandName string) error {
+	brandID, err := getOrCreateBrand(tx, brandName)
+	if err != nil {
+		return err
 	}
 
 	if _, err := tx.Exec(`
@@ -358,7 +399,7 @@ func savePostBrand(tx *sql.Tx, postID int, brandName string) error {
 	VALUES
 		(?, ?)`,
 		postID,
-		brand.ID,
+		brandID,
 	); err != nil {
 		return fmt.Errorf("failed to insert into post_brands: %w", err)
 	}
@@ -374,7 +415,7 @@ func savePostBrands(tx *sql.Tx, postID int, offerBrands []string) error {
 	return nil
 }
 
-func saveOfferCouponCodes(tx *sql.Tx, website Website, offerCouponCodes []CouponCode) error {
+func saveOfferCouponCodes(tx *sql.Tx, website Website, postID int, offerCouponCodes []CouponCode) error {
 	for _, coupon := range offerCouponCodes {
 		coupon.WebsiteID = website.WebsiteID
 		if coupon.WebsiteID == 0 {
@@ -387,15 +428,17 @@ func saveOfferCouponCodes(tx *sql.Tx, website Website, offerCouponCodes []Coupon
 				description,
 				valid_until,
 				first_seen,
-				website_id
+				website_id,
+				post_id
 			)
 		VALUES
-			(?, ?, ?, ?, ?)`,
+			(?, ?, ?, ?, ?, ?)`,
 			coupon.Code,
 			coupon.Description,
 			coupon.ValidUntil,
 			time.Now(),
 			coupon.WebsiteID,
+			postID,
 		); err != nil {
 			return err
 		}
@@ -403,32 +446,56 @@ func saveOfferCouponCodes(tx *sql.Tx, website Website, offerCouponCodes []Coupon
 	return nil
 }
 
-func scorePosts(service *Service) error {
-	posts, err := service.getPosts(getPostParams{})
+// saveOffer saves an analysed and normalised banner as a post with its image, categories, brands
+// and coupons and takes it off the analysis queue in one transaction. The post is held for
+// review when moderation is on or the offer needed too many fixes.
+func saveOffer(service *Service, website Website, banner BannerData, image StoredImage, offer *OfferDescriptionResponse, review offerReview) (int, error) {
+	tx, err := service.db.Begin()
 	if err != nil {
-		return fmt.Errorf("error getting posts: %w", err)
+		return -1, err
 	}
+	defer tx.Rollback()
 
-	for i := range posts {
-		w, err := getWebsiteByID(posts[i].WebsiteID)
-		if err != nil {
-			return fmt.Errorf("error getting website for post %d: %w", posts[i].ID, err)
+	status := postApproved
+	if service.moderate || review.NeedsReview() {
+		status = postPending
+	}
+
+	postID, err := saveOfferDescriptionAsPost(tx, website, banner, offer, status)
+	if err != nil {
+		return -1, err
+	}
+
+	if image.Hash != "" {
+		if err := savePostImage(tx, postID, image); err != nil {
+			return -1, err
 		}
+	}
 
-		if posts[i].Score != float64(w.Score) {
-			posts[i].Score = float64(w.Score)
-			if _, err := service.db.Exec(`
-			UPDATE 
-				posts 
-			SET score = ? 
-			WHERE id = ?`,
-				posts[i].Score,
-				posts[i].ID,
-			); err != nil {
-				return fmt.Errorf("error updating score for post %d: %w", posts[i].ID, err)
-			}
+	if review.NeedsReview() {
+		if err := savePostReviewReasons(tx, postID, review.Issues); err != nil {
+			return -1, err
 		}
 	}
 
-	return nil
+	if err := savePostCategories(tx, postID, offer.Categories); err != nil {
+		return -1, fmt.Errorf("error saving post categories for post %d: %w", postID, err)
+	}
+
+	if err := savePostBrands(tx, postID, offer.Brands); err != nil {
+		return -1, fmt.Errorf("error saving post brands for post %d: %w", postID, err)
+	}
+
+	if err := saveOfferCouponCodes(tx, website, postID, offer.CouponCodes); err != nil {
+		return -1, fmt.Errorf("error saving offer coupon codes for website %s: %w", website.WebsiteName, err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM analysis_queue WHERE src_url = ?`, banner.Src); err != nil {
+		return -1, fmt.Errorf("could not take banner %s off the analysis queue: %w", banner.Src, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return -1, err
+	}
+	return postID, nil
 }
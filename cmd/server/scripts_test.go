@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"testing"
@@ -38,7 +39,7 @@ func TestProcessHashtags(t *testing.T) {
 
 func TestExtractBannerURLs(t *testing.T) {
 	for i := range websites {
-		bannerData, err := extractWebsiteBannerURLs(websites[i])
+		bannerData, err := extractWebsiteBannerURLs(context.Background(), websites[i])
 		if err != nil {
 			t.Error(err)
 		}
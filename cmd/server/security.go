@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* security headers */
+
+// contentSecurityPolicy allows our own scripts (the htmx bundle) and stylesheet. Inline styles are
+// allowed because htmx injects its indicator styles and a few templates use style attributes.
+// Banners that were never archived are hotlinked from retailer CDNs so any https image source is allowed.
+func contentSecurityPolicy(mode Mode) string {
+	scriptSrc := "'self'"
+	if mode == Dev {
+		// tailwind play cdn is only loaded in dev
+		scriptSrc += " https://cdn.tailwindcss.com"
+	}
+	return strings.Join([]string{
+		"default-src 'self'",
+		"script-src " + scriptSrc,
+		"style-src 'self' 'unsafe-inline'",
+		"img-src 'self' https: data:",
+		"connect-src 'self'",
+		"font-src 'self'",
+		"object-src 'none'",
+		"base-uri 'self'",
+		"form-action 'self'",
+		"frame-ancestors 'none'",
+	}, "; ")
+}
+
+func (h *Handler) securityHeaders(next handleFunc) handleFunc {
+	csp := contentSecurityPolicy(h.mode)
+	return func(w http.ResponseWriter, r *http.Request) error {
+		header := w.Header()
+		header.Set("Content-Security-Policy", csp)
+		header.Set("X-Frame-Options", "DENY")
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		header.Set("Permissions-Policy", "camera=(), microphone=(), geolocation=()")
+		if h.mode == Prod {
+			header.Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+		}
+		return next(w, r)
+	}
+}
+
+/* csrf */
+
+const (
+	csrfCookieName = "csrf_token"
+	csrfFormField  = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+type csrfContextKey struct{}
+
+// csrfProtect implements the double submit cookie pattern. Every visitor gets a random token in a
+// cookie and the same token is rendered into pages, as a hidden form field for plain forms and as
+// an hx-headers attribute on the body for htmx requests. Unsafe requests must echo it back.
+func (h *Handler) csrfProtect(next handleFunc) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		token := ""
+		if c, err := r.Cookie(csrfCookieName); err == nil && isValidVerificationToken(c.Value) {
+			token = c.Value
+		} else {
+			b := make([]byte, 32)
+			if _, err := rand.Read(b); err != nil {
+				return err
+			}
+			token = hex.EncodeToString(b)
+			http.SetCookie(w, &http.Cookie{
+				Name:     csrfCookieName,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   h.mode == Prod,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			submitted := r.Header.Get(csrfHeaderName)
+			if submitted == "" {
+				submitted = r.PostFormValue(csrfFormField)
+			}
+			if subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) != 1 {
+				if h.mode == Dev {
+					log.Printf("Warning: rejected %s %s with missing or invalid csrf token", r.Method, r.URL.Path)
+				}
+				http.Error(w, "Invalid or missing CSRF token. Please refresh the page and try again.", http.StatusForbidden)
+				return nil
+			}
+		}
+
+		return next(w, r.WithContext(context.WithValue(r.Context(), csrfContextKey{}, token)))
+	}
+}
+
+// csrfToken returns the token to render into the page for r
+func csrfToken(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	token, _ := r.Context().Value(csrfContextKey{}).(string)
+	return token
+}
+
+/* rate limiting */
+
+// rateLimiter is a per client token bucket. Each client can make burst requests
+// at once which then refill at rate tokens per second.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter allows burst requests per client and refills one request every interval
+func newRateLimiter(interval time.Duration, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    1 / interval.Seconds(),
+		burst:   float64(burst),
+		now:     time.Now,
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+// allow takes a token for key. When none are left it reports how long until the next one.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep forgets clients whose buckets have completely refilled so the map doesn't grow forever
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for k, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// rateLimit rejects requests from clients that have exhausted their bucket in l
+func (h *Handler) rateLimit(l *rateLimiter) middleware {
+	return func(next handleFunc) handleFunc {
+		return func(w http.ResponseWriter, r *http.Request) error {
+			ok, wait := l.allow(h.clientIP(r))
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, "Too many requests. Please try again later.", http.StatusTooManyRequests)
+				return nil
+			}
+			return next(w, r)
+		}
+	}
+}
+
+// limitWrites applies l to every request that isn't a read so new write endpoints are covered by default
+func (h *Handler) limitWrites(l *rateLimiter) middleware {
+	limited := h.rateLimit(l)
+	return func(next handleFunc) handleFunc {
+		limitedNext := limited(next)
+		return func(w http.ResponseWriter, r *http.Request) error {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return next(w, r)
+			}
+			return limitedNext(w, r)
+		}
+	}
+}
+
+// clientIP is the address of the remote client. Forwarding headers are only trusted when we
+// have been told we're behind a reverse proxy, otherwise anyone could pick their own bucket.
+func (h *Handler) clientIP(r *http.Request) string {
+	if h.trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			ip, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(ip)
+		}
+		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			return realIP
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
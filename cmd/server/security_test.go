@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newRateLimiter(time.Minute, 2)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("1.2.3.4"); !ok {
+			t.Fatalf("expected request %d to be allowed", i)
+		}
+	}
+	ok, wait := l.allow("1.2.3.4")
+	if ok {
+		t.Fatal("expected third request to be limited")
+	}
+	if wait != time.Minute {
+		t.Errorf("expected to wait a minute got %s", wait)
+	}
+	if ok, _ := l.allow("5.6.7.8"); !ok {
+		t.Error("expected other clients to have their own bucket")
+	}
+
+	now = now.Add(time.Minute)
+	if ok, _ := l.allow("1.2.3.4"); !ok {
+		t.Error("expected a token to have been refilled")
+	}
+}
+
+func TestCSRFProtect(t *testing.T) {
+	h := &Handler{mode: Dev}
+	called := false
+	fn := h.csrfProtect(func(w http.ResponseWriter, r *http.Request) error {
+		called = true
+		return nil
+	})
+
+	// a get request issues a token
+	rec := httptest.NewRecorder()
+	if err := fn(rec, httptest.NewRequest(http.MethodGet, "/", nil)); err != nil {
+		t.Fatal(err)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookieName {
+		t.Fatalf("expected a csrf cookie to be set got %v", cookies)
+	}
+	token := cookies[0].Value
+
+	// a post without the token is rejected
+	called = false
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/subscribe", strings.NewReader("email=a@b.ie"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookies[0])
+	if err := fn(rec, req); err != nil {
+		t.Fatal(err)
+	}
+	if called || rec.Code != http.StatusForbidden {
+		t.Fatalf("expected post without token to be forbidden got %d", rec.Code)
+	}
+
+	// a post with the token in the htmx header is allowed
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/subscribe", nil)
+	req.Header.Set(csrfHeaderName, token)
+	req.AddCookie(cookies[0])
+	if err := fn(rec, req); err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Fatal("expected post with a valid token to reach the handler")
+	}
+}
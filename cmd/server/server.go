@@ -2,31 +2,29 @@ package main
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
+	"time"
 
 	"github.com/gorilla/sessions"
 	"github.com/seanomeara96/auth"
 )
 
-func server(port string, mode Mode, service *Service) error {
-	if port == "" {
-		return fmt.Errorf("port is required via -port flag")
-	}
-	if mode == "" || mode == "dev" {
+// serverTimeouts bound how long the http server spends on a single connection and how long
+// it waits for in-flight work when shutting down
+type serverTimeouts struct {
+	Read     time.Duration
+	Write    time.Duration
+	Idle     time.Duration
+	Shutdown time.Duration
+}
+
+// server runs until ctx is cancelled then waits for in-flight requests until shutdownCtx is done
+func server(ctx, shutdownCtx context.Context, cfg Config, service *Service) error {
+	mode := cfg.Mode
+	if mode == Dev {
 		log.Println("Starting server in development mode.")
-		mode = Dev
-	}
-	productionDomain := os.Getenv("PROD_DOMAIN")
-	if mode == Prod && productionDomain == "" {
-		return errors.New("must supply production domain to run server in prod")
-	}
-	currentDomain := "http://localhost:" + port
-	if mode == Prod {
-		currentDomain = productionDomain
 	}
 	r := http.NewServeMux()
 
@@ -41,7 +39,7 @@ func server(port string, mode Mode, service *Service) error {
 	}
 
 	authConfig := auth.AuthConfig{
-		JWTSecretKey: os.Getenv("SESSION_KEY"),
+		JWTSecretKey: cfg.SessionKey,
 		CookieSecure: true,
 		HttpOnly:     true,
 		SameSite:     http.SameSiteStrictMode,
@@ -56,19 +54,19 @@ func server(port string, mode Mode, service *Service) error {
 		if admin email / password is set in env then no user is created
 		cant log in with email = "" and password = ""
 	*/
-	authenticator.Register(context.Background(), os.Getenv("ADMIN_EMAIL"), os.Getenv("ADMIN_PASSWORD"))
+	authenticator.Register(context.Background(), cfg.AdminEmail, cfg.AdminPassword)
 
 	handler := Handler{
-		store:         sessions.NewCookieStore([]byte(os.Getenv(`SESSION_KEY`))),
+		store:         sessions.NewCookieStore([]byte(cfg.SessionKey)),
 		mode:          mode,
-		domain:        currentDomain,
+		domain:        cfg.Domain,
 		service:       service,
 		render:        renderer,
 		authenticator: authenticator,
+		trustProxy:    cfg.TrustProxy,
 	}
 
 	handler.InitSubscriptionWorker()
-	defer handler.Close()
 
 	assetsDir := http.Dir("assets/dist")
 	assetsFileServer := http.FileServer(assetsDir)
@@ -82,6 +80,10 @@ func server(port string, mode Mode, service *Service) error {
 	faviconFileServer := http.FileServer(faviconDir)
 	r.Handle("/favicon_io/", http.StripPrefix("/favicon_io/", faviconFileServer))
 
+	if service.media != nil {
+		r.Handle("/media/", service.media.Handler())
+	}
+
 	/*
 		Serve robots.txt & sitemap
 	*/
@@ -95,23 +97,78 @@ func server(port string, mode Mode, service *Service) error {
 		http.ServeFile(w, r, "./favicon_io/favicon.ico")
 	})
 
-	globalMiddleware := []middleware{handler.pathLogger}
+	// per client limits, sign in and subscribe are stricter than the default for writes
+	writeLimiter := newRateLimiter(2*time.Second, 30)
+	signInLimiter := newRateLimiter(time.Minute, 5)
+	subscribeLimiter := newRateLimiter(10*time.Minute, 5)
+	handler.clicks = newRateLimiter(time.Minute, 10)
+
+	// applied in order so the last middleware is the outermost
+	globalMiddleware := []middleware{
+		handler.pathLogger,
+		handler.csrfProtect,
+		handler.limitWrites(writeLimiter),
+		handler.securityHeaders,
+	}
 
 	handle := newHandleFunc(r, globalMiddleware, service.ReportErr)
 
-	handle("/", handler.handleGetHomePage)
-	handle("GET /coupons", handler.handleListCoupons)
-	handle("GET /website/{websitePath}", handler.handleGetFeed)
-	handle("GET /subscribe", handler.handleSubscribe)
-	handle("POST /subscribe", handler.handleStoreSubscription)
-	handle("GET /subscribe/verify", handler.handleGetVerifySubscription)
+	// public pages are served in each locale, see i18n.go
+	english := handler.withLocale("en")
+	handle("/", english(handler.handleGetHomePage))
+	handle("GET /coupons", english(handler.handleListCoupons))
+	handle("GET /website/{websitePath}", english(handler.handleGetFeed))
+	handle("GET /subscribe", english(handler.handleSubscribe))
+	handle("POST /subscribe", handler.rateLimit(subscribeLimiter)(english(handler.handleStoreSubscription)))
+	handle("GET /subscribe/verify", english(handler.handleGetVerifySubscription))
+	handle("GET /products/{slug}", english(handler.handleGetCatalogProduct))
+	handle("GET /alerts/new", english(handler.handleGetPriceAlert))
+	handle("POST /alerts", handler.rateLimit(subscribeLimiter)(english(handler.handleStorePriceAlert)))
+	handle("GET /alerts/confirm", english(handler.handleConfirmPriceAlert))
+	handle("GET /alerts/remove", english(handler.handleRemovePriceAlert))
+	handle("GET /go/{postID}", handler.handleGoPost)
+	handle("GET /go/coupon/{id}", handler.handleGoCoupon)
+	handle("GET /go/offer/{id}", handler.handleGoOffer)
+	handle("GET /go/product/{id}", handler.handleGoProduct)
+
+	irish := handler.withLocale("ga")
+	handle("/ga/", irish(handler.handleGetHomePage))
+	handle("GET /ga/coupons", irish(handler.handleListCoupons))
+	handle("GET /ga/website/{websitePath}", irish(handler.handleGetFeed))
+	handle("GET /ga/subscribe", irish(handler.handleSubscribe))
+	handle("POST /ga/subscribe", handler.rateLimit(subscribeLimiter)(irish(handler.handleStoreSubscription)))
+	handle("GET /ga/subscribe/verify", irish(handler.handleGetVerifySubscription))
+	handle("GET /ga/products/{slug}", irish(handler.handleGetCatalogProduct))
+	handle("GET /ga/alerts/new", irish(handler.handleGetPriceAlert))
+	handle("POST /ga/alerts", handler.rateLimit(subscribeLimiter)(irish(handler.handleStorePriceAlert)))
+	handle("GET /ga/alerts/confirm", irish(handler.handleConfirmPriceAlert))
+	handle("GET /ga/alerts/remove", irish(handler.handleRemovePriceAlert))
 
 	handle("GET /admin/signin", handler.adminHandleGetSignIn)
-	handle("POST /admin/signin", handler.adminHandlePostSignIn)
+	handle("POST /admin/signin", handler.rateLimit(signInLimiter)(handler.adminHandlePostSignIn))
 	handle("GET /admin/signout", handler.adminHandleGetSignOut)
 	handle("GET /admin", handler.mustBeAdmin(handler.adminHandleGetDashboard))
 
 	handle("GET /admin/subscribers", handler.mustBeAdmin(handler.handleListSubscribers))
+	handle("GET /metrics", handler.mustBeAdmin(handler.handleGetMetrics))
+	handle("GET /admin/errors", handler.mustBeAdmin(handler.adminHandleListErrors))
+	handle("GET /admin/promotions", handler.mustBeAdmin(handler.adminHandleGetPromotions))
+	handle("GET /admin/review", handler.mustBeAdmin(handler.adminHandleGetReview))
+	handle("POST /admin/review/{id}", handler.mustBeAdmin(handler.adminHandlePostReview))
+	handle("GET /admin/analyses", handler.mustBeAdmin(handler.adminHandleGetAnalysisQueue))
+	handle("POST /admin/analyses/{id}/retry", handler.mustBeAdmin(handler.adminHandleRetryAnalysis))
+	handle("GET /admin/prompts", handler.mustBeAdmin(handler.adminHandleListPrompts))
+	handle("POST /admin/prompts", handler.mustBeAdmin(handler.adminHandleCreatePrompt))
+	handle("POST /admin/prompts/{id}/activate", handler.mustBeAdmin(handler.adminHandleActivatePrompt))
+	handle("GET /admin/prompts/{id}/compare", handler.mustBeAdmin(handler.adminHandleGetPromptComparison))
+	handle("POST /admin/prompts/{id}/compare", handler.mustBeAdmin(handler.adminHandleComparePrompt))
+	handle("GET /admin/llm", handler.mustBeAdmin(handler.adminHandleGetLLMSpend))
+	handle("GET /admin/products", handler.mustBeAdmin(handler.adminHandleListProducts))
+	handle("POST /admin/products", handler.mustBeAdmin(handler.adminHandleAddProduct))
+	handle("GET /admin/scoring", handler.mustBeAdmin(handler.adminHandleGetScoring))
+	handle("POST /admin/scoring", handler.mustBeAdmin(handler.adminHandleSaveScoring))
+	handle("GET /admin/clicks", handler.mustBeAdmin(handler.adminHandleGetClicks))
+	handle("POST /admin/clicks", handler.mustBeAdmin(handler.adminHandleSaveAffiliateTemplate))
 	/*	handle("GET /admin/subscribers/create", handler.mustBeAdmin(handler.handleCreateSubscriber))
 		handle("POST /admin/subscribers/create", handler.mustBeAdmin(handler.handleStoreSubscriber))
 		handle("GET /admin/subscribers/{id}", handler.mustBeAdmin(handler.handleEditSubscriber))
@@ -147,10 +204,38 @@ func server(port string, mode Mode, service *Service) error {
 
 	*/
 
-	log.Println("Server listening on http://localhost:" + port)
-	if err := http.ListenAndServe(":"+port, r); err != nil {
+	srv := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           r,
+		ReadTimeout:       cfg.Timeouts.Read,
+		ReadHeaderTimeout: cfg.Timeouts.Read,
+		WriteTimeout:      cfg.Timeouts.Write,
+		IdleTimeout:       cfg.Timeouts.Idle,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Println("Server listening on http://localhost:" + cfg.Port)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		handler.Close()
 		return fmt.Errorf("failure to launch. %w", err)
+	case <-ctx.Done():
 	}
 
+	log.Println("shutting down server")
+
+	// stop accepting new connections and wait for in-flight requests to complete
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("warning: server did not shut down cleanly: %v", err)
+		srv.Close()
+	}
+
+	// let any queued subscriptions finish before the database is closed
+	handler.Close()
+
 	return nil
 }
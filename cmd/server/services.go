@@ -1,13 +1,41 @@
 package main
 
 import (
+	"beautybargains/internal/chat"
 	"database/sql"
 	"fmt"
+	"sync"
+	"time"
 )
 
 type Service struct {
 	db        *sql.DB
 	ReportErr func(error) error
+	// media archives banner images, nil skips archiving
+	media *MediaStore
+	// llm caches llm responses and records their usage
+	llm *chat.SQLStore
+	// moderate holds new posts as pending for an admin to approve
+	moderate bool
+	// retry is how failed banner analyses are retried, see analysis.go
+	retry retryPolicy
+	// translateTo are the locales approved posts are translated into, see translations.go
+	translateTo []string
+	// ocr reads banners when the llm can't, nil disables the fallback, see ocr.go
+	ocr *ocrEngine
+	// priceDrops is when a cheaper price is a drop worth recording or posting, see pricedrops.go
+	priceDrops PriceDropConfig
+	// scoreHalfLife is how fast recency fades from post scores, see scoring.go
+	scoreHalfLife time.Duration
+	// mail sends price alerts, nil holds them until a mail server is configured, see pricealerts.go
+	mail *Mailer
+	// trendingConfig is the window and number of trending hashtags, see trending.go
+	trendingConfig TrendingConfig
+	// trending caches trending hashtags by scope between job runs
+	trendingMu sync.RWMutex
+	trending   map[trendScope][]TrendingHashtag
+	// promptRuns are the ids of prompts being compared in the background, see prompts.go
+	promptRuns sync.Map
 
 	// category statemants
 	// Prepared statements for reusing and improving performance
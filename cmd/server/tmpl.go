@@ -22,6 +22,11 @@ func (t *Tmpl) Get() *template.Template {
 		"add":                 add,
 		"subtract":            subtract,
 		"lower":               lower,
+		"humanDuration":       humanDuration,
+		"joinNames":           joinNames,
+		"t":                   translate,
+		"localDuration":       localDuration,
+		"dealLabels":          func(d Deal) []string { return d.labels(defaultLocale) },
 	}
 	t.tmpl = template.Must(template.New("web").Funcs(funcMap).ParseGlob(t.glob))
 	return t.tmpl
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"beautybargains/internal/chat"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+/*
+Approved posts are translated into each of TRANSLATE_LOCALES by the translate_posts job and the
+translations stored in post_translations. Pages in a locale show the translation when there is
+one and the English description until then. The prompt is versioned like extract_offers.
+*/
+
+const translatePromptJob = "translate_post"
+
+// translateBatch caps how many posts one run of translatePosts sends to the llm per locale
+const translateBatch = 50
+
+type translationResponse struct {
+	Description string `json:"description"`
+}
+
+// translatePosts translates approved posts that are missing a translation. Failed posts are
+// logged and tried again next run, running out of llm budget stops the job until then.
+func translatePosts(ctx context.Context, service *Service) error {
+	if len(service.translateTo) == 0 {
+		return nil
+	}
+	prompt, err := getActivePrompt(service.db, translatePromptJob)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range service.translateTo {
+		locale, ok := getLocale(code)
+		if !ok {
+			return fmt.Errorf("unknown locale %q", code)
+		}
+		posts, err := getUntranslatedPosts(service.db, locale.Code, translateBatch)
+		if err != nil {
+			return err
+		}
+		for _, post := range posts {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			description, err := runTranslatePrompt(ctx, prompt, locale, post.Description)
+			if errors.Is(err, chat.ErrBudgetExceeded) {
+				log.Printf("stopped translating posts into %s: %v", locale.Code, err)
+				return nil
+			}
+			if err != nil {
+				log.Printf("could not translate post %d into %s: %v", post.ID, locale.Code, err)
+				continue
+			}
+			if err := savePostTranslation(service.db, post.ID, locale.Code, description, prompt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// getUntranslatedPosts returns approved posts without a translation into locale, newest first
+func getUntranslatedPosts(db *sql.DB, locale string, limit int) ([]Post, error) {
+	rows, err := db.Query(`
+	SELECT
+		`+postColumns+`
+	FROM
+		posts
+	WHERE
+		status = ?
+		AND id NOT IN (SELECT post_id FROM post_translations WHERE locale = ?)
+	ORDER BY
+		timestamp DESC
+	LIMIT ?`,
+		postApproved, locale, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not get posts to translate into %s: %w", locale, err)
+	}
+	defer rows.Close()
+	return scanPosts(rows, make([]Post, 0, limit))
+}
+
+// runTranslatePrompt translates text, a post description, into locale
+func runTranslatePrompt(ctx context.Context, prompt Prompt, locale Locale, text string) (string, error) {
+	content, err := prompt.execute(promptData{Language: locale.Language, Text: text})
+	if err != nil {
+		return "", err
+	}
+
+	requestParams := openai.ChatCompletionRequest{
+		Model: prompt.model(),
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    "user",
+				Content: content,
+			},
+		},
+		ResponseFormat: &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+			JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+				Name:        "TranslationResponse",
+				Description: "Schema for a translated post.",
+				Schema:      &chat.MySchema{Raw: prompt.Schema},
+			},
+		},
+	}
+
+	call := chat.Call{Job: translatePromptJob, Validate: func(answer string) error {
+		_, err := parseTranslation(answer)
+		return err
+	}}
+	answer, err := chat.CreateChatCompletion(ctx, requestParams, call)
+	if err != nil {
+		return "", err
+	}
+	return parseTranslation(answer)
+}
+
+// parseTranslation returns the translated description in the llm's answer
+func parseTranslation(answer string) (string, error) {
+	var data translationResponse
+	if err := json.Unmarshal([]byte(answer), &data); err != nil {
+		return "", err
+	}
+	description := strings.TrimSpace(data.Description)
+	if description == "" {
+		return "", errors.New("the translation is empty")
+	}
+	return description, nil
+}
+
+func savePostTranslation(db *sql.DB, postID int, locale, description string, prompt Prompt) error {
+	if _, err := db.Exec(`
+	INSERT INTO
+		post_translations (post_id, locale, description, prompt_version, model, created_at)
+	VALUES
+		(?, ?, ?, ?, ?, ?)
+	ON CONFLICT(post_id, locale) DO UPDATE SET
+		description = excluded.description,
+		prompt_version = excluded.prompt_version,
+		model = excluded.model,
+		created_at = excluded.created_at`,
+		postID, locale, description, prompt.Version, prompt.model(), time.Now(),
+	); err != nil {
+		return fmt.Errorf("could not save %s translation of post %d: %w", locale, postID, err)
+	}
+	return nil
+}
+
+// getPostTranslations returns the descriptions of posts in locale by post id. Posts that haven't
+// been translated yet are missing.
+func (s *Service) getPostTranslations(posts []Post, locale string) (map[int]string, error) {
+	if locale == defaultLocale || len(posts) == 0 {
+		return nil, nil
+	}
+	args := make([]any, 0, len(posts)+1)
+	args = append(args, locale)
+	for _, post := range posts {
+		args = append(args, post.ID)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(posts)), ",")
+	rows, err := s.db.Query(`
+	SELECT
+		post_id,
+		description
+	FROM
+		post_translations
+	WHERE
+		locale = ?
+		AND post_id IN (`+placeholders+`)`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not get %s translations: %w", locale, err)
+	}
+	defer rows.Close()
+
+	translations := make(map[int]string, len(posts))
+	for rows.Next() {
+		var postID int
+		var description string
+		if err := rows.Scan(&postID, &description); err != nil {
+			return nil, err
+		}
+		translations[postID] = description
+	}
+	return translations, rows.Err()
+}
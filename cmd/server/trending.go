@@ -0,0 +1,208 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+A hashtag trends by being on more approved posts in the trending window, the last
+TRENDING_WINDOW, than in the window before it. Hashtags are ranked by their posts in the window
+times their velocity, (posts + 1) / (previous posts + 1), so a tag on 4 posts this week that
+wasn't used last week beats one that's on 6 posts every week.
+
+Trending is worked out overall, per website and per category by the jobs and cached until
+the next run, see refreshTrending.
+*/
+
+type TrendingConfig struct {
+	// Window is how far back trending looks, velocity compares it with the window before
+	Window time.Duration
+	// Limit is how many hashtags trend at once
+	Limit int
+}
+
+type TrendingHashtag struct {
+	Hashtag
+	// Posts used the hashtag in the window, Previous in the window before
+	Posts    int
+	Previous int
+	Velocity float64
+}
+
+// New reports whether the hashtag wasn't used in the previous window
+func (t TrendingHashtag) New() bool {
+	return t.Previous == 0
+}
+
+// Rising reports whether the hashtag is on more posts than in the previous window
+func (t TrendingHashtag) Rising() bool {
+	return t.Posts > t.Previous
+}
+
+func (t TrendingHashtag) rank() float64 {
+	return float64(t.Posts) * t.Velocity
+}
+
+// trendScope is what hashtags trend in, everything when both are 0
+type trendScope struct {
+	WebsiteID  int
+	CategoryID int
+}
+
+// hashtagMention is a hashtag on a post from either window
+type hashtagMention struct {
+	Hashtag
+	PostID      int
+	WebsiteID   int
+	CategoryIDs []int
+	// Current is set for posts in the trending window, unset for the window before
+	Current bool
+}
+
+// rankTrending counts mentions in every scope and returns the top limit hashtags of each
+func rankTrending(mentions []hashtagMention, limit int) map[trendScope][]TrendingHashtag {
+	type scopedHashtag struct {
+		scope     trendScope
+		hashtagID int
+	}
+	counts := map[scopedHashtag]*TrendingHashtag{}
+	seen := map[[2]int]bool{}
+	for _, m := range mentions {
+		if seen[[2]int{m.ID, m.PostID}] {
+			continue
+		}
+		seen[[2]int{m.ID, m.PostID}] = true
+
+		scopes := []trendScope{{}, {WebsiteID: m.WebsiteID}}
+		for _, categoryID := range m.CategoryIDs {
+			scopes = append(scopes, trendScope{CategoryID: categoryID})
+		}
+		for _, scope := range scopes {
+			key := scopedHashtag{scope, m.ID}
+			t, ok := counts[key]
+			if !ok {
+				t = &TrendingHashtag{Hashtag: m.Hashtag}
+				counts[key] = t
+			}
+			if m.Current {
+				t.Posts++
+			} else {
+				t.Previous++
+			}
+		}
+	}
+
+	ranked := map[trendScope][]TrendingHashtag{}
+	for key, t := range counts {
+		// falling out of use isn't trending
+		if t.Posts == 0 {
+			continue
+		}
+		t.Velocity = float64(t.Posts+1) / float64(t.Previous+1)
+		ranked[key.scope] = append(ranked[key.scope], *t)
+	}
+	for scope, hashtags := range ranked {
+		sort.Slice(hashtags, func(i, j int) bool {
+			a, b := hashtags[i], hashtags[j]
+			if a.rank() != b.rank() {
+				return a.rank() > b.rank()
+			}
+			if a.Posts != b.Posts {
+				return a.Posts > b.Posts
+			}
+			return a.Phrase < b.Phrase
+		})
+		if len(hashtags) > limit {
+			ranked[scope] = hashtags[:limit]
+		}
+	}
+	return ranked
+}
+
+// getHashtagMentions returns the hashtags of approved posts published since from, the
+// start of the window before the trending window, with their websites and categories
+func (s *Service) getHashtagMentions(from, windowStart time.Time) ([]hashtagMention, error) {
+	rows, err := s.db.Query(`
+	SELECT
+		h.id,
+		h.phrase,
+		p.id,
+		p.website_id,
+		p.timestamp >= ?,
+		GROUP_CONCAT(pc.category_id)
+	FROM
+		post_hashtags ph
+		JOIN hashtags h ON h.id = ph.hashtag_id
+		JOIN posts p ON p.id = ph.post_id
+		LEFT JOIN post_categories pc ON pc.post_id = p.id
+	WHERE
+		p.status = ?
+		AND p.timestamp >= ?
+	GROUP BY
+		ph.id`,
+		windowStart.UTC(), postApproved, from.UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not get hashtag mentions: %w", err)
+	}
+	defer rows.Close()
+
+	var mentions []hashtagMention
+	for rows.Next() {
+		var m hashtagMention
+		var categories sql.NullString
+		if err := rows.Scan(&m.ID, &m.Phrase, &m.PostID, &m.WebsiteID, &m.Current, &categories); err != nil {
+			return nil, fmt.Errorf("could not scan hashtag mention: %w", err)
+		}
+		for _, id := range strings.Split(categories.String, ",") {
+			if categoryID, err := strconv.Atoi(id); err == nil {
+				m.CategoryIDs = append(m.CategoryIDs, categoryID)
+			}
+		}
+		mentions = append(mentions, m)
+	}
+	return mentions, rows.Err()
+}
+
+func (s *Service) computeTrending(now time.Time) (map[trendScope][]TrendingHashtag, error) {
+	windowStart := now.Add(-s.trendingConfig.Window)
+	mentions, err := s.getHashtagMentions(windowStart.Add(-s.trendingConfig.Window), windowStart)
+	if err != nil {
+		return nil, err
+	}
+	return rankTrending(mentions, s.trendingConfig.Limit), nil
+}
+
+// refreshTrending recomputes the cached trending hashtags
+func refreshTrending(service *Service) error {
+	trending, err := service.computeTrending(time.Now())
+	if err != nil {
+		return err
+	}
+	service.trendingMu.Lock()
+	service.trending = trending
+	service.trendingMu.Unlock()
+	return nil
+}
+
+// GetTrendingHashtags returns the cached trending hashtags of a website or category, or
+// overall when both are 0. They're computed here when the jobs haven't run yet.
+func (s *Service) GetTrendingHashtags(websiteID, categoryID int) ([]TrendingHashtag, error) {
+	s.trendingMu.RLock()
+	trending := s.trending
+	s.trendingMu.RUnlock()
+	if trending == nil {
+		if err := refreshTrending(s); err != nil {
+			return nil, err
+		}
+		s.trendingMu.RLock()
+		trending = s.trending
+		s.trendingMu.RUnlock()
+	}
+	return trending[trendScope{WebsiteID: websiteID, CategoryID: categoryID}], nil
+}
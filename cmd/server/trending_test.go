@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestRankTrending(t *testing.T) {
+	steady := Hashtag{ID: 1, Phrase: "skincare"}
+	rising := Hashtag{ID: 2, Phrase: "spf"}
+	fading := Hashtag{ID: 3, Phrase: "advent"}
+
+	var mentions []hashtagMention
+	mention := func(h Hashtag, postID, websiteID int, current bool, categories ...int) {
+		mentions = append(mentions, hashtagMention{Hashtag: h, PostID: postID, WebsiteID: websiteID, Current: current, CategoryIDs: categories})
+	}
+	// skincare is on 6 posts in both windows, spf on 4 new posts and advent only last window
+	for i := range 6 {
+		mention(steady, 100+i, 1, true)
+		mention(steady, 200+i, 1, false)
+	}
+	for i := range 4 {
+		mention(rising, 300+i, 2, true, 7)
+	}
+	mention(rising, 300, 2, true, 7) // the same post twice counts once
+	mention(fading, 400, 1, false)
+
+	ranked := rankTrending(mentions, 5)
+
+	all := ranked[trendScope{}]
+	if len(all) != 2 {
+		t.Fatalf("expected 2 trending hashtags, got %+v", all)
+	}
+	if all[0].Phrase != "spf" || all[0].Posts != 4 || !all[0].New() || all[0].Velocity != 5 {
+		t.Errorf("expected spf to trend first with 4 new posts, got %+v", all[0])
+	}
+	if all[1].Phrase != "skincare" || all[1].Posts != 6 || all[1].Previous != 6 || all[1].Rising() {
+		t.Errorf("expected steady skincare second, got %+v", all[1])
+	}
+
+	if site := ranked[trendScope{WebsiteID: 1}]; len(site) != 1 || site[0].Phrase != "skincare" {
+		t.Errorf("expected only skincare to trend on website 1, got %+v", site)
+	}
+	if category := ranked[trendScope{CategoryID: 7}]; len(category) != 1 || category[0].Phrase != "spf" {
+		t.Errorf("expected only spf to trend in category 7, got %+v", category)
+	}
+
+	if top := rankTrending(mentions, 1)[trendScope{}]; len(top) != 1 || top[0].Phrase != "spf" {
+		t.Errorf("expected the limit to keep only spf, got %+v", top)
+	}
+}
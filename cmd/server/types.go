@@ -4,12 +4,6 @@ import (
 	"net/http"
 )
 
-type Trending struct {
-	Category  string
-	Phrase    string
-	PostCount int
-}
-
 type handleFunc func(w http.ResponseWriter, r *http.Request) error
 type middleware func(next handleFunc) handleFunc
 
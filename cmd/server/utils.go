@@ -1,7 +1,9 @@
 package main
 
 import (
-	"encoding/json"
+	"beautybargains/internal/fetch"
+	"bytes"
+	"context"
 	"fmt"
 	"html/template"
 	"log"
@@ -21,7 +23,11 @@ func newHandleFunc(
 			fn = globalMiddleware[i](fn)
 		}
 		r.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
-			if err := fn(w, r); err != nil {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w}
+			err := fn(rec, r)
+			observeRequest(path, start, rec, err)
+			if err != nil {
 				err = fmt.Errorf("error at %s %s => %v", r.Method, r.URL.Path, err)
 				reportErr(err)
 				return
@@ -83,14 +89,13 @@ func lower(s string) string {
 // for websites like lookfantastic and cult beauty that have carousels with text not embedded in the image
 // can be passed to the llm for additional context
 
-func getGoQueryPageDocument(url string) (*goquery.Document, error) {
-	res, err := http.Get(url)
+func getGoQueryPageDocument(ctx context.Context, url string) (*goquery.Document, error) {
+	res, err := fetch.Get(ctx, url)
 	if err != nil {
 		return nil, fmt.Errorf("error sending get request to extract banner urls %w", err)
 	}
-	defer res.Body.Close()
 	// Load the HTML document
-	doc, err := goquery.NewDocumentFromReader(res.Body)
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(res.Body))
 	if err != nil {
 		return nil, fmt.Errorf("error parsing document with go query %w", err)
 	}
@@ -98,16 +103,22 @@ func getGoQueryPageDocument(url string) (*goquery.Document, error) {
 }
 
 type BannerData struct {
-	Src            string
-	SupportingText string
-	Href           string
+	Src            string `json:"src"`
+	SupportingText string `json:"supporting_text,omitempty"`
+	Href           string `json:"href,omitempty"`
 }
 
-func extractWebsiteBannerURLs(website Website) ([]BannerData, error) {
-	doc, err := getGoQueryPageDocument(website.URL)
+func extractWebsiteBannerURLs(ctx context.Context, website Website) ([]BannerData, error) {
+	doc, err := getGoQueryPageDocument(ctx, website.URL)
 	if err != nil {
 		return nil, err
 	}
+	return extractDocumentBanners(website, doc)
+}
+
+// extractDocumentBanners applies a website's banner rules to its homepage
+func extractDocumentBanners(website Website, doc *goquery.Document) ([]BannerData, error) {
+	embedded := extractEmbeddedData(doc)
 
 	bannerData := []BannerData{}
 
@@ -187,38 +198,30 @@ func extractWebsiteBannerURLs(website Website) ([]BannerData, error) {
 			bannerData = append(bannerData, lf)
 		})
 	case 3:
-		// millies
-		doc.Find(".swiper-wrapper .slide-img.md\\:hidden").Each(func(i int, s *goquery.Selection) {
-			millies := BannerData{}
-			// For each item found, get the title
-			if value, found := s.Attr("src"); found {
-				value = strings.ReplaceAll(value, "{width}", "800")
-
-				if strings.HasPrefix(value, "//") {
-					value = "https:" + value
-				}
-
-				millies.Src = value
-			}
+		// millies, a shopify theme that renders its slideshow from section json
+		for _, millies := range embedded.SectionBanners(website) {
+			millies.Src = strings.ReplaceAll(millies.Src, "{width}", "800")
 			bannerData = append(bannerData, millies)
-		})
+		}
 	case 4:
-		// mcCauleys
-		doc.Find("[data-content-type=slide] [data-background-images]").Each(func(i int, s *goquery.Selection) {
-			mc := BannerData{}
-			if value, found := s.Attr("data-background-images"); found {
-				var result = map[string]any{}
-				value = strings.ReplaceAll(value, "\\\"", "\"")
-				if err := json.Unmarshal([]byte(value), &result); err != nil {
-					return
-				}
-				// Extract the mobile_image value
-				if mobileImage, ok := result["mobile_image"].(string); ok {
-					mc.Src = mobileImage
-				}
+		// mcCauleys, magento page builder keeps slide images in a json attribute
+		for _, attr := range embedded.AttributesNamed("data-background-images") {
+			slide := attr.Selection.Closest("[data-content-type=slide]")
+			if slide.Length() == 0 {
+				continue
+			}
+			mc := BannerData{Src: jsonString(attr.Value, "mobile_image")}
+			if mc.Src == "" {
+				mc.Src = jsonString(attr.Value, "desktop_image")
+			}
+			if href, found := slide.Find("a[href]").First().Attr("href"); found {
+				mc.Href = absoluteURL(website, href)
+			}
+			if text := strings.Join(strings.Fields(slide.Text()), " "); text != "" {
+				mc.SupportingText = text
 			}
 			bannerData = append(bannerData, mc)
-		})
+		}
 
 	case 5:
 		// skin shop
@@ -246,17 +249,50 @@ func extractWebsiteBannerURLs(website Website) ([]BannerData, error) {
 		})
 
 	case 7:
-		doc.Find("body > div.home-main > div:nth-child(5) > div .slide-image").Each(func(i int, s *goquery.Selection) {
-			if imgSrc, found := s.Attr("src"); found {
-				if strings.HasPrefix(imgSrc, "/") {
-					imgSrc = website.URL + imgSrc
-				}
-
-				bannerData = append(bannerData, BannerData{Src: imgSrc})
+		// beauty savers, any image in a slide rather than a position in the page
+		seen := map[string]bool{}
+		doc.Find(`[class*="slide"] img`).Each(func(i int, s *goquery.Selection) {
+			imgSrc := lazyImageSrc(s)
+			if imgSrc == "" || seen[imgSrc] {
+				return
+			}
+			seen[imgSrc] = true
+			bs := BannerData{Src: absoluteURL(website, imgSrc)}
+			if href, found := s.Closest("a[href]").Attr("href"); found {
+				bs.Href = absoluteURL(website, href)
 			}
+			bs.SupportingText = strings.TrimSpace(s.AttrOr("alt", ""))
+			bannerData = append(bannerData, bs)
 		})
 	default:
 		return nil, fmt.Errorf("could not find banner extraction rules for website %s", website.WebsiteName)
 	}
-	return bannerData, nil
+
+	// a slide we couldn't read an image from is no use
+	usable := bannerData[:0]
+	for _, banner := range bannerData {
+		if banner.Src != "" {
+			usable = append(usable, banner)
+		}
+	}
+
+	// themes change, fall back to any slides described in the page's embedded json
+	if len(usable) == 0 {
+		usable = embedded.Banners(website)
+	}
+
+	return usable, nil
+}
+
+// lazyImageSrc returns the image an img loads, lazy loaded images keep it in a data attribute
+// until they're scrolled into view
+func lazyImageSrc(s *goquery.Selection) string {
+	for _, attr := range []string{"data-src", "src", "data-srcset", "srcset"} {
+		// a srcset's first candidate is enough, "url 640w, url 1280w"
+		fields := strings.Fields(s.AttrOr(attr, ""))
+		if len(fields) > 0 && !strings.HasPrefix(fields[0], "data:") {
+			return strings.TrimSuffix(fields[0], ",")
+		}
+	}
+	return ""
 }
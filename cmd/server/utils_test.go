@@ -1,12 +1,18 @@
 package main
 
-import "testing"
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
 
 func TestExtractWebsiteBannerURLs(t *testing.T) {
 
 	for _, website := range websites {
 
-		banners, err := extractWebsiteBannerURLs(website)
+		banners, err := extractWebsiteBannerURLs(context.Background(), website)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -17,3 +23,60 @@ func TestExtractWebsiteBannerURLs(t *testing.T) {
 	}
 
 }
+
+func TestExtractDocumentBanners(t *testing.T) {
+	tests := []struct {
+		name      string
+		websiteID int
+		page      string
+		want      []BannerData
+	}{
+		{
+			"millies section json",
+			Millies,
+			`<script type="application/json" id="slideshow">{"slides":[{"image":"//millies.ie/cdn/shop/files/spf_{width}x.jpg","link":"/collections/spf","heading":"SPF week"}]}</script>`,
+			[]BannerData{{Src: "https://millies.ie/cdn/shop/files/spf_800x.jpg", Href: "https://millies.ie/collections/spf", SupportingText: "SPF week"}},
+		},
+		{
+			"beauty savers lazy slides",
+			BeautySavers,
+			`<div class="hero-slider"><div class="slide"><a href="/offers"><img data-src="/img/offers.jpg" src="data:image/gif;base64,R0lGOD" alt="Offers"></a></div>
+			<div class="slide"><img srcset="/img/sale-640.jpg 640w, /img/sale-1280.jpg 1280w"></div></div>`,
+			[]BannerData{
+				{Src: "https://www.beautysavers.ie/img/offers.jpg", Href: "https://www.beautysavers.ie/offers", SupportingText: "Offers"},
+				{Src: "https://www.beautysavers.ie/img/sale-640.jpg"},
+			},
+		},
+		{
+			"slides without images fall back to embedded json",
+			BeautyFeatures,
+			`<div class="som-carousel"><a href="/sale">Sale</a></div>
+			<script type="application/json" data-section-id="hero">{"image":"https://www.beautyfeatures.ie/hero.png"}</script>`,
+			[]BannerData{{Src: "https://www.beautyfeatures.ie/hero.png"}},
+		},
+	}
+	for _, tt := range tests {
+		website, err := getWebsiteByID(tt.websiteID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader("<html><body>" + tt.page + "</body></html>"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		banners, err := extractDocumentBanners(website, doc)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(banners) != len(tt.want) {
+			t.Errorf("%s: expected %+v got %+v", tt.name, tt.want, banners)
+			continue
+		}
+		for i := range banners {
+			if banners[i] != tt.want[i] {
+				t.Errorf("%s: expected %+v got %+v", tt.name, tt.want[i], banners[i])
+			}
+		}
+	}
+}
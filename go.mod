@@ -13,6 +13,7 @@ require (
 	github.com/sashabaranov/go-openai v1.32.0
 	github.com/seanomeara96/auth v0.0.0-20250228155908-adb02061ba43
 	github.com/seanomeara96/paginator v1.0.1
+	golang.org/x/image v0.24.0
 )
 
 require (
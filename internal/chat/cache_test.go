@@ -0,0 +1,134 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func imageRequest(url string) openai.ChatCompletionRequest {
+	return openai.ChatCompletionRequest{
+		Model: openai.GPT4o20240806,
+		Messages: []openai.ChatCompletionMessage{{
+			Role: "user",
+			MultiContent: []openai.ChatMessagePart{
+				{Type: "text", Text: "describe this banner"},
+				{Type: "image_url", ImageURL: &openai.ChatMessageImageURL{URL: url}},
+			},
+		}},
+	}
+}
+
+func TestCacheKeyUsesImageHash(t *testing.T) {
+	a, err := CacheKey(imageRequest("https://cdn.example.com/banner.jpg?v=1"), []string{"abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := CacheKey(imageRequest("https://cdn.example.com/banner.jpg?width=800"), []string{"abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b {
+		t.Fatal("expected the same image under a different url to share a cache key")
+	}
+
+	c, _ := CacheKey(imageRequest("https://cdn.example.com/banner.jpg?v=1"), []string{"def"})
+	if a == c {
+		t.Fatal("expected different images to have different cache keys")
+	}
+
+	// without a hash the url is all we have to go on
+	d, _ := CacheKey(imageRequest("https://cdn.example.com/banner.jpg?v=1"), nil)
+	e, _ := CacheKey(imageRequest("https://cdn.example.com/banner.jpg?width=800"), nil)
+	if d == e {
+		t.Fatal("expected different urls without hashes to have different cache keys")
+	}
+
+	other := imageRequest("https://cdn.example.com/banner.jpg?v=1")
+	other.Model = openai.GPT4oMini
+	f, _ := CacheKey(other, []string{"abc"})
+	if a == f {
+		t.Fatal("expected the model to be part of the cache key")
+	}
+}
+
+type usageStore struct {
+	Store
+	usage []Usage
+}
+
+func (s *usageStore) UsageSince(ctx context.Context, since time.Time) (int, float64, error) {
+	tokens, cost := 0, 0.0
+	for _, u := range s.usage {
+		if !u.Timestamp.Before(since) {
+			tokens += u.PromptTokens + u.CompletionTokens
+			cost += u.Cost
+		}
+	}
+	return tokens, cost, nil
+}
+
+func TestCheckBudget(t *testing.T) {
+	now := time.Date(2024, 11, 15, 12, 0, 0, 0, time.UTC)
+	store := &usageStore{usage: []Usage{
+		{PromptTokens: 900, CompletionTokens: 100, Cost: 1, Timestamp: now.Add(-time.Hour)},
+		{PromptTokens: 4000, Cost: 4, Timestamp: now.Add(-72 * time.Hour)},
+	}}
+
+	cases := []struct {
+		name   string
+		budget Budget
+		err    bool
+	}{
+		{"no budget", Budget{}, false},
+		{"daily tokens left", Budget{DailyTokens: 1001}, false},
+		{"daily tokens used", Budget{DailyTokens: 1000}, true},
+		{"monthly spend left", Budget{MonthlySpend: 5.01}, false},
+		{"monthly spend used", Budget{MonthlySpend: 5}, true},
+		{"earlier days only count towards the month", Budget{DailySpend: 2, MonthlyTokens: 6000}, false},
+	}
+	for _, c := range cases {
+		err := checkBudget(context.Background(), store, c.budget, now)
+		if c.err != errors.Is(err, ErrBudgetExceeded) {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		}
+	}
+}
+
+func TestCost(t *testing.T) {
+	if got := cost(openai.GPT4o20240806, 1_000_000, 100_000); got != 3.5 {
+		t.Fatalf("expected $3.50 got %v", got)
+	}
+	if got := cost("unpriced-model", 1000, 1000); got != 0 {
+		t.Fatalf("expected unpriced models to cost nothing got %v", got)
+	}
+}
+
+func TestCacheable(t *testing.T) {
+	valid := func(content string) error {
+		if content != "{}" {
+			return errors.New("not json")
+		}
+		return nil
+	}
+	tests := []struct {
+		finish   openai.FinishReason
+		content  string
+		validate func(string) error
+		want     bool
+	}{
+		{openai.FinishReasonStop, "anything", nil, true},
+		{openai.FinishReasonStop, "{}", valid, true},
+		{openai.FinishReasonStop, `{"description": "30% of`, valid, false},
+		{openai.FinishReasonLength, "{}", valid, false},
+		{openai.FinishReasonContentFilter, "", nil, false},
+	}
+	for _, tt := range tests {
+		if got := cacheable(tt.finish, tt.content, tt.validate); got != tt.want {
+			t.Errorf("cacheable(%s, %q) = %v, want %v", tt.finish, tt.content, got, tt.want)
+		}
+	}
+}
@@ -1,15 +1,36 @@
 package chat
 
 import (
+	"beautybargains/internal/metrics"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
+	"net/http"
 	"os"
+	"time"
 
 	"github.com/sashabaranov/go-openai"
 )
 
+var (
+	llmCalls = metrics.NewCounter(
+		"beautybargains_llm_calls_total", "Chat completion calls by model, request and outcome (ok, error, cached, budget).",
+		"model", "request", "status",
+	)
+	llmLatency = metrics.NewHistogram(
+		"beautybargains_llm_call_duration_seconds", "Chat completion latency in seconds.",
+		nil, "model", "request",
+	)
+	llmTokens = metrics.NewCounter(
+		"beautybargains_llm_tokens_total", "Tokens consumed by chat completions.",
+		"model", "request", "type",
+	)
+)
+
 type MySchema struct {
 	Raw string
 }
@@ -18,28 +39,271 @@ func (s *MySchema) MarshalJSON() ([]byte, error) {
 	return []byte(s.Raw), nil
 }
 
-func CreateChatCompletion(params openai.ChatCompletionRequest) (string, error) {
-	key := os.Getenv("OPENAI_API_KEY")
+// Config controls how completions are requested. An empty APIKey falls back to the
+// OPENAI_API_KEY env var.
+type Config struct {
+	APIKey    string
+	Model     string
+	MaxTokens int
+	// Timeout bounds each api request, on top of whatever deadline the caller's context has
+	Timeout time.Duration
+	// Store caches responses and records usage, without one every call goes to the api
+	// and budgets can't be enforced
+	Store  Store
+	Budget Budget
+}
+
+// Budget limits spend per UTC day and month. Zero means no limit.
+type Budget struct {
+	DailyTokens   int
+	MonthlyTokens int
+	DailySpend    float64
+	MonthlySpend  float64
+}
+
+// ErrBudgetExceeded is returned instead of making a call that the budget doesn't allow
+var ErrBudgetExceeded = errors.New("llm budget exceeded")
+
+// Price is the cost in US dollars per million tokens
+type Price struct {
+	Prompt     float64
+	Completion float64
+}
+
+// Prices are used to estimate the cost of each call. Models missing from here are recorded
+// with no cost so only their token budget applies.
+var Prices = map[string]Price{
+	openai.GPT4o20240806:     {Prompt: 2.50, Completion: 10.00},
+	openai.GPT4o:             {Prompt: 2.50, Completion: 10.00},
+	openai.GPT4oMini:         {Prompt: 0.15, Completion: 0.60},
+	openai.GPT4oMini20240718: {Prompt: 0.15, Completion: 0.60},
+}
+
+var config = Config{
+	Model:     openai.GPT4o20240806,
+	MaxTokens: 1000,
+	Timeout:   90 * time.Second,
+}
+
+// Configure replaces the package wide config. Zero values keep the defaults, apart from
+// the budget which is always replaced.
+func Configure(c Config) {
+	if c.APIKey != "" {
+		config.APIKey = c.APIKey
+	}
+	if c.Model != "" {
+		config.Model = c.Model
+	}
+	if c.MaxTokens > 0 {
+		config.MaxTokens = c.MaxTokens
+	}
+	if c.Timeout > 0 {
+		config.Timeout = c.Timeout
+	}
+	if c.Store != nil {
+		config.Store = c.Store
+	}
+	config.Budget = c.Budget
+}
+
+// Model is the model used for requests that don't name one
+func Model() string {
+	return config.Model
+}
+
+// Call describes a completion for caching and usage reporting
+type Call struct {
+	// Job is recorded with the usage so spend can be reported per job
+	Job string
+	// ImageHashes are content hashes of the images passed by url. They stand in for the urls
+	// in the cache key so the same image re-uploaded under a new url is still a hit.
+	ImageHashes []string
+	// Validate rejects answers the caller can't use, like JSON that doesn't parse. Rejected
+	// answers aren't cached and a rejected cached answer is asked for again.
+	Validate func(content string) error
+}
+
+// CreateChatCompletion returns the content of the first choice. params.Model falls back to the
+// configured model, max tokens always come from the config. The request is abandoned when
+// ctx is done.
+func CreateChatCompletion(ctx context.Context, params openai.ChatCompletionRequest, call Call) (string, error) {
+	key := config.APIKey
+	if key == "" {
+		key = os.Getenv("OPENAI_API_KEY")
+	}
 	if key == "" {
 		return "", errors.New("OPENAI_API_KEY env var not set")
 	}
 
-	params.Model = openai.GPT4o20240806
-	params.MaxTokens = 1000
+	if params.Model == "" {
+		params.Model = config.Model
+	}
+	params.MaxTokens = config.MaxTokens
+
+	request := "unnamed"
+	if params.ResponseFormat != nil && params.ResponseFormat.JSONSchema != nil {
+		request = params.ResponseFormat.JSONSchema.Name
+	}
+	job := call.Job
+	if job == "" {
+		job = "unknown"
+	}
 
-	res, err := openai.NewClient(key).CreateChatCompletion(context.Background(), params)
+	store := config.Store
+	var cacheKey string
+	if store != nil {
+		var err error
+		if cacheKey, err = CacheKey(params, call.ImageHashes); err != nil {
+			return "", err
+		}
+		content, ok, err := store.CachedResponse(ctx, cacheKey)
+		if err != nil {
+			return "", err
+		}
+		if ok && call.Validate != nil && call.Validate(content) != nil {
+			ok = false
+		}
+		if ok {
+			llmCalls.Inc(params.Model, request, "cached")
+			usage := Usage{Job: job, Request: request, Model: params.Model, Cached: true, Timestamp: time.Now()}
+			if err := store.RecordUsage(ctx, usage); err != nil {
+				return "", err
+			}
+			return content, nil
+		}
+		if err := checkBudget(ctx, store, config.Budget, time.Now()); err != nil {
+			llmCalls.Inc(params.Model, request, "budget")
+			return "", err
+		}
+	}
+
+	start := time.Now()
+	clientConfig := openai.DefaultConfig(key)
+	clientConfig.HTTPClient = &http.Client{Timeout: config.Timeout}
+	res, err := openai.NewClientWithConfig(clientConfig).CreateChatCompletion(ctx, params)
+	llmLatency.Observe(time.Since(start).Seconds(), params.Model, request)
 	if err != nil {
+		llmCalls.Inc(params.Model, request, "error")
 		return "", err
 	}
+	llmCalls.Inc(params.Model, request, "ok")
+	llmTokens.Add(float64(res.Usage.PromptTokens), params.Model, request, "prompt")
+	llmTokens.Add(float64(res.Usage.CompletionTokens), params.Model, request, "completion")
+
+	if len(res.Choices) == 0 {
+		return "", errors.New("chat completion returned no choices")
+	}
+	content := res.Choices[0].Message.Content
+
+	if store != nil {
+		usage := Usage{
+			Job:              job,
+			Request:          request,
+			Model:            params.Model,
+			PromptTokens:     res.Usage.PromptTokens,
+			CompletionTokens: res.Usage.CompletionTokens,
+			Cost:             cost(params.Model, res.Usage.PromptTokens, res.Usage.CompletionTokens),
+			Timestamp:        time.Now(),
+		}
+		// the call has been paid for so failing to record it is reported but the answer is kept
+		var errs []error
+		if err := store.RecordUsage(ctx, usage); err != nil {
+			errs = append(errs, err)
+		}
+		if cacheable(res.Choices[0].FinishReason, content, call.Validate) {
+			if err := store.SaveResponse(ctx, cacheKey, params.Model, content); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if err := errors.Join(errs...); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+
+	return content, nil
+}
+
+// cacheable reports whether an answer can be cached. Truncated or unusable answers would be
+// replayed by every retry.
+func cacheable(finish openai.FinishReason, content string, validate func(string) error) bool {
+	if finish != openai.FinishReasonStop {
+		return false
+	}
+	return validate == nil || validate(content) == nil
+}
+
+// CacheKey identifies a request by its model, messages and response format. Image urls are
+// replaced by imageHashes, in order, when they are given.
+func CacheKey(params openai.ChatCompletionRequest, imageHashes []string) (string, error) {
+	messages := make([]openai.ChatCompletionMessage, len(params.Messages))
+	next := 0
+	for i, m := range params.Messages {
+		if len(m.MultiContent) > 0 {
+			parts := make([]openai.ChatMessagePart, len(m.MultiContent))
+			copy(parts, m.MultiContent)
+			for j, part := range parts {
+				if part.ImageURL != nil && next < len(imageHashes) {
+					parts[j].ImageURL = &openai.ChatMessageImageURL{URL: "sha256:" + imageHashes[next], Detail: part.ImageURL.Detail}
+					next++
+				}
+			}
+			m.MultiContent = parts
+		}
+		messages[i] = m
+	}
+
+	b, err := json.Marshal(struct {
+		Model          string
+		Messages       []openai.ChatCompletionMessage
+		ResponseFormat *openai.ChatCompletionResponseFormat
+	}{params.Model, messages, params.ResponseFormat})
+	if err != nil {
+		return "", fmt.Errorf("could not build cache key: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// checkBudget returns ErrBudgetExceeded when the day or month so far has used up its budget
+func checkBudget(ctx context.Context, store Store, budget Budget, now time.Time) error {
+	now = now.UTC()
+	periods := []struct {
+		name   string
+		since  time.Time
+		tokens int
+		spend  float64
+	}{
+		{"daily", time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC), budget.DailyTokens, budget.DailySpend},
+		{"monthly", time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), budget.MonthlyTokens, budget.MonthlySpend},
+	}
+	for _, p := range periods {
+		if p.tokens <= 0 && p.spend <= 0 {
+			continue
+		}
+		tokens, spend, err := store.UsageSince(ctx, p.since)
+		if err != nil {
+			return err
+		}
+		if p.tokens > 0 && tokens >= p.tokens {
+			return fmt.Errorf("%w: %d of %d %s tokens used", ErrBudgetExceeded, tokens, p.tokens, p.name)
+		}
+		if p.spend > 0 && spend >= p.spend {
+			return fmt.Errorf("%w: $%.2f of $%.2f %s spend used", ErrBudgetExceeded, spend, p.spend, p.name)
+		}
+	}
+	return nil
+}
 
-	return res.Choices[0].Message.Content, nil
+func cost(model string, promptTokens, completionTokens int) float64 {
+	price := Prices[model]
+	return (float64(promptTokens)*price.Prompt + float64(completionTokens)*price.Completion) / 1e6
 }
 
 type Score struct {
 	Rating int `json:"rating"`
 }
 
-func ChatRateBrand(brandName string) (int, error) {
+func ChatRateBrand(ctx context.Context, brandName string) (int, error) {
 	params := openai.ChatCompletionRequest{
 		Messages: []openai.ChatCompletionMessage{
 			{Role: "user", Content: fmt.Sprintf(`Give %s a rating out of 100 based on your knowledge of the sentiment of the brand`, brandName)},
@@ -67,7 +331,7 @@ func ChatRateBrand(brandName string) (int, error) {
 		},
 	}
 
-	ans, err := CreateChatCompletion(params)
+	ans, err := CreateChatCompletion(ctx, params, Call{Job: "rate_brands"})
 	if err != nil {
 		return 0, err
 	}
@@ -1,6 +1,7 @@
 package chat
 
 import (
+	"context"
 	"testing"
 
 	"github.com/joho/godotenv"
@@ -12,7 +13,7 @@ func TestGetBannerDescriptionsV2(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	rating, err := ChatRateBrand("Color Wow")
+	rating, err := ChatRateBrand(context.Background(), "Color Wow")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,170 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Store persists responses so an identical request is only paid for once, and records the
+// usage of every call so budgets can be enforced.
+type Store interface {
+	CachedResponse(ctx context.Context, key string) (content string, ok bool, err error)
+	SaveResponse(ctx context.Context, key, model, content string) error
+	RecordUsage(ctx context.Context, u Usage) error
+	// UsageSince totals the tokens and cost of calls made at or after since
+	UsageSince(ctx context.Context, since time.Time) (tokens int, cost float64, err error)
+}
+
+// Usage is what a single call consumed. Cached calls are recorded with no tokens.
+type Usage struct {
+	Job              string
+	Request          string
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	// Cost is in US dollars, estimated from Prices
+	Cost      float64
+	Cached    bool
+	Timestamp time.Time
+}
+
+// ReadOnlyStore answers from Store's cache and checks its budget without writing to it, for
+// dry runs that shouldn't leave answers or usage behind
+type ReadOnlyStore struct {
+	Store
+}
+
+func (ReadOnlyStore) SaveResponse(ctx context.Context, key, model, content string) error {
+	return nil
+}
+
+func (ReadOnlyStore) RecordUsage(ctx context.Context, u Usage) error {
+	return nil
+}
+
+// SQLStore keeps the cache and usage in the llm_cache and llm_usage tables. Timestamps are
+// stored in UTC so they compare correctly as text.
+type SQLStore struct {
+	db *sql.DB
+}
+
+func NewSQLStore(db *sql.DB) *SQLStore {
+	return &SQLStore{db: db}
+}
+
+func (s *SQLStore) CachedResponse(ctx context.Context, key string) (string, bool, error) {
+	var content string
+	err := s.db.QueryRowContext(ctx, `SELECT content FROM llm_cache WHERE key = ?`, key).Scan(&content)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("could not read llm cache: %w", err)
+	}
+	return content, true, nil
+}
+
+func (s *SQLStore) SaveResponse(ctx context.Context, key, model, content string) error {
+	if _, err := s.db.ExecContext(ctx, `
+	INSERT INTO
+		llm_cache (key, model, content, created_at)
+	VALUES
+		(?, ?, ?, ?)
+	ON CONFLICT(key) DO UPDATE SET
+		content = excluded.content,
+		created_at = excluded.created_at`,
+		key, model, content, time.Now().UTC(),
+	); err != nil {
+		return fmt.Errorf("could not save llm response: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) RecordUsage(ctx context.Context, u Usage) error {
+	if _, err := s.db.ExecContext(ctx, `
+	INSERT INTO
+		llm_usage (
+			job,
+			request,
+			model,
+			prompt_tokens,
+			completion_tokens,
+			cost,
+			cached,
+			timestamp
+		)
+	VALUES
+		(?, ?, ?, ?, ?, ?, ?, ?)`,
+		u.Job, u.Request, u.Model, u.PromptTokens, u.CompletionTokens, u.Cost, u.Cached, u.Timestamp.UTC(),
+	); err != nil {
+		return fmt.Errorf("could not record llm usage: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) UsageSince(ctx context.Context, since time.Time) (int, float64, error) {
+	var tokens int
+	var cost float64
+	if err := s.db.QueryRowContext(ctx, `
+	SELECT
+		COALESCE(SUM(prompt_tokens + completion_tokens), 0),
+		COALESCE(SUM(cost), 0)
+	FROM
+		llm_usage
+	WHERE
+		timestamp >= ?`,
+		since.UTC(),
+	).Scan(&tokens, &cost); err != nil {
+		return 0, 0, fmt.Errorf("could not total llm usage: %w", err)
+	}
+	return tokens, cost, nil
+}
+
+// JobSpend is the usage of one job over a period
+type JobSpend struct {
+	Job              string
+	Calls            int
+	CachedCalls      int
+	PromptTokens     int
+	CompletionTokens int
+	Cost             float64
+}
+
+// SpendByJob totals usage per job for calls made at or after since, most expensive first
+func (s *SQLStore) SpendByJob(ctx context.Context, since time.Time) ([]JobSpend, error) {
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT
+		job,
+		COUNT(id),
+		COALESCE(SUM(cached), 0),
+		COALESCE(SUM(prompt_tokens), 0),
+		COALESCE(SUM(completion_tokens), 0),
+		COALESCE(SUM(cost), 0)
+	FROM
+		llm_usage
+	WHERE
+		timestamp >= ?
+	GROUP BY
+		job
+	ORDER BY
+		SUM(cost) DESC`,
+		since.UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not query llm spend: %w", err)
+	}
+	defer rows.Close()
+
+	var spend []JobSpend
+	for rows.Next() {
+		var j JobSpend
+		if err := rows.Scan(&j.Job, &j.Calls, &j.CachedCalls, &j.PromptTokens, &j.CompletionTokens, &j.Cost); err != nil {
+			return nil, err
+		}
+		spend = append(spend, j)
+	}
+	return spend, rows.Err()
+}
@@ -0,0 +1,64 @@
+package fetch
+
+import (
+	"container/list"
+	"net/http"
+)
+
+type cachedResponse struct {
+	url          string
+	etag         string
+	lastModified string
+	header       http.Header
+	body         []byte
+}
+
+// responseCache keeps the most recently used responses up to maxBytes of bodies.
+// It isn't safe for concurrent use, the fetcher holds its mu around it.
+type responseCache struct {
+	maxBytes int64
+	size     int64
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+func newResponseCache(maxBytes int64) *responseCache {
+	return &responseCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+func (c *responseCache) get(url string) (cachedResponse, bool) {
+	e, ok := c.entries[url]
+	if !ok {
+		return cachedResponse{}, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(cachedResponse), true
+}
+
+// put stores r, evicting the least recently used responses to make room. A body larger
+// than the whole cache isn't kept and drops any older copy of the url.
+func (c *responseCache) put(r cachedResponse) {
+	c.remove(r.url)
+	if int64(len(r.body)) > c.maxBytes {
+		return
+	}
+	c.entries[r.url] = c.order.PushFront(r)
+	c.size += int64(len(r.body))
+	for c.size > c.maxBytes {
+		c.remove(c.order.Back().Value.(cachedResponse).url)
+	}
+}
+
+func (c *responseCache) remove(url string) {
+	e, ok := c.entries[url]
+	if !ok {
+		return
+	}
+	c.order.Remove(e)
+	delete(c.entries, url)
+	c.size -= int64(len(e.Value.(cachedResponse).body))
+}
@@ -0,0 +1,315 @@
+// Package fetch is a polite http client for scraping retailer sites. It identifies itself
+// with a configurable user agent, honours robots.txt, spaces out requests to the same host,
+// retries with exponential backoff on 429 and 5xx responses and makes conditional requests
+// so unchanged pages aren't downloaded twice.
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrDisallowed is returned when robots.txt asks us not to fetch a url
+var ErrDisallowed = errors.New("fetch disallowed by robots.txt")
+
+type Config struct {
+	UserAgent string
+	// Timeout bounds a single attempt including reading the body
+	Timeout time.Duration
+	// HostInterval is the minimum gap between requests to the same host. A larger
+	// Crawl-delay in robots.txt takes precedence.
+	HostInterval time.Duration
+	MaxRetries   int
+	// BaseBackoff is doubled after every failed attempt up to MaxBackoff
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// MaxBodyBytes caps how much of a response is read
+	MaxBodyBytes int64
+	// MaxCacheBytes caps the bodies kept for conditional requests, the least recently
+	// fetched are dropped first
+	MaxCacheBytes int64
+}
+
+func DefaultConfig() Config {
+	return Config{
+		UserAgent:     "BeautyBargainsBot/1.0 (+https://beautybargains.ie)",
+		Timeout:       20 * time.Second,
+		HostInterval:  2 * time.Second,
+		MaxRetries:    3,
+		BaseBackoff:   time.Second,
+		MaxBackoff:    30 * time.Second,
+		MaxBodyBytes:  10 << 20,
+		MaxCacheBytes: 64 << 20,
+	}
+}
+
+// Response is the body of a successful fetch. NotModified is set when the server answered a
+// conditional request with 304 and Body is the copy we kept from the previous fetch.
+type Response struct {
+	URL         string
+	StatusCode  int
+	Header      http.Header
+	Body        []byte
+	NotModified bool
+}
+
+type Fetcher struct {
+	cfg    Config
+	client *http.Client
+
+	mu    sync.Mutex
+	hosts map[string]*host
+	cache *responseCache
+}
+
+type host struct {
+	// held while waiting for our turn so requests to a host are serialised
+	mu          sync.Mutex
+	lastRequest time.Time
+
+	robots        *robots
+	robotsExpires time.Time
+}
+
+func New(cfg Config) *Fetcher {
+	return &Fetcher{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		hosts:  map[string]*host{},
+		cache:  newResponseCache(cfg.MaxCacheBytes),
+	}
+}
+
+var (
+	defaultMu      sync.RWMutex
+	defaultFetcher = New(DefaultConfig())
+)
+
+// Configure replaces the fetcher used by the package level Get. Zero values keep the defaults.
+func Configure(cfg Config) {
+	d := DefaultConfig()
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = d.UserAgent
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = d.Timeout
+	}
+	if cfg.HostInterval <= 0 {
+		cfg.HostInterval = d.HostInterval
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = d.MaxRetries
+	}
+	if cfg.BaseBackoff <= 0 {
+		cfg.BaseBackoff = d.BaseBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = d.MaxBackoff
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = d.MaxBodyBytes
+	}
+	if cfg.MaxCacheBytes <= 0 {
+		cfg.MaxCacheBytes = d.MaxCacheBytes
+	}
+	defaultMu.Lock()
+	defaultFetcher = New(cfg)
+	defaultMu.Unlock()
+}
+
+// Default returns the fetcher used by the package level Get
+func Default() *Fetcher {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultFetcher
+}
+
+// Get fetches rawURL with the default fetcher
+func Get(ctx context.Context, rawURL string) (*Response, error) {
+	return Default().Get(ctx, rawURL)
+}
+
+// UserAgent is the user agent sent with every request
+func (f *Fetcher) UserAgent() string {
+	return f.cfg.UserAgent
+}
+
+// Get fetches rawURL if robots.txt allows it, waiting for the host to be free and retrying
+// transient failures.
+func (f *Fetcher) Get(ctx context.Context, rawURL string) (*Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url %s: %w", rawURL, err)
+	}
+
+	h := f.host(u.Host)
+	rules := f.robotsFor(ctx, u, h)
+	if !rules.allowed(u.RequestURI()) {
+		return nil, fmt.Errorf("%w: %s", ErrDisallowed, rawURL)
+	}
+
+	interval := f.cfg.HostInterval
+	if rules != nil && rules.crawlDelay > interval {
+		interval = rules.crawlDelay
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= f.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			if err := sleep(ctx, f.backoff(attempt, lastErr)); err != nil {
+				return nil, err
+			}
+		}
+
+		res, retry, err := f.do(ctx, h, interval, rawURL)
+		if err == nil {
+			return res, nil
+		}
+		lastErr = err
+		if !retry {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// statusError is returned for unsuccessful responses. RetryAfter is set from the Retry-After header.
+type statusError struct {
+	URL        string
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status %d fetching %s", e.StatusCode, e.URL)
+}
+
+// do makes a single attempt and reports whether a failure is worth retrying
+func (f *Fetcher) do(ctx context.Context, h *host, interval time.Duration, rawURL string) (*Response, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("User-Agent", f.cfg.UserAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+
+	f.mu.Lock()
+	cached, hasCached := f.cache.get(rawURL)
+	f.mu.Unlock()
+	if hasCached {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+
+	if err := f.wait(ctx, h, interval); err != nil {
+		return nil, false, err
+	}
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		// network errors and timeouts are retried unless we were cancelled
+		return nil, ctx.Err() == nil, fmt.Errorf("error fetching %s: %w", rawURL, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified && hasCached {
+		return &Response{URL: rawURL, StatusCode: res.StatusCode, Header: cached.header, Body: cached.body, NotModified: true}, false, nil
+	}
+
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500 {
+		io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+		return nil, true, &statusError{URL: rawURL, StatusCode: res.StatusCode, RetryAfter: parseRetryAfter(res.Header.Get("Retry-After"))}
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, false, &statusError{URL: rawURL, StatusCode: res.StatusCode}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, f.cfg.MaxBodyBytes))
+	if err != nil {
+		return nil, ctx.Err() == nil, fmt.Errorf("error reading %s: %w", rawURL, err)
+	}
+
+	// images are downloaded once and archived so keeping them would only fill the cache
+	etag, lastModified := res.Header.Get("ETag"), res.Header.Get("Last-Modified")
+	if (etag != "" || lastModified != "") && !strings.HasPrefix(res.Header.Get("Content-Type"), "image/") {
+		f.mu.Lock()
+		f.cache.put(cachedResponse{url: rawURL, etag: etag, lastModified: lastModified, header: res.Header, body: body})
+		f.mu.Unlock()
+	}
+
+	return &Response{URL: rawURL, StatusCode: res.StatusCode, Header: res.Header, Body: body}, false, nil
+}
+
+func (f *Fetcher) host(name string) *host {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	h, ok := f.hosts[name]
+	if !ok {
+		h = &host{}
+		f.hosts[name] = h
+	}
+	return h
+}
+
+// wait blocks until at least interval has passed since the last request to h
+func (f *Fetcher) wait(ctx context.Context, h *host, interval time.Duration) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if d := time.Until(h.lastRequest.Add(interval)); d > 0 {
+		if err := sleep(ctx, d); err != nil {
+			return err
+		}
+	}
+	h.lastRequest = time.Now()
+	return nil
+}
+
+func (f *Fetcher) backoff(attempt int, lastErr error) time.Duration {
+	var se *statusError
+	if errors.As(lastErr, &se) && se.RetryAfter > 0 {
+		return min(se.RetryAfter, f.cfg.MaxBackoff)
+	}
+	d := f.cfg.BaseBackoff << (attempt - 1)
+	if d <= 0 || d > f.cfg.MaxBackoff {
+		d = f.cfg.MaxBackoff
+	}
+	// up to 25% jitter so retries from several workers don't line up
+	return d + time.Duration(rand.Int63n(int64(d)/4+1))
+}
+
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,104 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testFetcher() *Fetcher {
+	cfg := DefaultConfig()
+	cfg.HostInterval = time.Millisecond
+	cfg.BaseBackoff = time.Millisecond
+	cfg.MaxBackoff = 10 * time.Millisecond
+	return New(cfg)
+}
+
+func TestGetRetriesAndConditionalRequests(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+		case "/":
+			attempts++
+			if r.Header.Get("User-Agent") != DefaultConfig().UserAgent {
+				t.Errorf("unexpected user agent %q", r.Header.Get("User-Agent"))
+			}
+			if attempts == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+			w.Write([]byte("<html>banners</html>"))
+		}
+	}))
+	defer srv.Close()
+
+	f := testFetcher()
+	res, err := f.Get(context.Background(), srv.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 || string(res.Body) != "<html>banners</html>" {
+		t.Fatalf("expected a retry after the 503, attempts %d body %q", attempts, res.Body)
+	}
+
+	res, err = f.Get(context.Background(), srv.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.NotModified || string(res.Body) != "<html>banners</html>" {
+		t.Errorf("expected the cached body from a 304, got %+v", res)
+	}
+
+	if _, err := f.Get(context.Background(), srv.URL+"/private"); !errors.Is(err, ErrDisallowed) {
+		t.Errorf("expected robots.txt to disallow /private got %v", err)
+	}
+}
+
+func TestCacheEvictsAndSkipsImages(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != "" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		if r.URL.Path == "/banner.jpg" {
+			w.Header().Set("Content-Type", "image/jpeg")
+		}
+		w.Write([]byte("0123456789"))
+	}))
+	defer srv.Close()
+
+	cfg := DefaultConfig()
+	cfg.HostInterval = time.Millisecond
+	cfg.MaxCacheBytes = 25
+	f := New(cfg)
+	for _, path := range []string{"/a", "/b", "/a", "/c", "/banner.jpg"} {
+		if _, err := f.Get(context.Background(), srv.URL+path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// /a was fetched again after /b so /b made room for /c
+	for _, tt := range []struct {
+		path   string
+		cached bool
+	}{{"/a", true}, {"/c", true}, {"/banner.jpg", false}, {"/b", false}} {
+		res, err := f.Get(context.Background(), srv.URL+tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.NotModified != tt.cached {
+			t.Errorf("%s: expected cached %v, got %v", tt.path, tt.cached, res.NotModified)
+		}
+	}
+}
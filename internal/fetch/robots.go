@@ -0,0 +1,186 @@
+package fetch
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	robotsTTL      = 24 * time.Hour
+	robotsRetryTTL = time.Hour
+)
+
+type robotsRule struct {
+	allow   bool
+	length  int
+	pattern *regexp.Regexp
+}
+
+// robots holds the rules from the group of a robots.txt that applies to us
+type robots struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// allowed applies the longest matching rule, allow wins a tie. No match means allowed.
+func (r *robots) allowed(path string) bool {
+	if r == nil {
+		return true
+	}
+	best, allow := -1, true
+	for _, rule := range r.rules {
+		if rule.length < best || !rule.pattern.MatchString(path) {
+			continue
+		}
+		if rule.length > best || rule.allow {
+			best, allow = rule.length, rule.allow
+		}
+	}
+	return allow
+}
+
+// robotsFor returns the cached rules for u's host, fetching robots.txt when they are missing or stale
+func (f *Fetcher) robotsFor(ctx context.Context, u *url.URL, h *host) *robots {
+	f.mu.Lock()
+	rules, expires := h.robots, h.robotsExpires
+	f.mu.Unlock()
+	if time.Now().Before(expires) {
+		return rules
+	}
+
+	robotsURL := u.Scheme + "://" + u.Host + "/robots.txt"
+	rules, ttl := f.fetchRobots(ctx, h, robotsURL)
+
+	f.mu.Lock()
+	h.robots, h.robotsExpires = rules, time.Now().Add(ttl)
+	f.mu.Unlock()
+	return rules
+}
+
+// fetchRobots downloads and parses robots.txt. A missing file allows everything, as does a
+// failure to fetch it, though failures are retried sooner.
+func (f *Fetcher) fetchRobots(ctx context.Context, h *host, robotsURL string) (*robots, time.Duration) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, robotsURL, nil)
+	if err != nil {
+		return nil, robotsRetryTTL
+	}
+	req.Header.Set("User-Agent", f.cfg.UserAgent)
+
+	if err := f.wait(ctx, h, f.cfg.HostInterval); err != nil {
+		return nil, robotsRetryTTL
+	}
+	res, err := f.client.Do(req)
+	if err != nil {
+		return nil, robotsRetryTTL
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 400 && res.StatusCode < 500 {
+		return nil, robotsTTL
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, robotsRetryTTL
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 512<<10))
+	if err != nil {
+		return nil, robotsRetryTTL
+	}
+	return parseRobots(body, f.cfg.UserAgent), robotsTTL
+}
+
+// parseRobots returns the rules from the most specific group matching userAgent, falling back to *
+func parseRobots(body []byte, userAgent string) *robots {
+	token := strings.ToLower(userAgent)
+	if i := strings.IndexAny(token, "/ "); i > 0 {
+		token = token[:i]
+	}
+
+	type group struct {
+		agents []string
+		robots robots
+	}
+	var groups []*group
+	var current *group
+	lastWasAgent := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if current == nil || !lastWasAgent {
+				current = &group{}
+				groups = append(groups, current)
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+			lastWasAgent = true
+			continue
+		case "allow", "disallow":
+			// an empty disallow allows everything so it adds no rule
+			if current != nil && value != "" {
+				current.robots.rules = append(current.robots.rules, robotsRule{
+					allow:   key == "allow",
+					length:  len(value),
+					pattern: robotsPattern(value),
+				})
+			}
+		case "crawl-delay":
+			if current != nil {
+				if secs, err := strconv.ParseFloat(value, 64); err == nil && secs > 0 {
+					current.robots.crawlDelay = time.Duration(secs * float64(time.Second))
+				}
+			}
+		}
+		lastWasAgent = false
+	}
+
+	var wildcard *robots
+	for _, g := range groups {
+		for _, agent := range g.agents {
+			if agent == "*" {
+				if wildcard == nil {
+					wildcard = &g.robots
+				}
+				continue
+			}
+			if token != "" && strings.Contains(token, agent) {
+				return &g.robots
+			}
+		}
+	}
+	return wildcard
+}
+
+// robotsPattern converts a robots.txt path, which may use * and a trailing $, to an anchored regexp
+func robotsPattern(path string) *regexp.Regexp {
+	anchored := strings.HasSuffix(path, "$")
+	path = strings.TrimSuffix(path, "$")
+	parts := strings.Split(path, "*")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
@@ -0,0 +1,51 @@
+package fetch
+
+import (
+	"testing"
+	"time"
+)
+
+const robotsTxt = `
+# comments are ignored
+User-agent: *
+Disallow: /checkout
+Disallow: /*?sort=
+Allow: /checkout/help
+
+User-agent: BeautyBargainsBot
+User-agent: otherbot
+Disallow: /search
+Crawl-delay: 5
+`
+
+func TestParseRobots(t *testing.T) {
+	ours := parseRobots([]byte(robotsTxt), "BeautyBargainsBot/1.0 (+https://beautybargains.ie)")
+	if ours.crawlDelay != 5*time.Second {
+		t.Errorf("expected crawl delay of 5s got %s", ours.crawlDelay)
+	}
+	if ours.allowed("/search?q=serum") {
+		t.Error("expected /search to be disallowed for our group")
+	}
+	if !ours.allowed("/checkout") {
+		t.Error("expected the wildcard group to be ignored when a specific group matches")
+	}
+
+	other := parseRobots([]byte(robotsTxt), "SomeCrawler/2.0")
+	cases := map[string]bool{
+		"/":                   true,
+		"/checkout":           false,
+		"/checkout/help":      true,
+		"/skincare?sort=desc": false,
+		"/skincare":           true,
+	}
+	for path, want := range cases {
+		if got := other.allowed(path); got != want {
+			t.Errorf("allowed(%q) = %v want %v", path, got, want)
+		}
+	}
+
+	var none *robots
+	if !none.allowed("/anything") {
+		t.Error("expected a missing robots.txt to allow everything")
+	}
+}
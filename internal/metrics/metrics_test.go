@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	c := NewCounter("test_banners_total", "Banners seen.", "website", "status")
+	c.Inc("Millies", "new")
+	c.Add(2, "Millies", "new")
+	c.Inc(`Skin "Shop"`, "skipped")
+
+	h := NewHistogram("test_duration_seconds", "Durations.", []float64{1, 0.1}, "job")
+	h.Observe(0.05, "hashtags")
+	h.Observe(0.5, "hashtags")
+
+	var buf bytes.Buffer
+	WriteTo(&buf)
+	out := buf.String()
+
+	expected := []string{
+		"# TYPE test_banners_total counter",
+		`test_banners_total{website="Millies",status="new"} 3`,
+		`test_banners_total{website="Skin \"Shop\"",status="skipped"} 1`,
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{job="hashtags",le="0.1"} 1`,
+		`test_duration_seconds_bucket{job="hashtags",le="1"} 2`,
+		`test_duration_seconds_bucket{job="hashtags",le="+Inf"} 2`,
+		`test_duration_seconds_sum{job="hashtags"} 0.55`,
+		`test_duration_seconds_count{job="hashtags"} 2`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("expected output to contain %q\n%s", line, out)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+-- banners waiting for the llm, see cmd/server/analysis.go. Rows are removed once the post is saved.
+CREATE TABLE IF NOT EXISTS analysis_queue (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    website_id INTEGER NOT NULL,
+    src_url TEXT NOT NULL UNIQUE,
+    supporting_text TEXT NOT NULL DEFAULT '',
+    href TEXT NOT NULL DEFAULT '',
+    image_hash TEXT NOT NULL DEFAULT '',
+    image_ext TEXT NOT NULL DEFAULT '',
+    image_width INTEGER NOT NULL DEFAULT 0,
+    image_height INTEGER NOT NULL DEFAULT 0,
+    image_phash INTEGER NOT NULL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'dead')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt TIMESTAMP NOT NULL,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (website_id) REFERENCES websites(website_id)
+);
+
+CREATE INDEX IF NOT EXISTS analysis_queue_due ON analysis_queue (status, next_attempt);
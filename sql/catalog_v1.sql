@@ -0,0 +1,19 @@
+-- products matched across retailers for price comparison, see cmd/server/matching.go
+-- size is normalised, e.g. 30ml, and '' when the name has none
+CREATE TABLE IF NOT EXISTS catalog_products (
+    id INTEGER PRIMARY KEY,
+    slug TEXT NOT NULL UNIQUE,
+    brand_id INTEGER,
+    name TEXT NOT NULL,
+    size TEXT NOT NULL DEFAULT '',
+    gtin TEXT UNIQUE,
+    image TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (brand_id) REFERENCES brands(id)
+);
+
+CREATE INDEX IF NOT EXISTS catalog_products_brand ON catalog_products (brand_id, size);
+
+ALTER TABLE product_offers ADD COLUMN catalog_id INTEGER REFERENCES catalog_products(id);
+
+CREATE INDEX IF NOT EXISTS product_offers_catalog ON product_offers (catalog_id);
@@ -0,0 +1,38 @@
+-- outbound click tracking, see cmd/server/clicks.go
+-- every click through /go, coupon_id is set for clicks on a coupon
+CREATE TABLE IF NOT EXISTS clicks (
+    id INTEGER PRIMARY KEY,
+    website_id INTEGER NOT NULL,
+    post_id INTEGER REFERENCES posts(id),
+    coupon_id INTEGER REFERENCES coupon_codes(id),
+    clicked_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS clicks_clicked_at ON clicks (clicked_at);
+
+-- added to a retailer's links at redirect time, edited at /admin/clicks
+CREATE TABLE IF NOT EXISTS affiliate_templates (
+    website_id INTEGER PRIMARY KEY,
+    template TEXT NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- posts saved before links were stored take the href of a later sighting of their banner
+UPDATE
+    posts
+SET
+    link = (
+        SELECT
+            d.href
+        FROM
+            banner_duplicates d
+        WHERE
+            d.post_id = posts.id
+            AND d.href != ''
+        ORDER BY
+            d.timestamp DESC
+        LIMIT
+            1
+    )
+WHERE
+    link IS NULL OR link = '';
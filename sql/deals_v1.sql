@@ -0,0 +1,122 @@
+-- structured deal attributes, see cmd/server/deals.go. Existing posts are left empty.
+ALTER TABLE posts ADD COLUMN percent_off REAL;
+ALTER TABLE posts ADD COLUMN amount_off REAL;
+ALTER TABLE posts ADD COLUMN min_spend REAL;
+ALTER TABLE posts ADD COLUMN free_gift INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE posts ADD COLUMN free_shipping INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE posts ADD COLUMN bogof INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE posts ADD COLUMN starts_at TIMESTAMP;
+ALTER TABLE posts ADD COLUMN ends_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS posts_percent_off ON posts (percent_off);
+CREATE INDEX IF NOT EXISTS posts_ends_at ON posts (ends_at);
+
+-- the next version of extract_offers asks for the deal too and replaces the active one
+INSERT INTO prompts (job, version, template, schema, notes, active, created_at)
+SELECT
+    'extract_offers',
+    COALESCE(MAX(version), 0) + 1,
+    'You are a joyful and excited social media manager for a health and beauty magazine with the goal of motivating people to take advantage of today''s available beauty offers.
+Tell your audience what the beauty retailer {{.Website}} is advertising today and highlight any coupons if available. Keep your response short, playful and suitable for a tweet or instagram caption.
+Do not acknowledge that you are AI.
+Also fill in the deal with the discount as structured fields, using null or false for anything the banner doesn''t say.
+{{- if .SupportingText}}
+For some additional context regarding this promotion please see the quoted text ''{{.SupportingText}}''
+{{- end}}',
+    '{
+  "type": "object",
+  "properties": {
+    "description": {
+      "type": "string",
+      "description": "Text description of the offer"
+    },
+    "coupon_codes": {
+      "type": "array",
+      "description": "Any coupon codes found in the resource",
+      "items": {
+        "type": "object",
+        "properties": {
+          "code": {
+            "type": "string",
+            "description": "The coupon code"
+          },
+          "description": {
+            "type": "string",
+            "description": "Description of the coupon code"
+          },
+          "valid_until": {
+            "type": ["string", "null"],
+            "format": "date-time",
+            "description": "Expiration date of the coupon, if any, in rfc3339 time format"
+          }
+        },
+        "required": ["code", "description"]
+      }
+    },
+    "categories": {
+      "type": "array",
+      "description": "Typical health and beauty categories",
+      "items": {
+        "type": "string"
+      }
+    },
+    "brands": {
+      "type": "array",
+      "description": "Any brands mentioned",
+      "items": {
+        "type": "string"
+      }
+    },
+    "deal": {
+      "type": "object",
+      "description": "The discount as structured fields, null or false for anything the banner doesn''t say",
+      "properties": {
+        "percent_off": {
+          "type": ["number", "null"],
+          "description": "Percentage off, the largest one when it is up to a percentage"
+        },
+        "amount_off": {
+          "type": ["number", "null"],
+          "description": "Amount off in euro"
+        },
+        "min_spend": {
+          "type": ["number", "null"],
+          "description": "How much has to be spent in euro for the offer to apply"
+        },
+        "free_gift": {
+          "type": "boolean",
+          "description": "A free gift comes with a purchase"
+        },
+        "free_shipping": {
+          "type": "boolean",
+          "description": "Delivery is free"
+        },
+        "bogof": {
+          "type": "boolean",
+          "description": "Buy one get one free"
+        },
+        "starts_at": {
+          "type": ["string", "null"],
+          "format": "date-time",
+          "description": "When the offer starts, if stated, in rfc3339 time format"
+        },
+        "ends_at": {
+          "type": ["string", "null"],
+          "format": "date-time",
+          "description": "When the offer ends, if stated, in rfc3339 time format"
+        }
+      },
+      "required": ["percent_off", "amount_off", "min_spend", "free_gift", "free_shipping", "bogof"]
+    }
+  },
+  "required": ["description", "coupon_codes", "categories", "brands", "deal"]
+}',
+    'Structured deal attributes',
+    0,
+    CURRENT_TIMESTAMP
+FROM
+    prompts
+WHERE
+    job = 'extract_offers';
+
+UPDATE prompts SET active = (id = last_insert_rowid()) WHERE job = 'extract_offers';
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS error_groups (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    fingerprint TEXT NOT NULL UNIQUE,
+    message TEXT NOT NULL,
+    count INTEGER NOT NULL DEFAULT 0,
+    first_seen DATETIME NOT NULL,
+    last_seen DATETIME NOT NULL
+);
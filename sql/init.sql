@@ -14,6 +14,34 @@ CREATE TABLE posts (
     timestamp TIMESTAMP,
     author_id INTEGER,
     score FLOAT64 DEFAULT 0,
+    image_hash TEXT,
+    image_ext TEXT,
+    image_width INTEGER,
+    image_height INTEGER,
+    image_phash INTEGER,
+    first_seen TIMESTAMP,
+    last_seen TIMESTAMP,
+    active INTEGER NOT NULL DEFAULT 0,
+    review_reasons TEXT,
+    status TEXT NOT NULL DEFAULT 'approved' CHECK (status IN ('pending', 'approved', 'rejected')),
+    supporting_text TEXT,
+    reviewed_at TIMESTAMP,
+    prompt_version INTEGER,
+    model TEXT,
+    machine_extracted INTEGER NOT NULL DEFAULT 0,
+    percent_off REAL,
+    amount_off REAL,
+    min_spend REAL,
+    free_gift INTEGER NOT NULL DEFAULT 0,
+    free_shipping INTEGER NOT NULL DEFAULT 0,
+    bogof INTEGER NOT NULL DEFAULT 0,
+    starts_at TIMESTAMP,
+    ends_at TIMESTAMP,
+    product_id INTEGER REFERENCES products(id),
+    lowest_90_days INTEGER NOT NULL DEFAULT 0,
+    all_time_low INTEGER NOT NULL DEFAULT 0,
+    clicks INTEGER NOT NULL DEFAULT 0,
+    scored_at TIMESTAMP,
     FOREIGN KEY (website_id) REFERENCES websites(website_id)
 );
 
@@ -69,5 +97,465 @@ CREATE TABLE coupon_codes (
     description TEXT NOT NULL,
     valid_until DATETIME,
     first_seen DATETIME,
-    website_id INTEGER
-);
\ No newline at end of file
+    website_id INTEGER,
+    post_id INTEGER REFERENCES posts(id)
+);
+CREATE TABLE error_groups (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    fingerprint TEXT NOT NULL UNIQUE,
+    message TEXT NOT NULL,
+    count INTEGER NOT NULL DEFAULT 0,
+    first_seen DATETIME NOT NULL,
+    last_seen DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS banner_duplicates (
+    id INTEGER PRIMARY KEY,
+    post_id INTEGER NOT NULL,
+    src_url TEXT NOT NULL,
+    href TEXT,
+    distance INTEGER NOT NULL,
+    timestamp TIMESTAMP NOT NULL,
+    FOREIGN KEY (post_id) REFERENCES posts(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_banner_duplicates_src_url ON banner_duplicates(src_url);
+
+CREATE TABLE IF NOT EXISTS llm_cache (
+    key TEXT PRIMARY KEY,
+    model TEXT NOT NULL,
+    content TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS llm_usage (
+    id INTEGER PRIMARY KEY,
+    job TEXT NOT NULL,
+    request TEXT NOT NULL,
+    model TEXT NOT NULL,
+    prompt_tokens INTEGER NOT NULL DEFAULT 0,
+    completion_tokens INTEGER NOT NULL DEFAULT 0,
+    cost FLOAT64 NOT NULL DEFAULT 0,
+    cached INTEGER NOT NULL DEFAULT 0,
+    timestamp TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_llm_usage_timestamp ON llm_usage(timestamp);
+
+-- alias is matched ignoring case, spacing and punctuation
+CREATE TABLE IF NOT EXISTS aliases (
+    kind TEXT NOT NULL CHECK (kind IN ('brand', 'category')),
+    alias TEXT NOT NULL,
+    canonical TEXT NOT NULL,
+    PRIMARY KEY (kind, alias)
+);
+
+INSERT OR IGNORE INTO aliases (kind, alias, canonical) VALUES
+    ('category', 'skin care', 'Skincare'),
+    ('category', 'skin', 'Skincare'),
+    ('category', 'hair care', 'Haircare'),
+    ('category', 'hair', 'Haircare'),
+    ('category', 'make up', 'Makeup'),
+    ('category', 'cosmetics', 'Makeup'),
+    ('category', 'perfume', 'Fragrance'),
+    ('category', 'perfumes', 'Fragrance'),
+    ('category', 'fragrances', 'Fragrance'),
+    ('category', 'body care', 'Bodycare'),
+    ('category', 'nail care', 'Nails'),
+    ('category', 'nail', 'Nails'),
+    ('category', 'suncare', 'Sun Care'),
+    ('category', 'spf', 'Sun Care'),
+    ('brand', 'loreal', 'L''Oréal Paris'),
+    ('brand', 'loreal paris', 'L''Oréal Paris'),
+    ('brand', 'esteelauder', 'Estée Lauder'),
+    ('brand', 'la roche posay', 'La Roche-Posay'),
+    ('brand', 'ysl', 'Yves Saint Laurent');
+CREATE INDEX posts_status_timestamp ON posts (status, timestamp);
+CREATE TABLE prompts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    job TEXT NOT NULL,
+    version INTEGER NOT NULL,
+    template TEXT NOT NULL,
+    schema TEXT NOT NULL,
+    -- model overrides OPENAI_MODEL when set
+    model TEXT,
+    notes TEXT NOT NULL DEFAULT '',
+    active INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE (job, version)
+);
+
+-- version 1 is the prompt that used to be hard coded in analyzeOffer
+INSERT INTO prompts (job, version, template, schema, notes, active, created_at) VALUES (
+    'extract_offers',
+    1,
+    'You are a joyful and excited social media manager for a health and beauty magazine with the goal of motivating people to take advantage of today''s available beauty offers.
+Tell your audience what the beauty retailer {{.Website}} is advertising today and highlight any coupons if available. Keep your response short, playful and suitable for a tweet or instagram caption.
+Do not acknowledge that you are AI.
+{{- if .SupportingText}}
+For some additional context regarding this promotion please see the quoted text ''{{.SupportingText}}''
+{{- end}}',
+    '{
+  "type": "object",
+  "properties": {
+    "description": {
+      "type": "string",
+      "description": "Text description of the offer"
+    },
+    "coupon_codes": {
+      "type": "array",
+      "description": "Any coupon codes found in the resource",
+      "items": {
+        "type": "object",
+        "properties": {
+          "code": {
+            "type": "string",
+            "description": "The coupon code"
+          },
+          "description": {
+            "type": "string",
+            "description": "Description of the coupon code"
+          },
+          "valid_until": {
+            "type": ["string", "null"],
+            "format": "date-time",
+            "description": "Expiration date of the coupon, if any, in rfc3339 time format"
+          }
+        },
+        "required": ["code", "description"]
+      }
+    },
+    "categories": {
+      "type": "array",
+      "description": "Typical health and beauty categories",
+      "items": {
+        "type": "string"
+      }
+    },
+    "brands": {
+      "type": "array",
+      "description": "Any brands mentioned",
+      "items": {
+        "type": "string"
+      }
+    }
+  },
+  "required": ["description", "coupon_codes", "categories", "brands"]
+}',
+    'Original prompt',
+    0,
+    CURRENT_TIMESTAMP
+);
+
+INSERT INTO prompts (job, version, template, schema, notes, active, created_at) VALUES (
+    'extract_offers',
+    2,
+    'You are a joyful and excited social media manager for a health and beauty magazine with the goal of motivating people to take advantage of today''s available beauty offers.
+Tell your audience what the beauty retailer {{.Website}} is advertising today and highlight any coupons if available. Keep your response short, playful and suitable for a tweet or instagram caption.
+Do not acknowledge that you are AI.
+Also fill in the deal with the discount as structured fields, using null or false for anything the banner doesn''t say.
+{{- if .SupportingText}}
+For some additional context regarding this promotion please see the quoted text ''{{.SupportingText}}''
+{{- end}}',
+    '{
+  "type": "object",
+  "properties": {
+    "description": {
+      "type": "string",
+      "description": "Text description of the offer"
+    },
+    "coupon_codes": {
+      "type": "array",
+      "description": "Any coupon codes found in the resource",
+      "items": {
+        "type": "object",
+        "properties": {
+          "code": {
+            "type": "string",
+            "description": "The coupon code"
+          },
+          "description": {
+            "type": "string",
+            "description": "Description of the coupon code"
+          },
+          "valid_until": {
+            "type": ["string", "null"],
+            "format": "date-time",
+            "description": "Expiration date of the coupon, if any, in rfc3339 time format"
+          }
+        },
+        "required": ["code", "description"]
+      }
+    },
+    "categories": {
+      "type": "array",
+      "description": "Typical health and beauty categories",
+      "items": {
+        "type": "string"
+      }
+    },
+    "brands": {
+      "type": "array",
+      "description": "Any brands mentioned",
+      "items": {
+        "type": "string"
+      }
+    },
+    "deal": {
+      "type": "object",
+      "description": "The discount as structured fields, null or false for anything the banner doesn''t say",
+      "properties": {
+        "percent_off": {
+          "type": ["number", "null"],
+          "description": "Percentage off, the largest one when it is up to a percentage"
+        },
+        "amount_off": {
+          "type": ["number", "null"],
+          "description": "Amount off in euro"
+        },
+        "min_spend": {
+          "type": ["number", "null"],
+          "description": "How much has to be spent in euro for the offer to apply"
+        },
+        "free_gift": {
+          "type": "boolean",
+          "description": "A free gift comes with a purchase"
+        },
+        "free_shipping": {
+          "type": "boolean",
+          "description": "Delivery is free"
+        },
+        "bogof": {
+          "type": "boolean",
+          "description": "Buy one get one free"
+        },
+        "starts_at": {
+          "type": ["string", "null"],
+          "format": "date-time",
+          "description": "When the offer starts, if stated, in rfc3339 time format"
+        },
+        "ends_at": {
+          "type": ["string", "null"],
+          "format": "date-time",
+          "description": "When the offer ends, if stated, in rfc3339 time format"
+        }
+      },
+      "required": ["percent_off", "amount_off", "min_spend", "free_gift", "free_shipping", "bogof"]
+    }
+  },
+  "required": ["description", "coupon_codes", "categories", "brands", "deal"]
+}',
+    'Structured deal attributes',
+    1,
+    CURRENT_TIMESTAMP
+);
+CREATE TABLE analysis_queue (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    website_id INTEGER NOT NULL,
+    src_url TEXT NOT NULL UNIQUE,
+    supporting_text TEXT NOT NULL DEFAULT '',
+    href TEXT NOT NULL DEFAULT '',
+    image_hash TEXT NOT NULL DEFAULT '',
+    image_ext TEXT NOT NULL DEFAULT '',
+    image_width INTEGER NOT NULL DEFAULT 0,
+    image_height INTEGER NOT NULL DEFAULT 0,
+    image_phash INTEGER NOT NULL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'dead')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt TIMESTAMP NOT NULL,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (website_id) REFERENCES websites(website_id)
+);
+
+CREATE INDEX analysis_queue_due ON analysis_queue (status, next_attempt);
+CREATE TABLE post_translations (
+    post_id INTEGER NOT NULL,
+    locale TEXT NOT NULL,
+    description TEXT NOT NULL,
+    prompt_version INTEGER,
+    model TEXT,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (post_id, locale),
+    FOREIGN KEY (post_id) REFERENCES posts(id)
+);
+
+INSERT INTO prompts (job, version, template, schema, notes, active, created_at) VALUES (
+    'translate_post',
+    1,
+    'Translate this social media post about a beauty offer from English into {{.Language}}.
+Keep the playful tone and the emojis. Leave hashtags, brand names, prices and coupon codes exactly as they are.
+
+{{.Text}}',
+    '{
+  "type": "object",
+  "properties": {
+    "description": {
+      "type": "string",
+      "description": "The translated post"
+    }
+  },
+  "required": ["description"]
+}',
+    'Original prompt',
+    1,
+    CURRENT_TIMESTAMP
+);
+
+CREATE INDEX posts_percent_off ON posts (percent_off);
+CREATE INDEX posts_ends_at ON posts (ends_at);
+
+CREATE TABLE products (
+    id INTEGER PRIMARY KEY,
+    website_id INTEGER NOT NULL,
+    brand_id INTEGER,
+    name TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    image TEXT NOT NULL DEFAULT '',
+    url TEXT NOT NULL UNIQUE,
+    last_crawled TIMESTAMP,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (website_id) REFERENCES websites(website_id),
+    FOREIGN KEY (brand_id) REFERENCES brands(id)
+);
+
+CREATE INDEX products_due ON products (last_crawled);
+
+CREATE TABLE product_offers (
+    id INTEGER PRIMARY KEY,
+    product_id INTEGER NOT NULL,
+    sku TEXT NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    gtin TEXT,
+    image TEXT NOT NULL DEFAULT '',
+    url TEXT NOT NULL DEFAULT '',
+    price REAL,
+    currency TEXT NOT NULL DEFAULT 'EUR',
+    availability TEXT NOT NULL DEFAULT '',
+    last_seen TIMESTAMP,
+    lowest_90_days INTEGER NOT NULL DEFAULT 0,
+    all_time_low INTEGER NOT NULL DEFAULT 0,
+    catalog_id INTEGER REFERENCES catalog_products(id),
+    UNIQUE (product_id, sku),
+    FOREIGN KEY (product_id) REFERENCES products(id)
+);
+
+CREATE INDEX product_offers_gtin ON product_offers (gtin);
+
+CREATE INDEX product_offers_catalog ON product_offers (catalog_id);
+
+CREATE TABLE catalog_products (
+    id INTEGER PRIMARY KEY,
+    slug TEXT NOT NULL UNIQUE,
+    brand_id INTEGER,
+    name TEXT NOT NULL,
+    size TEXT NOT NULL DEFAULT '',
+    gtin TEXT UNIQUE,
+    image TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (brand_id) REFERENCES brands(id)
+);
+
+CREATE INDEX catalog_products_brand ON catalog_products (brand_id, size);
+
+CREATE TABLE price_points (
+    id INTEGER PRIMARY KEY,
+    offer_id INTEGER NOT NULL,
+    price REAL NOT NULL,
+    currency TEXT NOT NULL,
+    availability TEXT NOT NULL DEFAULT '',
+    observed_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (offer_id) REFERENCES product_offers(id)
+);
+
+CREATE INDEX price_points_offer ON price_points (offer_id, observed_at);
+
+CREATE TABLE price_drops (
+    id INTEGER PRIMARY KEY,
+    offer_id INTEGER NOT NULL,
+    previous_price REAL NOT NULL,
+    reference_price REAL NOT NULL,
+    price REAL NOT NULL,
+    lowest_90_days INTEGER NOT NULL DEFAULT 0,
+    all_time_low INTEGER NOT NULL DEFAULT 0,
+    post_id INTEGER,
+    detected_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (offer_id) REFERENCES product_offers(id),
+    FOREIGN KEY (post_id) REFERENCES posts(id)
+);
+
+CREATE INDEX price_drops_offer ON price_drops (offer_id, detected_at);
+
+CREATE TABLE price_alerts (
+    id INTEGER PRIMARY KEY,
+    subscriber_id INTEGER NOT NULL,
+    product_id INTEGER NOT NULL,
+    target_price REAL,
+    token TEXT NOT NULL UNIQUE,
+    notified_price REAL,
+    notified_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE (subscriber_id, product_id),
+    FOREIGN KEY (subscriber_id) REFERENCES subscribers(id),
+    FOREIGN KEY (product_id) REFERENCES products(id)
+);
+
+CREATE INDEX posts_scored_at ON posts (scored_at);
+
+CREATE TABLE score_weights (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    retailer REAL NOT NULL DEFAULT 1,
+    brand REAL NOT NULL DEFAULT 1,
+    discount REAL NOT NULL DEFAULT 1,
+    recency REAL NOT NULL DEFAULT 1,
+    engagement REAL NOT NULL DEFAULT 1,
+    coupon REAL NOT NULL DEFAULT 1,
+    updated_at TIMESTAMP
+);
+
+INSERT INTO score_weights (id) VALUES (1);
+
+CREATE TABLE clicks (
+    id INTEGER PRIMARY KEY,
+    website_id INTEGER NOT NULL,
+    post_id INTEGER REFERENCES posts(id),
+    coupon_id INTEGER REFERENCES coupon_codes(id),
+    clicked_at TIMESTAMP NOT NULL,
+    product_id INTEGER REFERENCES products(id)
+);
+
+CREATE INDEX clicks_clicked_at ON clicks (clicked_at);
+
+CREATE TABLE affiliate_templates (
+    website_id INTEGER PRIMARY KEY,
+    template TEXT NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE prompt_comparisons (
+    id INTEGER PRIMARY KEY,
+    prompt_id INTEGER NOT NULL,
+    post_id INTEGER NOT NULL,
+    answer TEXT,
+    error TEXT,
+    created_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP,
+    FOREIGN KEY (prompt_id) REFERENCES prompts(id),
+    FOREIGN KEY (post_id) REFERENCES posts(id)
+);
+
+CREATE INDEX prompt_comparisons_prompt ON prompt_comparisons (prompt_id);
+
+CREATE TABLE price_alert_requests (
+    id INTEGER PRIMARY KEY,
+    subscriber_id INTEGER NOT NULL,
+    product_id INTEGER NOT NULL,
+    target_price REAL,
+    token TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE (subscriber_id, product_id),
+    FOREIGN KEY (subscriber_id) REFERENCES subscribers(id),
+    FOREIGN KEY (product_id) REFERENCES products(id)
+);
@@ -0,0 +1,4 @@
+ALTER TABLE posts ADD COLUMN image_hash TEXT;
+ALTER TABLE posts ADD COLUMN image_ext TEXT;
+ALTER TABLE posts ADD COLUMN image_width INTEGER;
+ALTER TABLE posts ADD COLUMN image_height INTEGER;
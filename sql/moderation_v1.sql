@@ -0,0 +1,9 @@
+-- existing posts were published without moderation
+ALTER TABLE posts ADD COLUMN status TEXT NOT NULL DEFAULT 'approved' CHECK (status IN ('pending', 'approved', 'rejected'));
+ALTER TABLE posts ADD COLUMN supporting_text TEXT;
+ALTER TABLE posts ADD COLUMN reviewed_at TIMESTAMP;
+
+-- coupons found before this were not linked to their post
+ALTER TABLE coupon_codes ADD COLUMN post_id INTEGER REFERENCES posts(id);
+
+CREATE INDEX IF NOT EXISTS posts_status_timestamp ON posts (status, timestamp);
@@ -0,0 +1,30 @@
+ALTER TABLE posts ADD COLUMN review_reasons TEXT;
+
+-- alias is matched ignoring case, spacing and punctuation
+CREATE TABLE IF NOT EXISTS aliases (
+    kind TEXT NOT NULL CHECK (kind IN ('brand', 'category')),
+    alias TEXT NOT NULL,
+    canonical TEXT NOT NULL,
+    PRIMARY KEY (kind, alias)
+);
+
+INSERT OR IGNORE INTO aliases (kind, alias, canonical) VALUES
+    ('category', 'skin care', 'Skincare'),
+    ('category', 'skin', 'Skincare'),
+    ('category', 'hair care', 'Haircare'),
+    ('category', 'hair', 'Haircare'),
+    ('category', 'make up', 'Makeup'),
+    ('category', 'cosmetics', 'Makeup'),
+    ('category', 'perfume', 'Fragrance'),
+    ('category', 'perfumes', 'Fragrance'),
+    ('category', 'fragrances', 'Fragrance'),
+    ('category', 'body care', 'Bodycare'),
+    ('category', 'nail care', 'Nails'),
+    ('category', 'nail', 'Nails'),
+    ('category', 'suncare', 'Sun Care'),
+    ('category', 'spf', 'Sun Care'),
+    ('brand', 'loreal', 'L''Oréal Paris'),
+    ('brand', 'loreal paris', 'L''Oréal Paris'),
+    ('brand', 'esteelauder', 'Estée Lauder'),
+    ('brand', 'la roche posay', 'La Roche-Posay'),
+    ('brand', 'ysl', 'Yves Saint Laurent');
@@ -0,0 +1,2 @@
+-- posts written from the banner's text by the ocr fallback, see cmd/server/ocr.go
+ALTER TABLE posts ADD COLUMN machine_extracted INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,13 @@
+ALTER TABLE posts ADD COLUMN image_phash INTEGER;
+
+CREATE TABLE IF NOT EXISTS banner_duplicates (
+    id INTEGER PRIMARY KEY,
+    post_id INTEGER NOT NULL,
+    src_url TEXT NOT NULL,
+    href TEXT,
+    distance INTEGER NOT NULL,
+    timestamp TIMESTAMP NOT NULL,
+    FOREIGN KEY (post_id) REFERENCES posts(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_banner_duplicates_src_url ON banner_duplicates(src_url);
@@ -0,0 +1,14 @@
+-- price alert confirmation, see cmd/server/pricealerts.go
+-- an alert asked for on the price alert form, it only becomes a price_alerts row once the
+-- subscriber follows the link we email them
+CREATE TABLE IF NOT EXISTS price_alert_requests (
+    id INTEGER PRIMARY KEY,
+    subscriber_id INTEGER NOT NULL,
+    product_id INTEGER NOT NULL,
+    target_price REAL,
+    token TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE (subscriber_id, product_id),
+    FOREIGN KEY (subscriber_id) REFERENCES subscribers(id),
+    FOREIGN KEY (product_id) REFERENCES products(id)
+);
@@ -0,0 +1,39 @@
+-- price drop detection and alerts, see cmd/server/pricedrops.go and pricealerts.go
+ALTER TABLE product_offers ADD COLUMN lowest_90_days INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE product_offers ADD COLUMN all_time_low INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE posts ADD COLUMN product_id INTEGER REFERENCES products(id);
+ALTER TABLE posts ADD COLUMN lowest_90_days INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE posts ADD COLUMN all_time_low INTEGER NOT NULL DEFAULT 0;
+
+-- reference_price is the median price of the 90 days before the drop
+CREATE TABLE IF NOT EXISTS price_drops (
+    id INTEGER PRIMARY KEY,
+    offer_id INTEGER NOT NULL,
+    previous_price REAL NOT NULL,
+    reference_price REAL NOT NULL,
+    price REAL NOT NULL,
+    lowest_90_days INTEGER NOT NULL DEFAULT 0,
+    all_time_low INTEGER NOT NULL DEFAULT 0,
+    post_id INTEGER,
+    detected_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (offer_id) REFERENCES product_offers(id),
+    FOREIGN KEY (post_id) REFERENCES posts(id)
+);
+
+CREATE INDEX IF NOT EXISTS price_drops_offer ON price_drops (offer_id, detected_at);
+
+-- a subscriber's alert for a product, target_price NULL alerts on any drop
+CREATE TABLE IF NOT EXISTS price_alerts (
+    id INTEGER PRIMARY KEY,
+    subscriber_id INTEGER NOT NULL,
+    product_id INTEGER NOT NULL,
+    target_price REAL,
+    token TEXT NOT NULL UNIQUE,
+    notified_price REAL,
+    notified_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE (subscriber_id, product_id),
+    FOREIGN KEY (subscriber_id) REFERENCES subscribers(id),
+    FOREIGN KEY (product_id) REFERENCES products(id)
+);
@@ -0,0 +1,2 @@
+-- clicks through /go/offer and /go/product, from the catalog and price alerts, see cmd/server/clicks.go
+ALTER TABLE clicks ADD COLUMN product_id INTEGER REFERENCES products(id);
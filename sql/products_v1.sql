@@ -0,0 +1,49 @@
+-- product pages tracked for their prices, see cmd/server/products.go
+CREATE TABLE IF NOT EXISTS products (
+    id INTEGER PRIMARY KEY,
+    website_id INTEGER NOT NULL,
+    brand_id INTEGER,
+    name TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    image TEXT NOT NULL DEFAULT '',
+    url TEXT NOT NULL UNIQUE,
+    last_crawled TIMESTAMP,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (website_id) REFERENCES websites(website_id),
+    FOREIGN KEY (brand_id) REFERENCES brands(id)
+);
+
+CREATE INDEX IF NOT EXISTS products_due ON products (last_crawled);
+
+-- an offer is a variant of a product, e.g. a shade or size, keyed by its sku
+CREATE TABLE IF NOT EXISTS product_offers (
+    id INTEGER PRIMARY KEY,
+    product_id INTEGER NOT NULL,
+    sku TEXT NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    gtin TEXT,
+    image TEXT NOT NULL DEFAULT '',
+    url TEXT NOT NULL DEFAULT '',
+    price REAL,
+    currency TEXT NOT NULL DEFAULT 'EUR',
+    availability TEXT NOT NULL DEFAULT '',
+    last_seen TIMESTAMP,
+    UNIQUE (product_id, sku),
+    FOREIGN KEY (product_id) REFERENCES products(id)
+);
+
+CREATE INDEX IF NOT EXISTS product_offers_gtin ON product_offers (gtin);
+
+-- one row per offer per crawl, observed_at is in UTC
+CREATE TABLE IF NOT EXISTS price_points (
+    id INTEGER PRIMARY KEY,
+    offer_id INTEGER NOT NULL,
+    price REAL NOT NULL,
+    currency TEXT NOT NULL,
+    availability TEXT NOT NULL DEFAULT '',
+    observed_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (offer_id) REFERENCES product_offers(id)
+);
+
+CREATE INDEX IF NOT EXISTS price_points_offer ON price_points (offer_id, observed_at);
@@ -0,0 +1,6 @@
+ALTER TABLE posts ADD COLUMN first_seen TIMESTAMP;
+ALTER TABLE posts ADD COLUMN last_seen TIMESTAMP;
+ALTER TABLE posts ADD COLUMN active INTEGER NOT NULL DEFAULT 0;
+
+-- existing posts start out as seen once, the next scrape marks the ones still showing as active
+UPDATE posts SET first_seen = timestamp, last_seen = timestamp WHERE first_seen IS NULL;
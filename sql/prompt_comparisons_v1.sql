@@ -0,0 +1,16 @@
+-- a candidate prompt's answers for a sample of stored banners, see cmd/server/prompts.go
+-- rows are queued with no answer and filled in by a background run, finished_at is set either
+-- way and a new run for the prompt replaces its rows
+CREATE TABLE IF NOT EXISTS prompt_comparisons (
+    id INTEGER PRIMARY KEY,
+    prompt_id INTEGER NOT NULL,
+    post_id INTEGER NOT NULL,
+    answer TEXT,
+    error TEXT,
+    created_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP,
+    FOREIGN KEY (prompt_id) REFERENCES prompts(id),
+    FOREIGN KEY (post_id) REFERENCES posts(id)
+);
+
+CREATE INDEX IF NOT EXISTS prompt_comparisons_prompt ON prompt_comparisons (prompt_id);
@@ -0,0 +1,78 @@
+ALTER TABLE posts ADD COLUMN prompt_version INTEGER;
+ALTER TABLE posts ADD COLUMN model TEXT;
+
+CREATE TABLE IF NOT EXISTS prompts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    job TEXT NOT NULL,
+    version INTEGER NOT NULL,
+    template TEXT NOT NULL,
+    schema TEXT NOT NULL,
+    -- model overrides OPENAI_MODEL when set
+    model TEXT,
+    notes TEXT NOT NULL DEFAULT '',
+    active INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE (job, version)
+);
+
+-- version 1 is the prompt that used to be hard coded in analyzeOffer
+INSERT OR IGNORE INTO prompts (job, version, template, schema, notes, active, created_at) VALUES (
+    'extract_offers',
+    1,
+    'You are a joyful and excited social media manager for a health and beauty magazine with the goal of motivating people to take advantage of today''s available beauty offers.
+Tell your audience what the beauty retailer {{.Website}} is advertising today and highlight any coupons if available. Keep your response short, playful and suitable for a tweet or instagram caption.
+Do not acknowledge that you are AI.
+{{- if .SupportingText}}
+For some additional context regarding this promotion please see the quoted text ''{{.SupportingText}}''
+{{- end}}',
+    '{
+  "type": "object",
+  "properties": {
+    "description": {
+      "type": "string",
+      "description": "Text description of the offer"
+    },
+    "coupon_codes": {
+      "type": "array",
+      "description": "Any coupon codes found in the resource",
+      "items": {
+        "type": "object",
+        "properties": {
+          "code": {
+            "type": "string",
+            "description": "The coupon code"
+          },
+          "description": {
+            "type": "string",
+            "description": "Description of the coupon code"
+          },
+          "valid_until": {
+            "type": ["string", "null"],
+            "format": "date-time",
+            "description": "Expiration date of the coupon, if any, in rfc3339 time format"
+          }
+        },
+        "required": ["code", "description"]
+      }
+    },
+    "categories": {
+      "type": "array",
+      "description": "Typical health and beauty categories",
+      "items": {
+        "type": "string"
+      }
+    },
+    "brands": {
+      "type": "array",
+      "description": "Any brands mentioned",
+      "items": {
+        "type": "string"
+      }
+    }
+  },
+  "required": ["description", "coupon_codes", "categories", "brands"]
+}',
+    'Original prompt',
+    1,
+    CURRENT_TIMESTAMP
+);
@@ -0,0 +1,21 @@
+-- post scoring, see cmd/server/scoring.go
+-- clicks counts outbound clicks, scored_at is when score was last worked out and NULL queues
+-- the post for scoring
+ALTER TABLE posts ADD COLUMN clicks INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE posts ADD COLUMN scored_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS posts_scored_at ON posts (scored_at);
+
+-- a single row of weights edited at /admin/scoring
+CREATE TABLE IF NOT EXISTS score_weights (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    retailer REAL NOT NULL DEFAULT 1,
+    brand REAL NOT NULL DEFAULT 1,
+    discount REAL NOT NULL DEFAULT 1,
+    recency REAL NOT NULL DEFAULT 1,
+    engagement REAL NOT NULL DEFAULT 1,
+    coupon REAL NOT NULL DEFAULT 1,
+    updated_at TIMESTAMP
+);
+
+INSERT OR IGNORE INTO score_weights (id) VALUES (1);
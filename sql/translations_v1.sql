@@ -0,0 +1,33 @@
+-- post descriptions translated by the llm, see cmd/server/translations.go
+CREATE TABLE IF NOT EXISTS post_translations (
+    post_id INTEGER NOT NULL,
+    locale TEXT NOT NULL,
+    description TEXT NOT NULL,
+    prompt_version INTEGER,
+    model TEXT,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (post_id, locale),
+    FOREIGN KEY (post_id) REFERENCES posts(id)
+);
+
+INSERT OR IGNORE INTO prompts (job, version, template, schema, notes, active, created_at) VALUES (
+    'translate_post',
+    1,
+    'Translate this social media post about a beauty offer from English into {{.Language}}.
+Keep the playful tone and the emojis. Leave hashtags, brand names, prices and coupon codes exactly as they are.
+
+{{.Text}}',
+    '{
+  "type": "object",
+  "properties": {
+    "description": {
+      "type": "string",
+      "description": "The translated post"
+    }
+  },
+  "required": ["description"]
+}',
+    'Original prompt',
+    1,
+    CURRENT_TIMESTAMP
+);